@@ -4,28 +4,43 @@ package api
 type Action uint8
 
 const (
-	Null          Action = 0
-	ID            Action = 1
-	Dropoff       Action = 2
-	Pickup        Action = 3
-	CID           Action = 16
-	GetContact    Action = 17
-	GetContacts   Action = 18
-	AddContact    Action = 19
-	DeleteContact Action = 20
-	GetChannel    Action = 21
-	GetChannels   Action = 22
-	AddChannel    Action = 23
-	DeleteChannel Action = 24
-	GetProfile    Action = 25
-	GetProfiles   Action = 26
-	AddProfile    Action = 27
-	DeleteProfile Action = 28
-	LoadProfile   Action = 29
-	GetPeer       Action = 30
-	GetPeers      Action = 31
-	AddPeer       Action = 32
-	DeletePeer    Action = 33
-	Send          Action = 34
-	SendChannel   Action = 35
+	Null            Action = 0
+	ID              Action = 1
+	Dropoff         Action = 2
+	Pickup          Action = 3
+	CID             Action = 16
+	GetContact      Action = 17
+	GetContacts     Action = 18
+	AddContact      Action = 19
+	DeleteContact   Action = 20
+	GetChannel      Action = 21
+	GetChannels     Action = 22
+	AddChannel      Action = 23
+	DeleteChannel   Action = 24
+	GetProfile      Action = 25
+	GetProfiles     Action = 26
+	AddProfile      Action = 27
+	DeleteProfile   Action = 28
+	LoadProfile     Action = 29
+	GetPeer         Action = 30
+	GetPeers        Action = 31
+	AddPeer         Action = 32
+	DeletePeer      Action = 33
+	Send            Action = 34
+	SendChannel     Action = 35
+	SetPeerIdentity Action = 37
+	GetConfig       Action = 38
+	SetConfig       Action = 39
+	GetHealth       Action = 40
+	GetOutbox       Action = 41
+	GetOutboxCounts Action = 42
+	DeleteOutbox    Action = 43
+	FetchInbox      Action = 44
+	AckInbox        Action = 45
+	NackInbox       Action = 46
+
+	// public, returns the proof-of-work difficulty required for Dropoff
+	StampDifficulty Action = 4
+	// public, exchanges protocol version and capabilities
+	Version Action = 5
 )
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrPermissionDenied : returned by AdminRPC when a caller's credential does not permit the requested Action
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ReadOnlyActions : admin Actions that do not modify a Node or reveal private keys, suitable for monitoring
+var ReadOnlyActions = []Action{
+	CID, GetContact, GetContacts, GetChannel, GetChannels, GetProfile, GetProfiles, GetPeer, GetPeers, GetConfig, GetHealth, GetOutbox, GetOutboxCounts,
+}
+
+// Authorizer : maps named credentials to roles, and roles to the admin Actions they may call
+// Credentials are matched against RemoteCall.Credential, which the receiving Transport
+// fills in with a bearer token or the fingerprint of the client certificate.
+type Authorizer struct {
+	Roles       map[string][]Action // role name -> permitted Actions
+	Credentials map[string]string   // credential -> role name
+}
+
+// NewAuthorizer : returns an empty Authorizer, which denies everything until roles and credentials are added
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{
+		Roles:       make(map[string][]Action),
+		Credentials: make(map[string]string),
+	}
+}
+
+// AddRole : defines a role permitting the given Actions
+func (a *Authorizer) AddRole(role string, actions ...Action) {
+	a.Roles[role] = actions
+}
+
+// AddCredential : grants a role to a credential
+func (a *Authorizer) AddCredential(credential, role string) {
+	a.Credentials[credential] = role
+}
+
+// Authorize : returns the role of the credential, or ErrPermissionDenied if it may not call the Action
+func (a *Authorizer) Authorize(credential string, action Action) (string, error) {
+	if credential == "" {
+		return "", ErrPermissionDenied
+	}
+	role, ok := a.Credentials[credential]
+	if !ok {
+		return "", ErrPermissionDenied
+	}
+	for _, permitted := range a.Roles[role] {
+		if permitted == action {
+			return role, nil
+		}
+	}
+	return role, ErrPermissionDenied
+}
+
+// Authorizing : implemented by Transports that restrict which admin Actions their callers may use
+type Authorizing interface {
+	// Authorizer : returns the Authorizer for admin calls received by this Transport, or nil for no restrictions
+	Authorizer() *Authorizer
+}
+
+// ParseActions : converts a list of Action names or numbers to Actions,
+// "*" expands to every admin Action, CID and up, and "readonly" to ReadOnlyActions.
+// The public Actions (ID, Dropoff, Pickup, StampDifficulty and Version) are left out of "*",
+// they go through PublicRPC, which an Authorizer never checks.
+func ParseActions(names []string) ([]Action, error) {
+	var actions []Action
+	for _, name := range names {
+		switch name {
+		case "*":
+			for _, a := range actionNames {
+				if a >= CID {
+					actions = append(actions, a)
+				}
+			}
+		case "readonly":
+			actions = append(actions, ReadOnlyActions...)
+		default:
+			a, err := ParseAction(name)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions, nil
+}
+
+// ParseAction : converts an Action name (as in "GetPeers") or number to an Action
+func ParseAction(s string) (Action, error) {
+	if a, ok := actionNames[s]; ok {
+		return a, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return Null, errors.New("Unknown action: " + s)
+	}
+	return Action(n), nil
+}
+
+var actionNames = map[string]Action{
+	"ID": ID, "Dropoff": Dropoff, "Pickup": Pickup, "StampDifficulty": StampDifficulty, "Version": Version,
+	"CID": CID, "GetContact": GetContact, "GetContacts": GetContacts, "AddContact": AddContact, "DeleteContact": DeleteContact,
+	"GetChannel": GetChannel, "GetChannels": GetChannels, "AddChannel": AddChannel, "DeleteChannel": DeleteChannel,
+	"GetProfile": GetProfile, "GetProfiles": GetProfiles, "AddProfile": AddProfile, "DeleteProfile": DeleteProfile,
+	"LoadProfile": LoadProfile, "GetPeer": GetPeer, "GetPeers": GetPeers, "AddPeer": AddPeer, "DeletePeer": DeletePeer,
+	"Send": Send, "SendChannel": SendChannel,
+	"SetPeerIdentity": SetPeerIdentity, "GetConfig": GetConfig, "SetConfig": SetConfig,
+	"GetHealth": GetHealth, "GetOutbox": GetOutbox, "GetOutboxCounts": GetOutboxCounts, "DeleteOutbox": DeleteOutbox,
+	"FetchInbox": FetchInbox, "AckInbox": AckInbox, "NackInbox": NackInbox,
+}
+
+// String : returns the name of an Action
+func (a Action) String() string {
+	for name, action := range actionNames {
+		if action == a {
+			return name
+		}
+	}
+	return strconv.Itoa(int(a))
+}
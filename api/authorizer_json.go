@@ -0,0 +1,61 @@
+// +build !no_json
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// AuthorizerFromMap : Makes an Authorizer from its JSON representation, as decoded into a map by a transport's NewFromMap
+// {"Roles": {"monitor": ["readonly"], "admin": ["*"]}, "Credentials": {"<token or fingerprint>": "monitor"}}
+func AuthorizerFromMap(m map[string]interface{}) (*Authorizer, error) {
+	a := NewAuthorizer()
+	if roles, ok := m["Roles"].(map[string]interface{}); ok {
+		for role, v := range roles {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.New("Invalid action list for role " + role)
+			}
+			var names []string
+			for _, name := range list {
+				s, ok := name.(string)
+				if !ok {
+					return nil, errors.New("Invalid action name for role " + role)
+				}
+				names = append(names, s)
+			}
+			actions, err := ParseActions(names)
+			if err != nil {
+				return nil, err
+			}
+			a.AddRole(role, actions...)
+		}
+	}
+	if creds, ok := m["Credentials"].(map[string]interface{}); ok {
+		for cred, v := range creds {
+			role, ok := v.(string)
+			if !ok {
+				return nil, errors.New("Invalid role for credential")
+			}
+			a.AddCredential(cred, role)
+		}
+	}
+	return a, nil
+}
+
+// MarshalJSON : Create a serialied representation of this Authorizer, with Actions by name
+func (a *Authorizer) MarshalJSON() (b []byte, e error) {
+	roles := make(map[string][]string)
+	for role, actions := range a.Roles {
+		names := []string{}
+		for _, action := range actions {
+			names = append(names, action.String())
+		}
+		roles[role] = names
+	}
+	return json.Marshal(map[string]interface{}{
+		"Roles":       roles,
+		"Credentials": a.Credentials,
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_Authorizer_1(t *testing.T) {
+	auth := NewAuthorizer()
+	auth.AddRole("monitor", ReadOnlyActions...)
+	auth.AddCredential("ops-token", "monitor")
+
+	if role, err := auth.Authorize("ops-token", GetPeers); err != nil || role != "monitor" {
+		t.Fatal("monitor should be allowed GetPeers:", role, err)
+	}
+	if _, err := auth.Authorize("ops-token", DeleteContact); err != ErrPermissionDenied {
+		t.Fatal("monitor should not be allowed DeleteContact:", err)
+	}
+	if _, err := auth.Authorize("unknown", CID); err != ErrPermissionDenied {
+		t.Fatal("unknown credential should be denied:", err)
+	}
+	if _, err := auth.Authorize("", CID); err != ErrPermissionDenied {
+		t.Fatal("empty credential should be denied:", err)
+	}
+}
+
+func Test_ParseActions_Wildcard_1(t *testing.T) {
+	actions, err := ParseActions([]string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := make(map[Action]bool)
+	for _, a := range actions {
+		all[a] = true
+	}
+	// every admin action, and none of the public ones, ID, Dropoff, Pickup, StampDifficulty and Version
+	for name, a := range actionNames {
+		if all[a] != (a >= CID) {
+			t.Fatal("wildcard has the wrong coverage of", name)
+		}
+	}
+}
+
+func Test_Authorizer_JSON_1(t *testing.T) {
+	var m map[string]interface{}
+	config := `{"Roles": {"monitor": ["readonly"], "admin": ["*"], "sender": ["Send", "35"]},
+		"Credentials": {"a": "admin", "m": "monitor", "s": "sender"}}`
+	if err := json.Unmarshal([]byte(config), &m); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := AuthorizerFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authorize("a", AddPeer); err != nil {
+		t.Fatal("admin should be allowed everything:", err)
+	}
+	if _, err := auth.Authorize("a", Dropoff); err == nil {
+		t.Fatal("wildcard should only cover admin actions")
+	}
+	if _, err := auth.Authorize("s", SendChannel); err != nil {
+		t.Fatal("numeric action not parsed:", err)
+	}
+	if _, err := auth.Authorize("m", Send); err == nil {
+		t.Fatal("monitor should not be allowed Send")
+	}
+
+	// round trip
+	b, err := json.Marshal(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = nil
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	auth2, err := AuthorizerFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth2.Authorize("s", Send); err != nil {
+		t.Fatal("round trip lost a permission:", err)
+	}
+
+	if _, err := ParseActions([]string{"NoSuchAction"}); err == nil {
+		t.Fatal("expected an error for an unknown action name")
+	}
+}
@@ -48,14 +48,14 @@ func SendChunked(node api.Node, chunkSize uint32, msg api.Msg) (err error) {
 		}
 		b := bytes.NewBuffer(streamID)                            // StreamID
 		binary.Write(b, binary.LittleEndian, uint32(totalChunks)) // NumChunks
-		if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true, StreamHeader: true}); err != nil {
+		if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Route: msg.Route, Expiry: msg.Expiry, Chunked: true, StreamHeader: true}); err != nil {
 			return
 		}
 		for i := uint32(0); i < wholeLoops; i++ {
 			b := bytes.NewBuffer(streamID)                  // StreamID
 			binary.Write(b, binary.LittleEndian, uint32(i)) // ChunkNum
 			b.Write(buf[i*chunkSizeMinusHeader : (i*chunkSizeMinusHeader)+chunkSizeMinusHeader])
-			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true}); err != nil {
+			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Route: msg.Route, Expiry: msg.Expiry, Chunked: true}); err != nil {
 				return
 			}
 		}
@@ -63,7 +63,7 @@ func SendChunked(node api.Node, chunkSize uint32, msg api.Msg) (err error) {
 			b := bytes.NewBuffer(streamID)                           // StreamID
 			binary.Write(b, binary.LittleEndian, uint32(wholeLoops)) // ChunkNum
 			b.Write(buf[wholeLoops*chunkSizeMinusHeader:])
-			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true}); err != nil {
+			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Route: msg.Route, Expiry: msg.Expiry, Chunked: true}); err != nil {
 				return
 			}
 		}
@@ -71,31 +71,71 @@ func SendChunked(node api.Node, chunkSize uint32, msg api.Msg) (err error) {
 	return
 }
 
+// ChunkHeaderSize - length of the stream ID and chunk number (or total chunks) at the start of every chunked message
+const ChunkHeaderSize = 8
+
+// ParseChunk - splits a chunk into its stream ID, chunk number and data
+func ParseChunk(data []byte) (streamID, chunkNum uint32, chunk []byte, err error) {
+	if len(data) < ChunkHeaderSize {
+		return 0, 0, nil, &api.WireError{Op: "chunk header", Err: api.ErrInputTooShort}
+	}
+	streamID = binary.LittleEndian.Uint32(data)
+	chunkNum = binary.LittleEndian.Uint32(data[4:])
+	return streamID, chunkNum, data[ChunkHeaderSize:], nil
+}
+
+// ParseStreamHeader - reads the stream ID and total number of chunks from a stream header
+func ParseStreamHeader(data []byte) (streamID, totalChunks uint32, err error) {
+	streamID, totalChunks, _, err = ParseChunk(data)
+	if err != nil {
+		return 0, 0, &api.WireError{Op: "stream header", Err: api.ErrInputTooShort}
+	}
+	return streamID, totalChunks, nil
+}
+
+// CheckChunkNum - rejects a chunk number past the end of its stream, or one that was already received.
+// totalChunks is 0 when the stream header hasn't arrived yet, in which case only duplicates are rejected.
+func CheckChunkNum(chunkNum, totalChunks uint32, received bool) error {
+	if totalChunks > 0 && chunkNum >= totalChunks {
+		return &api.WireError{Op: "chunk number", Err: api.ErrChunkRange}
+	}
+	if received {
+		return &api.WireError{Op: "chunk number", Err: api.ErrDuplicateChunk}
+	}
+	return nil
+}
+
 // HandleChunked - shared handler for Nodes that deals with chunks and stream headers
 func HandleChunked(node api.Node, msg api.Msg) error {
 	if !msg.StreamHeader {
 		// save chunk
-		var streamID, chunkNum uint32
 		data, err := ioutil.ReadAll(msg.Content)
 		if err != nil {
 			return err
 		}
-		tmpb := bytes.NewBuffer(data[:8])
-		binary.Read(tmpb, binary.LittleEndian, &streamID)
-		binary.Read(tmpb, binary.LittleEndian, &chunkNum)
-
+		streamID, chunkNum, chunk, err := ParseChunk(data)
+		if err != nil {
+			return err
+		}
 		events.Debug(node, "adding chunk: %x  chunkNum: %x (%d)\n", streamID, chunkNum, chunkNum)
-		return node.AddChunk(streamID, chunkNum, data[8:])
+		return node.AddChunk(streamID, chunkNum, chunk)
 	}
 	// save totalChunks by streamID
-	var streamID, totalChunks uint32
-	tmpb := bytes.NewBuffer(msg.Content.Bytes()[:8])
-	binary.Read(tmpb, binary.LittleEndian, &streamID)
-	binary.Read(tmpb, binary.LittleEndian, &totalChunks)
+	streamID, totalChunks, err := ParseStreamHeader(msg.Content.Bytes())
+	if err != nil {
+		return err
+	}
+	if totalChunks == 0 { // SendChunked never sends an empty stream
+		return &api.WireError{Op: "stream header", Err: api.ErrChunkRange}
+	}
 	channel := ""
 	if msg.IsChan {
 		channel = msg.Name
 	}
 	events.Debug(node, "adding stream: %x  totalChunks: %x (%d)\n", streamID, totalChunks, totalChunks)
-	return node.AddStream(streamID, totalChunks, channel)
+	if err := node.AddStream(streamID, totalChunks, channel); err != nil {
+		return err
+	}
+	events.Emit(node, api.Info, api.StreamStarted, api.StreamEvent{StreamID: streamID, NumChunks: totalChunks, Channel: channel})
+	return nil
 }
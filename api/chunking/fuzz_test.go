@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package chunking
+
+import (
+	"testing"
+)
+
+func FuzzParseChunk(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 4, 0, 0, 0, 0, 'd', 'a', 't', 'a'})
+	f.Add([]byte{1, 2, 3, 4, 5, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _, chunk, err := ParseChunk(data)
+		if err == nil && len(chunk) != len(data)-ChunkHeaderSize {
+			t.Fatal("chunk data length mismatch")
+		}
+		ParseStreamHeader(data)
+	})
+}
+
+func FuzzCheckChunkNum(f *testing.F) {
+	f.Add(uint32(5), uint32(1), false) // stream header of one chunk, followed by chunk five
+	f.Add(uint32(0), uint32(1), true)
+	f.Fuzz(func(t *testing.T, chunkNum, totalChunks uint32, received bool) {
+		err := CheckChunkNum(chunkNum, totalChunks, received)
+		if err == nil && (received || (totalChunks > 0 && chunkNum >= totalChunks)) {
+			t.Fatal("accepted an invalid chunk number")
+		}
+	})
+}
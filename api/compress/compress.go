@@ -0,0 +1,101 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Algorithm : identifies a compression algorithm in the ExtCompression message extension
+type Algorithm byte
+
+const (
+	// None : content is not compressed
+	None Algorithm = 0
+	// Deflate : raw DEFLATE (RFC 1951), the smallest framing
+	Deflate Algorithm = 1
+	// Gzip : gzip (RFC 1952), adds a CRC-32 of the content
+	Gzip Algorithm = 2
+)
+
+// MaxSize : largest decompressed content a node will accept, guards against decompression bombs
+var MaxSize int64 = 16 * 1024 * 1024
+
+var (
+	// ErrUnknownAlgorithm : the algorithm name or identifier is not supported
+	ErrUnknownAlgorithm = errors.New("Unknown compression algorithm")
+	// ErrTooLarge : the decompressed content is larger than the limit
+	ErrTooLarge = errors.New("Decompressed content exceeds limit")
+)
+
+var names = map[string]Algorithm{"": None, "none": None, "deflate": Deflate, "gzip": Gzip}
+
+// ByName : returns the algorithm for a config value, "" or "none" disables compression
+func ByName(name string) (Algorithm, error) {
+	a, ok := names[name]
+	if !ok {
+		return None, ErrUnknownAlgorithm
+	}
+	return a, nil
+}
+
+// Compress : compresses data with the given algorithm
+func Compress(a Algorithm, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch a {
+	case None:
+		return data, nil
+	case Deflate:
+		w, err = flate.NewWriter(&b, flate.BestCompression)
+	case Gzip:
+		w, err = gzip.NewWriterLevel(&b, gzip.BestCompression)
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Decompress : decompresses data with the given algorithm, failing with ErrTooLarge rather than producing more than limit bytes
+func Decompress(a Algorithm, data []byte, limit int64) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch a {
+	case None:
+		if int64(len(data)) > limit {
+			return nil, ErrTooLarge
+		}
+		return data, nil
+	case Deflate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_compress_RoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("The spiders have always been slandered "), 100)
+	for _, name := range []string{"none", "deflate", "gzip"} {
+		a, err := ByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := Compress(a, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != None && len(c) >= len(data) {
+			t.Fatal(name, "did not compress:", len(c))
+		}
+		d, err := Decompress(a, c, MaxSize)
+		if err != nil || !bytes.Equal(d, data) {
+			t.Fatal(name, "did not round trip:", err)
+		}
+	}
+	if _, err := ByName("lzma"); err != ErrUnknownAlgorithm {
+		t.Fatal("expected ErrUnknownAlgorithm, got", err)
+	}
+	if _, err := Decompress(Algorithm(9), data, MaxSize); err != ErrUnknownAlgorithm {
+		t.Fatal("expected ErrUnknownAlgorithm, got", err)
+	}
+}
+
+func Test_compress_Bomb(t *testing.T) {
+	bomb, err := Compress(Deflate, make([]byte, 1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decompress(Deflate, bomb, 64*1024); err != ErrTooLarge {
+		t.Fatal("expected ErrTooLarge, got", err)
+	}
+	if _, err := Decompress(Deflate, bomb[:len(bomb)/2], MaxSize); err == nil {
+		t.Fatal("truncated input accepted")
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "strconv"
+
 // LogLevel - Severity value for events
 type LogLevel int
 
@@ -15,14 +17,78 @@ const (
 // EventType - type of event
 type EventType int
 
-//
+// Log events carry their arguments in Data, the other types carry the payload struct named in their comment
 const (
-	Log EventType = iota
+	Log               EventType = iota
+	MessageDelivered            // MessageEvent, a message was decrypted and put on the Out channel
+	MessageDropped              // DropEvent, a message was discarded
+	StreamStarted               // StreamEvent, the header of a chunked stream was received
+	StreamCompleted             // StreamEvent, a chunked stream was reassembled and put on the Out channel
+	PeerPollSucceeded           // PollEvent, a push/pull with a peer completed
+	PeerPollFailed              // PollEvent, a push/pull with a peer failed
+	DropoffRejected             // DropoffEvent, a bundle delivered by a peer was refused
+	OutboxFlushed               // FlushEvent, old messages were deleted from the outbox
 )
 
+var eventTypeNames = [...]string{"Log", "MessageDelivered", "MessageDropped", "StreamStarted", "StreamCompleted",
+	"PeerPollSucceeded", "PeerPollFailed", "DropoffRejected", "OutboxFlushed"}
+
+// String : returns the name of an EventType
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return strconv.Itoa(int(t))
+	}
+	return eventTypeNames[t]
+}
+
 // Event - Ratnet Events
 type Event struct {
 	Severity LogLevel
 	Type     EventType
 	Data     []interface{}
+	Payload  interface{} // typed payload of events other than Log
+}
+
+// MessageEvent - payload of MessageDelivered
+type MessageEvent struct {
+	Channel string // channel name, "" for a message to the content key
+	Size    int    // bytes of content
+}
+
+// Reasons for MessageDropped
+const (
+	DropHopLimit = "hop limit reached"
+	DropOutFull  = "Out channel full"
+	DropInbox    = "inbox write failed"
+	DropOnion    = "onion layer for another relay"
+)
+
+// DropEvent - payload of MessageDropped
+type DropEvent struct {
+	Channel string // channel name, "" for a message to the content key
+	Reason  string // DropHopLimit, DropOutFull, DropInbox, DropOnion
+}
+
+// StreamEvent - payload of StreamStarted and StreamCompleted
+type StreamEvent struct {
+	StreamID  uint32
+	NumChunks uint32
+	Channel   string // channel name, "" for a stream to the content key
+}
+
+// PollEvent - payload of PeerPollSucceeded and PeerPollFailed
+type PollEvent struct {
+	Host string
+	Err  error // nil if the poll succeeded
+}
+
+// DropoffEvent - payload of DropoffRejected
+type DropoffEvent struct {
+	Size int // bytes of bundle data
+	Err  error
+}
+
+// FlushEvent - payload of OutboxFlushed
+type FlushEvent struct {
+	MaxAge int64 // seconds, older messages were deleted
 }
@@ -0,0 +1,6 @@
+// +build debug
+
+package api
+
+// DefaultLogThreshold - severity below which an EventBus discards log events until SetThreshold is called, debug builds log everything
+const DefaultLogThreshold = Info
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventFilter : selects the events a Subscription receives, the zero value selects all of them
+type EventFilter struct {
+	MinSeverity LogLevel    // leave out events below this severity
+	Types       []EventType // only these types, empty for all types
+}
+
+// Match : returns true if the filter selects the event
+func (f EventFilter) Match(e Event) bool {
+	if e.Severity < f.MinSeverity {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// EventBus : fans the events of a node out to any number of subscribers, each with its own buffer.
+// Publish never blocks, when a subscriber falls behind its oldest buffered event is dropped.
+type EventBus struct {
+	mu        sync.RWMutex
+	subs      map[*Subscription]struct{}
+	closed    bool
+	dropped   uint64
+	threshold int32
+}
+
+// NewEventBus : returns an EventBus without subscribers, logging at DefaultLogThreshold
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{}), threshold: int32(DefaultLogThreshold)}
+}
+
+// Threshold : returns the lowest severity of log events the bus publishes
+func (b *EventBus) Threshold() LogLevel {
+	return LogLevel(atomic.LoadInt32(&b.threshold))
+}
+
+// SetThreshold : sets the lowest severity of log events the bus publishes, typed events are always published
+func (b *EventBus) SetThreshold(level LogLevel) {
+	atomic.StoreInt32(&b.threshold, int32(level))
+}
+
+// Subscribe : returns a Subscription to the events selected by filter, buffering up to size of them
+func (b *EventBus) Subscribe(size int, filter EventFilter) *Subscription {
+	if size < 1 {
+		size = 1
+	}
+	s := &Subscription{bus: b, filter: filter, c: make(chan Event, size)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.c)
+		s.closed = true
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish : delivers an event to every subscriber whose filter selects it, without blocking,
+// log events below the threshold are discarded
+func (b *EventBus) Publish(e Event) {
+	if e.Type == Log && e.Severity < b.Threshold() {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.filter.Match(e) {
+			s.send(e)
+		}
+	}
+}
+
+// Dropped : returns how many events were dropped across all subscribers
+func (b *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Close : closes every subscription, later events are discarded
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		s.close()
+		delete(b.subs, s)
+	}
+}
+
+// Subscription : a subscriber's buffered view of an EventBus
+type Subscription struct {
+	bus     *EventBus
+	filter  EventFilter
+	c       chan Event
+	mu      sync.Mutex
+	closed  bool
+	dropped uint64
+}
+
+// Events : returns the channel the subscriber receives events on, it is closed by Close
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped : returns how many events were dropped because this subscriber fell behind
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close : removes the subscription from its EventBus and closes its channel
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	delete(s.bus.subs, s)
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+// send - buffers an event, dropping the oldest buffered event to make room if needed
+func (s *Subscription) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for {
+		select {
+		case s.c <- e:
+			return
+		default:
+		}
+		select {
+		case <-s.c:
+			atomic.AddUint64(&s.dropped, 1)
+			atomic.AddUint64(&s.bus.dropped, 1)
+		default:
+		}
+	}
+}
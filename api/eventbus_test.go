@@ -0,0 +1,81 @@
+package api
+
+import "testing"
+
+func Test_EventBus_FanOut(t *testing.T) {
+	bus := NewEventBus()
+	bus.SetThreshold(Info)
+	all := bus.Subscribe(8, EventFilter{})
+	warnings := bus.Subscribe(8, EventFilter{MinSeverity: Warning})
+	polls := bus.Subscribe(8, EventFilter{Types: []EventType{PeerPollFailed}})
+
+	bus.Publish(Event{Severity: Info, Type: Log})
+	bus.Publish(Event{Severity: Warning, Type: PeerPollFailed})
+	bus.Publish(Event{Severity: Error, Type: DropoffRejected})
+
+	for _, c := range []struct {
+		sub  *Subscription
+		want int
+	}{{all, 3}, {warnings, 2}, {polls, 1}} {
+		if n := len(c.sub.Events()); n != c.want {
+			t.Fatalf("subscriber got %d events, want %d", n, c.want)
+		}
+	}
+	if e := <-polls.Events(); e.Type != PeerPollFailed {
+		t.Fatal("type filter passed", e.Type)
+	}
+}
+
+func Test_EventBus_DropOldest(t *testing.T) {
+	bus := NewEventBus()
+	bus.SetThreshold(Info)
+	slow := bus.Subscribe(2, EventFilter{})
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Data: []interface{}{i}})
+	}
+	if slow.Dropped() != 3 || bus.Dropped() != 3 {
+		t.Fatal("wrong dropped counts:", slow.Dropped(), bus.Dropped())
+	}
+	for _, want := range []int{3, 4} {
+		if e := <-slow.Events(); e.Data[0].(int) != want {
+			t.Fatal("kept event", e.Data[0], "want", want)
+		}
+	}
+}
+
+func Test_EventBus_Close(t *testing.T) {
+	bus := NewEventBus()
+	a := bus.Subscribe(1, EventFilter{})
+	b := bus.Subscribe(1, EventFilter{})
+	a.Close()
+	if _, ok := <-a.Events(); ok {
+		t.Fatal("closed subscription still open")
+	}
+	bus.Publish(Event{Type: MessageDelivered})
+	bus.Close()
+	bus.Publish(Event{Type: MessageDelivered}) // must not panic
+	if _, ok := <-b.Events(); !ok {
+		t.Fatal("event published before Close was lost")
+	}
+	if _, ok := <-b.Events(); ok {
+		t.Fatal("subscription open after bus Close")
+	}
+	if _, ok := <-bus.Subscribe(1, EventFilter{}).Events(); ok {
+		t.Fatal("subscription to a closed bus is open")
+	}
+}
+
+func Test_EventBus_Threshold(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe(8, EventFilter{})
+	bus.SetThreshold(Error)
+	bus.Publish(Event{Severity: Warning, Type: Log})
+	bus.Publish(Event{Severity: Info, Type: MessageDelivered})
+	bus.Publish(Event{Severity: Error, Type: Log})
+	if n := len(sub.Events()); n != 2 {
+		t.Fatal("threshold let through", n, "events, want 2")
+	}
+	if e := <-sub.Events(); e.Type != MessageDelivered {
+		t.Fatal("typed event below the threshold was discarded")
+	}
+}
@@ -5,29 +5,35 @@ import (
 	"github.com/fatih/color"
 )
 
-// StartDefaultLogger - Prints events above the given threshold using log
+// LogBufferSize - events the logger buffers before it drops the oldest
+var LogBufferSize = 256
+
+// StartDefaultLogger - Prints events above the given threshold using log, typed events as their type and payload.
+// The logger has its own subscription to the node's EventBus, so it does not consume node.Events().
 func StartDefaultLogger(node api.Node, logLevel api.LogLevel) {
+	sub := node.EventBus().Subscribe(LogBufferSize, api.EventFilter{MinSeverity: logLevel})
 	go func() {
-		for event := range node.Events() {
-			//	event := <-node.Events()
-			if event.Severity >= logLevel && event.Type == api.Log {
-				switch event.Severity {
-				case api.Info:
-					c := color.New(color.FgCyan)
-					c.Printf("%+v\n", event.Data)
-				case api.Debug:
-					c := color.New(color.FgBlue)
-					c.Printf("%+v\n", event.Data)
-				case api.Warning:
-					c := color.New(color.FgYellow)
-					c.Printf("%+v\n", event.Data)
-				case api.Error:
-					c := color.New(color.FgRed)
-					c.Printf("%+v\n", event.Data)
-				case api.Critical:
-					c := color.New(color.FgRed).Add(color.Bold).Add(color.Underline)
-					c.Printf("%+v\n", event.Data)
-				}
+		for event := range sub.Events() {
+			data := event.Data
+			if event.Type != api.Log {
+				data = []interface{}{event.Type, event.Payload}
+			}
+			switch event.Severity {
+			case api.Info:
+				c := color.New(color.FgCyan)
+				c.Printf("%+v\n", data)
+			case api.Debug:
+				c := color.New(color.FgBlue)
+				c.Printf("%+v\n", data)
+			case api.Warning:
+				c := color.New(color.FgYellow)
+				c.Printf("%+v\n", data)
+			case api.Error:
+				c := color.New(color.FgRed)
+				c.Printf("%+v\n", data)
+			case api.Critical:
+				c := color.New(color.FgRed).Add(color.Bold).Add(color.Underline)
+				c.Printf("%+v\n", data)
 			}
 		}
 	}()
@@ -0,0 +1,37 @@
+package events
+
+import "github.com/awgh/ratnet/api"
+
+// Emit - Publishes a typed event on the node's EventBus, in release and debug builds alike
+func Emit(node api.Node, severity api.LogLevel, eventType api.EventType, payload interface{}) {
+	if !node.IsRunning() {
+		return
+	}
+	node.EventBus().Publish(api.Event{Severity: severity, Type: eventType, Payload: payload})
+}
+
+// Delivered - Emits MessageDelivered for a message put on the node's Out channel
+func Delivered(node api.Node, msg api.Msg) {
+	e := api.MessageEvent{Channel: channelName(msg)}
+	if msg.Content != nil {
+		e.Size = msg.Content.Len()
+	}
+	Emit(node, api.Info, api.MessageDelivered, e)
+}
+
+// Dropped - Emits MessageDropped for a message the node discarded
+func Dropped(node api.Node, msg api.Msg, reason string) {
+	Emit(node, api.Warning, api.MessageDropped, api.DropEvent{Channel: channelName(msg), Reason: reason})
+}
+
+func channelName(msg api.Msg) string {
+	if msg.IsChan {
+		return msg.Name
+	}
+	return ""
+}
+
+// Rejected - Emits DropoffRejected for a bundle the node refused
+func Rejected(node api.Node, bundle api.Bundle, err error) {
+	Emit(node, api.Warning, api.DropoffRejected, api.DropoffEvent{Size: len(bundle.Data), Err: err})
+}
@@ -0,0 +1,43 @@
+package events
+
+import "github.com/awgh/ratnet/api"
+
+// Info - Informational messages (1)
+func Info(node api.Node, args ...interface{}) {
+	logEvent(node, api.Info, args)
+}
+
+// Debug - Debug messages (2)
+func Debug(node api.Node, args ...interface{}) {
+	logEvent(node, api.Debug, args)
+}
+
+// Warning - Warning messages (3)
+func Warning(node api.Node, args ...interface{}) {
+	logEvent(node, api.Warning, args)
+}
+
+// Error - Error messages (4)
+func Error(node api.Node, args ...interface{}) {
+	logEvent(node, api.Error, args)
+}
+
+// Critical - Critical error messages (5), they panic in release builds
+func Critical(node api.Node, args ...interface{}) {
+	logEvent(node, api.Critical, args)
+	if panicOnCritical {
+		panic(args)
+	}
+}
+
+// logEvent - publishes a log event, unless it is below the threshold of the node's EventBus
+func logEvent(node api.Node, severity api.LogLevel, args []interface{}) {
+	if !node.IsRunning() {
+		return
+	}
+	bus := node.EventBus()
+	if severity < bus.Threshold() {
+		return
+	}
+	bus.Publish(api.Event{Severity: severity, Type: api.Log, Data: args})
+}
@@ -0,0 +1,66 @@
+//go:build go1.21
+// +build go1.21
+
+package sloglogger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// LogBufferSize - events the logger buffers before it drops the oldest
+var LogBufferSize = 256
+
+// LevelCritical - slog level of Critical events, above slog.LevelError
+const LevelCritical = slog.LevelError + 4
+
+// Level - returns the slog level of an event severity
+func Level(severity api.LogLevel) slog.Level {
+	switch severity {
+	case api.Info:
+		return slog.LevelInfo
+	case api.Debug:
+		return slog.LevelDebug
+	case api.Warning:
+		return slog.LevelWarn
+	case api.Error:
+		return slog.LevelError
+	default:
+		return LevelCritical
+	}
+}
+
+// Record - returns an slog record for an event, log events use their data as the message,
+// typed events use their type as the message and carry their payload in the "payload" attribute
+func Record(event api.Event) slog.Record {
+	msg := event.Type.String()
+	if event.Type == api.Log {
+		msg = fmt.Sprint(event.Data...)
+	}
+	r := slog.NewRecord(time.Now(), Level(event.Severity), msg, 0)
+	r.AddAttrs(slog.String("type", event.Type.String()))
+	if event.Payload != nil {
+		r.AddAttrs(slog.Any("payload", event.Payload))
+	}
+	return r
+}
+
+// StartSlogLogger - Sends events at or above the given severity to an slog.Handler,
+// through its own subscription to the node's EventBus. Close the subscription to stop it.
+func StartSlogLogger(node api.Node, handler slog.Handler, logLevel api.LogLevel) *api.Subscription {
+	sub := node.EventBus().Subscribe(LogBufferSize, api.EventFilter{MinSeverity: logLevel})
+	go func() {
+		ctx := context.Background()
+		for event := range sub.Events() {
+			r := Record(event)
+			if handler.Enabled(ctx, r.Level) {
+				_ = handler.Handle(ctx, r)
+			}
+		}
+	}()
+	return sub
+}
@@ -0,0 +1,66 @@
+//go:build go1.21
+// +build go1.21
+
+package sloglogger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_slog(t *testing.T) {
+	node := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	node.EventBus().SetThreshold(api.Info)
+
+	out := new(syncBuffer)
+	sub := StartSlogLogger(node, slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}), api.Debug)
+	defer sub.Close()
+
+	events.Info(node, "not logged") // below the logger's level
+	events.Warning(node, "peer ", "gone")
+	events.Emit(node, api.Warning, api.PeerPollFailed, api.PollEvent{Host: "example"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(out.String(), "\n") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s := out.String()
+	for _, want := range []string{`"level":"WARN","msg":"peer gone","type":"Log"`, `"msg":"PeerPollFailed"`, `"Host":"example"`} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("slog output missing %s:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "not logged") {
+		t.Fatal("event below the logger's level was logged")
+	}
+}
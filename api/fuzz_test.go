@@ -0,0 +1,101 @@
+//go:build go1.18
+// +build go1.18
+
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awgh/bencrypt/ecc"
+)
+
+// seed values shared by the decoder fuzz targets, more are in testdata/fuzz
+func fuzzSeeds() [][]byte {
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	return [][]byte{
+		ArgsToBytes([]interface{}{int64(1), "two", []byte{3}, [][]byte{{4}, {5}}}),
+		ArgsToBytes([]interface{}{key.GetPubKey(), Bundle{Data: []byte("data"), Time: 1, Stamp: []byte("stamp")}}),
+		ArgsToBytes([]interface{}{[]Peer{{Name: "p", URI: "u", Fingerprint: "f"}}, &Contact{Name: "c"}}),
+		*RemoteCallToBytes(&RemoteCall{Action: Pickup, Args: []interface{}{key.GetPubKey(), int64(0)}}),
+		*RemoteResponseToBytes(&RemoteResponse{Error: "err", Value: []Channel{{Name: "ch"}}}),
+		*BytesBytesToBytes(&[][]byte{[]byte("one"), []byte("two")}),
+	}
+}
+
+func FuzzReadBuffer(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		var b bytes.Buffer
+		WriteBuffer(&b, &s)
+		f.Add(b.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadBuffer(bytes.NewReader(data))
+	})
+}
+
+func FuzzArgsFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		args, err := ArgsFromBytes(data)
+		if err != nil {
+			return
+		}
+		// anything that decodes must survive a round trip
+		if _, err := ArgsFromBytes(ArgsToBytes(args)); err != nil {
+			t.Fatal("round trip failed:", err)
+		}
+	})
+}
+
+func FuzzRemoteCallFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		RemoteCallFromBytes(&data)
+	})
+}
+
+func FuzzRemoteResponseFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		RemoteResponseFromBytes(&data)
+	})
+}
+
+func FuzzBytesBytesFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		BytesBytesFromBytes(&data)
+	})
+}
+
+func FuzzParseMsgHeader(f *testing.F) {
+	f.Add([]byte{ChannelFlag, 0, 3, 'a', 'b', 'c', 1, 2, 3})
+	f.Add([]byte{ChunkedFlag | StreamHeaderFlag, 1, 2, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := ParseMsgHeader(data)
+		if err == nil && h.Size > len(data) {
+			t.Fatal("header longer than the message")
+		}
+	})
+}
+
+func FuzzDecryptMessage(f *testing.F) {
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	cipher, _ := key.EncryptMessage([]byte("hello"), key.GetPubKey())
+	f.Add(cipher)
+	f.Add(make([]byte, eccOverhead))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecryptMessage(key, data)
+	})
+}
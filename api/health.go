@@ -0,0 +1,188 @@
+package api
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HealthState : how well a node or one of its components is working
+type HealthState int
+
+// HealthUnknown is the zero value, HealthDown the worst
+const (
+	HealthUnknown  HealthState = iota // nothing reported yet, e.g. a Poll policy that has not polled
+	HealthOK                          // listening, or the last operation succeeded
+	HealthDegraded                    // working, but the last operation failed
+	HealthDown                        // not working, e.g. a transport that could not listen
+)
+
+var healthStateNames = [...]string{"unknown", "ok", "degraded", "down"}
+
+// String : returns the name of a HealthState
+func (s HealthState) String() string {
+	if s < 0 || int(s) >= len(healthStateNames) {
+		return strconv.Itoa(int(s))
+	}
+	return healthStateNames[s]
+}
+
+// MarshalText : encodes a HealthState as its name
+func (s HealthState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ComponentHealth : the status a policy or transport reports
+type ComponentHealth struct {
+	Name        string
+	State       HealthState
+	Listening   string    // address the component accepts connections on, "" if it does not
+	LastSuccess time.Time // last successful poll or call, zero if there was none
+	Error       string    // why the component is degraded or down
+}
+
+// HealthReporter : implemented by Policies and Transports that report their status
+type HealthReporter interface {
+	Health() ComponentHealth
+}
+
+// Health : the status of a node, aggregated from its policies and their transports
+type Health struct {
+	State      HealthState // worst state of the components, HealthDown if the node is not running
+	Running    bool
+	Ready      bool // running, and every component is HealthOK
+	Components []ComponentHealth
+}
+
+// NewHealth : aggregates the status of a node's components, components that have not reported
+// yet do not make a node unhealthy, but it is not ready until they report HealthOK
+func NewHealth(running bool, components ...ComponentHealth) Health {
+	h := Health{State: HealthOK, Running: running, Ready: running, Components: components}
+	for _, c := range components {
+		if c.State > h.State {
+			h.State = c.State
+		}
+		if c.State != HealthOK {
+			h.Ready = false
+		}
+	}
+	if !running {
+		h.State = HealthDown
+	}
+	return h
+}
+
+// HealthTracker : records what a component did, for its Health method
+type HealthTracker struct {
+	mu          sync.Mutex
+	listening   string
+	down        error
+	lastSuccess time.Time
+	err         error
+}
+
+// Listening : records that the component accepts connections on addr
+func (t *HealthTracker) Listening(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listening, t.down = addr, nil
+}
+
+// ListenFailed : records that the component could not listen, which leaves it down
+func (t *HealthTracker) ListenFailed(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listening, t.down = "", err
+}
+
+// Stopped : forgets everything recorded so far
+func (t *HealthTracker) Stopped() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listening, t.down, t.lastSuccess, t.err = "", nil, time.Time{}, nil
+}
+
+// Record : records the result of a poll or call, a failure leaves the component degraded until the next success
+func (t *HealthTracker) Record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.lastSuccess = time.Now()
+	}
+	t.err = err
+}
+
+// Report : returns the status of the component, under the given name
+func (t *HealthTracker) Report(name string) ComponentHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := ComponentHealth{Name: name, Listening: t.listening, LastSuccess: t.lastSuccess}
+	switch {
+	case t.down != nil:
+		c.State, c.Error = HealthDown, t.down.Error()
+	case t.err != nil:
+		c.State, c.Error = HealthDegraded, t.err.Error()
+	case t.listening != "" || !t.lastSuccess.IsZero():
+		c.State = HealthOK
+	}
+	return c
+}
+
+// HealthToArgs : encodes a Health as the result of the GetHealth action
+func HealthToArgs(h Health) []interface{} {
+	args := []interface{}{int64(h.State), boolToInt64(h.Running), boolToInt64(h.Ready)}
+	for _, c := range h.Components {
+		var last int64
+		if !c.LastSuccess.IsZero() {
+			last = c.LastSuccess.UnixNano()
+		}
+		args = append(args, []interface{}{c.Name, int64(c.State), c.Listening, last, c.Error})
+	}
+	return args
+}
+
+// HealthFromArgs : decodes the result of the GetHealth action
+func HealthFromArgs(args []interface{}) (Health, error) {
+	var h Health
+	if len(args) < 3 {
+		return h, &WireError{Op: "health", Err: ErrInputTooShort}
+	}
+	state, ok1 := args[0].(int64)
+	running, ok2 := args[1].(int64)
+	ready, ok3 := args[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return h, &WireError{Op: "health", Err: ErrUnexpectedType}
+	}
+	h.State, h.Running, h.Ready = HealthState(state), running != 0, ready != 0
+	for _, a := range args[3:] {
+		fields, ok := a.([]interface{})
+		if !ok {
+			return h, &WireError{Op: "health component", Err: ErrUnexpectedType}
+		}
+		if len(fields) < 5 {
+			return h, &WireError{Op: "health component", Err: ErrInputTooShort}
+		}
+		name, ok1 := fields[0].(string)
+		cstate, ok2 := fields[1].(int64)
+		listening, ok3 := fields[2].(string)
+		last, ok4 := fields[3].(int64)
+		errString, ok5 := fields[4].(string)
+		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+			return h, &WireError{Op: "health component", Err: ErrUnexpectedType}
+		}
+		c := ComponentHealth{Name: name, Listening: listening, Error: errString}
+		c.State = HealthState(cstate)
+		if last != 0 {
+			c.LastSuccess = time.Unix(0, last)
+		}
+		h.Components = append(h.Components, c)
+	}
+	return h, nil
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Health_Aggregate(t *testing.T) {
+	var listener, poller HealthTracker
+	if c := poller.Report("poll"); c.State != HealthUnknown {
+		t.Fatal("tracker without reports is not unknown:", c.State)
+	}
+	listener.Listening("127.0.0.1:20001")
+
+	h := NewHealth(true, listener.Report("tls"), poller.Report("poll"))
+	if h.State != HealthOK || h.Ready {
+		t.Fatalf("unreported component should leave the node healthy but not ready: %+v", h)
+	}
+
+	poller.Record(errors.New("connection refused"))
+	h = NewHealth(true, listener.Report("tls"), poller.Report("poll"))
+	if h.State != HealthDegraded || h.Components[1].Error != "connection refused" {
+		t.Fatalf("failed poll not reported: %+v", h)
+	}
+
+	poller.Record(nil)
+	h = NewHealth(true, listener.Report("tls"), poller.Report("poll"))
+	if h.State != HealthOK || !h.Ready || h.Components[1].LastSuccess.IsZero() {
+		t.Fatalf("successful poll not reported: %+v", h)
+	}
+
+	listener.ListenFailed(errors.New("address in use"))
+	if h = NewHealth(true, listener.Report("tls"), poller.Report("poll")); h.State != HealthDown {
+		t.Fatalf("failed listener not reported: %+v", h)
+	}
+	if h = NewHealth(false); h.State != HealthDown || h.Ready {
+		t.Fatalf("stopped node reported healthy: %+v", h)
+	}
+}
+
+func Test_Health_Args(t *testing.T) {
+	var poller HealthTracker
+	poller.Record(nil)
+	want := NewHealth(true, ComponentHealth{Name: "tls", State: HealthDown, Error: "address in use"}, poller.Report("poll"))
+
+	rr := RemoteResponse{Value: HealthToArgs(want)}
+	resp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&rr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := HealthFromArgs(resp.Value.([]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != want.State || got.Running != want.Running || got.Ready != want.Ready || len(got.Components) != 2 {
+		t.Fatalf("health changed on the wire: %+v", got)
+	}
+	for i, c := range got.Components {
+		w := want.Components[i]
+		if c.Name != w.Name || c.State != w.State || c.Error != w.Error || !c.LastSuccess.Equal(w.LastSuccess) {
+			t.Fatalf("component changed on the wire: %+v, want %+v", c, w)
+		}
+	}
+	if _, err := HealthFromArgs([]interface{}{int64(1)}); err == nil {
+		t.Fatal("short health accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"time"
+)
+
+// ConfigInbox - config name for keeping received messages in the node's inbox until the application acknowledges them,
+// instead of sending them to Out(), "true" enables
+const ConfigInbox = "inbox"
+
+// DefaultInboxLease : seconds a fetched message is hidden from FetchInbox before it is delivered again, if it is not acknowledged
+var DefaultInboxLease int64 = 30
+
+// InboxMsg : a received message waiting in a node's inbox
+type InboxMsg struct {
+	ID       int64  `db:"msgid"`  // passed to AckInbox and NackInbox
+	Name     string `db:"name"`   // like Msg.Name
+	IsChan   bool   `db:"ischan"` // like Msg.IsChan
+	Content  []byte `db:"content"`
+	Received int64  `db:"received"` // unix nanoseconds
+	Attempts int64  `db:"attempts"` // how many times FetchInbox returned the message
+	Lease    int64  `db:"lease"`    // unix nanoseconds until which the message is hidden from FetchInbox, 0 if it is not leased
+}
+
+// Msg : returns the message as it would have been sent to Out()
+func (m InboxMsg) Msg() Msg {
+	return Msg{Name: m.Name, IsChan: m.IsChan, Content: bytes.NewBuffer(m.Content)}
+}
+
+// Leased : reports whether the message is hidden from FetchInbox at now, in unix nanoseconds
+func (m InboxMsg) Leased(now int64) bool {
+	return m.Lease > now
+}
+
+// InboxLease : returns the end of a lease of leaseSeconds starting at now, in unix nanoseconds, 0 seconds uses DefaultInboxLease
+func InboxLease(now, leaseSeconds int64) int64 {
+	if leaseSeconds <= 0 {
+		leaseSeconds = DefaultInboxLease
+	}
+	return now + leaseSeconds*int64(time.Second)
+}
+
+// InboxMsgToArgs : encodes an inbox message
+func InboxMsgToArgs(m InboxMsg) []interface{} {
+	return []interface{}{m.ID, m.Name, boolToInt64(m.IsChan), m.Content, m.Received, m.Attempts, m.Lease}
+}
+
+// InboxMsgFromArgs : decodes an inbox message
+func InboxMsgFromArgs(a interface{}) (InboxMsg, error) {
+	var m InboxMsg
+	fields, ok := a.([]interface{})
+	if !ok {
+		return m, &WireError{Op: "inbox message", Err: ErrUnexpectedType}
+	}
+	if len(fields) < 7 {
+		return m, &WireError{Op: "inbox message", Err: ErrInputTooShort}
+	}
+	var isChan int64
+	var ok1, ok2, ok3, ok4, ok5, ok6, ok7 bool
+	m.ID, ok1 = fields[0].(int64)
+	m.Name, ok2 = fields[1].(string)
+	isChan, ok3 = fields[2].(int64)
+	m.Content, ok4 = fields[3].([]byte)
+	m.Received, ok5 = fields[4].(int64)
+	m.Attempts, ok6 = fields[5].(int64)
+	m.Lease, ok7 = fields[6].(int64)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 || !ok7 {
+		return m, &WireError{Op: "inbox message", Err: ErrUnexpectedType}
+	}
+	m.IsChan = isChan != 0
+	return m, nil
+}
+
+// InboxMsgsToArgs : encodes inbox messages as the result of the FetchInbox action
+func InboxMsgsToArgs(msgs []InboxMsg) []interface{} {
+	args := make([]interface{}, 0, len(msgs))
+	for _, m := range msgs {
+		args = append(args, InboxMsgToArgs(m))
+	}
+	return args
+}
+
+// InboxMsgsFromArgs : decodes the result of the FetchInbox action
+func InboxMsgsFromArgs(args []interface{}) ([]InboxMsg, error) {
+	var msgs []InboxMsg
+	for _, a := range args {
+		m, err := InboxMsgFromArgs(a)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,26 @@
+package api
+
+import "testing"
+
+func Test_Inbox_Args(t *testing.T) {
+	msgs := []InboxMsg{{ID: 1, Name: "chan1", IsChan: true, Content: []byte("hi"), Received: 2, Attempts: 1, Lease: 3}, {ID: 4, Name: "[content]", Content: []byte{}}}
+	rr := RemoteResponse{Value: InboxMsgsToArgs(msgs)}
+	resp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&rr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := InboxMsgsFromArgs(resp.Value.([]interface{}))
+	if err != nil || len(got) != 2 || got[0].ID != 1 || got[0].Name != "chan1" || !got[0].IsChan || string(got[0].Content) != "hi" ||
+		got[0].Received != 2 || got[0].Attempts != 1 || got[0].Lease != 3 || got[1].IsChan || got[1].ID != 4 {
+		t.Fatalf("inbox messages changed on the wire: %+v %v", got, err)
+	}
+	if m := got[0].Msg(); m.Name != "chan1" || !m.IsChan || m.Content.String() != "hi" {
+		t.Fatalf("wrong Msg: %+v", m)
+	}
+	if !got[0].Leased(2) || got[0].Leased(3) {
+		t.Fatal("wrong lease check")
+	}
+	if _, err := InboxMsgsFromArgs([]interface{}{[]interface{}{int64(1), "a"}}); err == nil {
+		t.Fatal("short inbox message accepted")
+	}
+}
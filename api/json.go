@@ -28,6 +28,7 @@ type ExportedNode struct {
 	Channels []ChannelPrivB64
 	Peers    []Peer
 	Contacts []Contact
+	Config   []ConfigValue
 	Router   Router
 }
 
@@ -43,5 +44,6 @@ type ImportedNode struct {
 	Channels []ChannelPrivB64
 	Peers    []Peer
 	Contacts []Contact
+	Config   []ConfigValue
 	Router   map[string]interface{}
 }
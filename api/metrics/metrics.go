@@ -0,0 +1,298 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of metric
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// DefaultBuckets : histogram upper bounds for durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets : histogram upper bounds for sizes in bytes
+var SizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+// Registry : a set of named metrics, each with any number of labelled series,
+// that can be written out in the Prometheus text exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	name, help, kind string
+	buckets          []float64
+	series           map[string]*series
+}
+
+type series struct {
+	labels []string // name, value pairs
+	value  float64
+	fn     func() float64 // gauge computed when the registry is written, instead of value
+	counts []uint64       // histogram, per bucket
+	sum    float64
+	count  uint64
+}
+
+// NewRegistry : returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default : the registry nodes, policies, routers and transports update
+var Default = NewRegistry()
+
+// Describe : declares a metric with its kind and help text, buckets are the upper bounds of a histogram
+func (r *Registry) Describe(name, kind, help string, buckets ...float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, kind)
+	f.kind, f.help = kind, help
+	if len(buckets) > 0 {
+		f.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(f.buckets)
+	}
+}
+
+// Add : adds v to a counter or gauge series, labels are name, value pairs
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.family(name, Counter).get(labels).value += v
+}
+
+// Set : sets a counter or gauge series to v, labels are name, value pairs
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.family(name, Gauge).get(labels)
+	s.value, s.fn = v, nil
+}
+
+// SetFunc : makes a gauge series call fn for its value whenever the registry is written, a nil fn removes the series
+func (r *Registry) SetFunc(name string, fn func() float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, Gauge)
+	if fn == nil {
+		delete(f.series, key(labels))
+		return
+	}
+	f.get(labels).fn = fn
+}
+
+// Observe : records a value in a histogram series, labels are name, value pairs
+func (r *Registry) Observe(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, Histogram)
+	s := f.get(labels)
+	if len(s.counts) != len(f.buckets) {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Value : returns the value of a counter or gauge series, or the number of observations of a histogram series
+func (r *Registry) Value(name string, labels ...string) float64 {
+	r.mu.Lock()
+	f, ok := r.families[name]
+	if !ok {
+		r.mu.Unlock()
+		return 0
+	}
+	s, ok := f.series[key(labels)]
+	if !ok {
+		r.mu.Unlock()
+		return 0
+	}
+	fn, v := s.fn, s.value
+	if f.kind == Histogram {
+		v = float64(s.count)
+	}
+	r.mu.Unlock()
+	if fn != nil {
+		return fn()
+	}
+	return v
+}
+
+// family - returns the family of a name, creating one of the given kind if it does not exist, r.mu must be held
+func (r *Registry) family(name, kind string) *family {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, kind: kind, series: make(map[string]*series)}
+		if kind == Histogram {
+			f.buckets = DefaultBuckets
+		}
+		r.families[name] = f
+	}
+	return f
+}
+
+func (f *family) get(labels []string) *series {
+	k := key(labels)
+	s, ok := f.series[k]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		f.series[k] = s
+	}
+	return s
+}
+
+func key(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+type sample struct {
+	labels string
+	value  float64
+	fn     func() float64
+	hist   *series
+}
+
+// WriteText : writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	type snapshot struct {
+		f       family
+		samples []sample
+	}
+	var snaps []snapshot
+	for _, name := range names {
+		f := r.families[name]
+		snap := snapshot{f: family{name: f.name, help: f.help, kind: f.kind, buckets: f.buckets}}
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			smp := sample{labels: formatLabels(s.labels), value: s.value, fn: s.fn}
+			if f.kind == Histogram {
+				c := *s
+				c.counts = append([]uint64(nil), s.counts...)
+				smp.hist = &c
+			}
+			snap.samples = append(snap.samples, smp)
+		}
+		snaps = append(snaps, snap)
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, snap := range snaps {
+		f := snap.f
+		if f.help != "" {
+			bw.WriteString("# HELP " + f.name + " " + escape(f.help, false) + "\n")
+		}
+		bw.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, smp := range snap.samples {
+			switch {
+			case smp.fn != nil: // called without the lock held
+				bw.WriteString(f.name + smp.labels + " " + formatFloat(smp.fn()) + "\n")
+			case smp.hist != nil:
+				writeHistogram(bw, f, smp)
+			default:
+				bw.WriteString(f.name + smp.labels + " " + formatFloat(smp.value) + "\n")
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHistogram(bw *bufio.Writer, f family, smp sample) {
+	s := smp.hist
+	for i, b := range f.buckets {
+		var n uint64
+		if i < len(s.counts) {
+			n = s.counts[i]
+		}
+		bw.WriteString(f.name + "_bucket" + withLabel(smp.labels, "le", formatFloat(b)) + " " + strconv.FormatUint(n, 10) + "\n")
+	}
+	bw.WriteString(f.name + "_bucket" + withLabel(smp.labels, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	bw.WriteString(f.name + "_sum" + smp.labels + " " + formatFloat(s.sum) + "\n")
+	bw.WriteString(f.name + "_count" + smp.labels + " " + strconv.FormatUint(s.count, 10) + "\n")
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+escape(labels[i+1], true)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func withLabel(labels, name, value string) string {
+	l := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + l + "}"
+	}
+	return labels[:len(labels)-1] + "," + l + "}"
+}
+
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP : serves the registry in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = r.WriteText(w)
+}
+
+// Listen : serves a registry at /metrics on addr until the returned server is closed
+func Listen(addr string, r *Registry) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Addr: l.Addr().String(), Handler: mux}
+	go func() { _ = srv.Serve(l) }()
+	return srv, nil
+}
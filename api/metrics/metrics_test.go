@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Registry_Text(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("test_total", Counter, "A counter.")
+	r.Add("test_total", 2, "result", "ok")
+	r.Add("test_total", 1, "result", "ok")
+	r.Add("test_total", 1, "result", `bad "quote"`)
+	r.Set("test_depth", 7)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# HELP test_total A counter.\n",
+		"# TYPE test_total counter\n",
+		`test_total{result="ok"} 3` + "\n",
+		`test_total{result="bad \"quote\""} 1` + "\n",
+		"# TYPE test_depth gauge\n",
+		"test_depth 7\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("missing %q in:\n%s", line, out)
+		}
+	}
+	if v := r.Value("test_total", "result", "ok"); v != 3 {
+		t.Fatal("wrong value", v)
+	}
+}
+
+func Test_Registry_Histogram(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("test_seconds", Histogram, "", 1, 0.1)
+	r.Observe("test_seconds", 0.05)
+	r.Observe("test_seconds", 0.5)
+	r.Observe("test_seconds", 5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		`test_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_seconds_bucket{le="1"} 2` + "\n",
+		`test_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_seconds_sum 5.55\n",
+		"test_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func Test_Registry_SetFunc(t *testing.T) {
+	r := NewRegistry()
+	depth := 4
+	r.SetFunc("test_depth", func() float64 { return float64(depth) }, "node", "a")
+	depth = 5
+	if v := r.Value("test_depth", "node", "a"); v != 5 {
+		t.Fatal("func gauge not evaluated on read", v)
+	}
+	r.SetFunc("test_depth", nil, "node", "a")
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	if strings.Contains(buf.String(), `node="a"`) {
+		t.Fatal("removed series still written")
+	}
+}
+
+func Test_Listen(t *testing.T) {
+	r := NewRegistry()
+	r.Add("test_total", 1)
+	srv, err := Listen("127.0.0.1:0", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + srv.Addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") || !strings.Contains(string(body), "test_total 1\n") {
+		t.Fatal("bad scrape:", resp.Header.Get("Content-Type"), string(body))
+	}
+}
@@ -0,0 +1,47 @@
+package metrics
+
+// Metrics updated by ratnet itself, in the Default registry
+const (
+	OutboxMessages = "ratnet_outbox_messages"       // gauge, by node routing key
+	StreamsPending = "ratnet_streams_pending"       // gauge, by node routing key, chunked streams waiting for chunks
+	RouterMessages = "ratnet_router_messages_total" // counter, by decision
+	Polls          = "ratnet_polls_total"           // counter, by result
+	PollSeconds    = "ratnet_poll_duration_seconds" // histogram
+	BundleBytes    = "ratnet_bundle_bytes"          // histogram, by direction
+	PeerTXBytes    = "ratnet_peer_tx_bytes_total"   // counter, by node routing key and peer
+	PeerRXBytes    = "ratnet_peer_rx_bytes_total"   // counter, by node routing key and peer
+	TransportCalls = "ratnet_transport_calls_total" // counter, by transport, action and result
+	MixPooled      = "ratnet_mix_pooled_messages"   // gauge, messages held in router mix pools
+)
+
+// Router decisions, the "decision" label of RouterMessages
+const (
+	DecisionConsumed  = "consumed"
+	DecisionForwarded = "forwarded"
+	DecisionDuplicate = "duplicate"
+	DecisionCover     = "cover"
+	DecisionPeeled    = "peeled"
+	DecisionHopLimit  = "hoplimit"
+	DecisionUnpeeled  = "unpeeled"
+)
+
+func init() {
+	Default.Describe(OutboxMessages, Gauge, "Messages waiting in the outbox.")
+	Default.Describe(StreamsPending, Gauge, "Chunked streams waiting for more chunks.")
+	Default.Describe(RouterMessages, Counter, "Messages seen by the router, by what it did with them.")
+	Default.Describe(Polls, Counter, "Push/pull exchanges with peers, by result.")
+	Default.Describe(PollSeconds, Histogram, "Duration of push/pull exchanges with peers.", DefaultBuckets...)
+	Default.Describe(BundleBytes, Histogram, "Size of the bundles exchanged with peers, by direction.", SizeBuckets...)
+	Default.Describe(PeerTXBytes, Counter, "Bundle bytes sent to a peer.")
+	Default.Describe(PeerRXBytes, Counter, "Bundle bytes received from a peer.")
+	Default.Describe(TransportCalls, Counter, "RPC calls made through a transport, by action and result.")
+	Default.Describe(MixPooled, Gauge, "Messages held in router mix pools.")
+}
+
+// Result : returns the "result" label value for an error, "ok" or "error"
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -14,4 +14,11 @@ type Msg struct {
 	PubKey       bc.PubKey
 	Chunked      bool
 	StreamHeader bool
+	Extensions   []Extension // carried in the extended message header
+	Onion        bool        // content is an onion layer for a relay, see WrapOnion
+	Tagged       bool        // Name is a ChannelTag, not the channel name
+	Channel      string      // channel name of a Tagged message when this node knows it, outboxes record it in place of the tag
+	Route        []bc.PubKey // SendMsg: relay routing keys to pass the message through, first hop first
+	NextHop      string      // routing key (b64) of the relay an onion layer is for, outboxes only release it to that peer
+	Expiry       int64       // SendMsg: unix nanoseconds after which outboxes drop the message, 0 for the node's retention
 }
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentName : the Name of received messages that were sent to the node's content key
+const ContentName = "[content]"
+
+// MsgFilter : selects the received messages a MsgSubscription receives, see ChannelFilter, ProfileFilter and ContentFilter
+type MsgFilter struct {
+	Name   string // channel or profile name
+	IsChan bool
+}
+
+// ChannelFilter : selects the messages received on a channel
+func ChannelFilter(channelName string) MsgFilter {
+	return MsgFilter{Name: channelName, IsChan: true}
+}
+
+// ProfileFilter : selects the direct messages received for a profile
+func ProfileFilter(profileName string) MsgFilter {
+	return MsgFilter{Name: profileName}
+}
+
+// ContentFilter : selects the direct messages received for the node's content key
+func ContentFilter() MsgFilter {
+	return MsgFilter{Name: ContentName}
+}
+
+// Match : returns true if the filter selects the message, reassembled direct messages, which
+// do not carry a profile name, count as messages for the content key
+func (f MsgFilter) Match(msg Msg) bool {
+	if f.IsChan != msg.IsChan {
+		return false
+	}
+	if !f.IsChan && f.Name == ContentName && msg.Name == "" {
+		return true
+	}
+	return f.Name == msg.Name
+}
+
+// Backpressure : what a MsgSubscription does with a message when its buffer is full
+type Backpressure int
+
+const (
+	// DropOldest : drops the oldest buffered message to make room, like an EventBus Subscription
+	DropOldest Backpressure = iota
+	// DropNewest : drops the new message
+	DropNewest
+	// Block : waits for the subscriber to make room, which holds up the delivery of later messages
+	Block
+)
+
+// MsgBus : delivers the messages a node receives to the subscribers whose filter selects them,
+// each with its own buffer and Backpressure
+type MsgBus struct {
+	mu      sync.RWMutex
+	subs    map[*MsgSubscription]struct{}
+	dropped uint64
+}
+
+// NewMsgBus : returns a MsgBus without subscribers
+func NewMsgBus() *MsgBus {
+	return &MsgBus{subs: make(map[*MsgSubscription]struct{})}
+}
+
+// Subscribe : returns a MsgSubscription to the messages selected by filter, buffering up to size of them
+func (b *MsgBus) Subscribe(filter MsgFilter, size int, backpressure Backpressure) *MsgSubscription {
+	if size < 1 {
+		size = 1
+	}
+	s := &MsgSubscription{bus: b, filter: filter, backpressure: backpressure, c: make(chan Msg, size), quit: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// HandleFunc : calls fn with each message selected by filter, one at a time, until the returned subscription is closed
+func (b *MsgBus) HandleFunc(filter MsgFilter, size int, backpressure Backpressure, fn func(Msg)) *MsgSubscription {
+	s := b.Subscribe(filter, size, backpressure)
+	go func() {
+		for msg := range s.c {
+			fn(msg)
+		}
+	}()
+	return s
+}
+
+// Publish : delivers a message to every subscriber whose filter selects it, each with its own copy of
+// the content, returns false if there is none
+func (b *MsgBus) Publish(msg Msg) bool {
+	b.mu.RLock()
+	var subs []*MsgSubscription
+	for s := range b.subs {
+		if s.filter.Match(msg) {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.RUnlock()
+	if len(subs) == 0 {
+		return false
+	}
+	var content []byte
+	if msg.Content != nil {
+		content = msg.Content.Bytes()
+	}
+	for _, s := range subs {
+		m := msg
+		m.Content = bytes.NewBuffer(content)
+		s.send(m)
+	}
+	return true
+}
+
+// Dropped : returns how many messages were dropped across all subscribers
+func (b *MsgBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// MsgSubscription : a subscriber's buffered view of a MsgBus
+type MsgSubscription struct {
+	bus          *MsgBus
+	filter       MsgFilter
+	backpressure Backpressure
+	c            chan Msg
+	quit         chan struct{}
+	quitOnce     sync.Once
+	mu           sync.Mutex
+	closed       bool
+	dropped      uint64
+}
+
+// Msgs : returns the channel the subscriber receives messages on, it is closed by Close
+func (s *MsgSubscription) Msgs() <-chan Msg {
+	return s.c
+}
+
+// Dropped : returns how many messages were dropped because this subscriber fell behind
+func (s *MsgSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close : removes the subscription from its MsgBus and closes its channel
+func (s *MsgSubscription) Close() {
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+	s.quitOnce.Do(func() { close(s.quit) }) // releases a blocked send
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+// send - buffers a message, applying the subscription's Backpressure if the buffer is full
+func (s *MsgSubscription) send(msg Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	switch s.backpressure {
+	case Block:
+		select {
+		case s.c <- msg:
+		case <-s.quit:
+		}
+		return
+	case DropNewest:
+		select {
+		case s.c <- msg:
+		default:
+			s.drop()
+		}
+		return
+	}
+	for {
+		select {
+		case s.c <- msg:
+			return
+		default:
+		}
+		select {
+		case <-s.c:
+			s.drop()
+		default:
+		}
+	}
+}
+
+func (s *MsgSubscription) drop() {
+	atomic.AddUint64(&s.dropped, 1)
+	atomic.AddUint64(&s.bus.dropped, 1)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_MsgBus_Filters(t *testing.T) {
+	bus := NewMsgBus()
+	chan1 := bus.Subscribe(ChannelFilter("chan1"), 8, DropOldest)
+	profile := bus.Subscribe(ProfileFilter("chan1"), 8, DropOldest)
+	content := bus.Subscribe(ContentFilter(), 8, DropOldest)
+
+	msgs := []Msg{
+		{Name: "chan1", IsChan: true, Content: bytes.NewBufferString("a")},
+		{Name: "chan1"},
+		{Name: ContentName},
+		{}, // reassembled direct message
+		{Name: "chan2", IsChan: true},
+	}
+	for i, msg := range msgs {
+		if got, want := bus.Publish(msg), i < 4; got != want {
+			t.Fatalf("Publish of message %d returned %v", i, got)
+		}
+	}
+	for _, c := range []struct {
+		sub  *MsgSubscription
+		want int
+	}{{chan1, 1}, {profile, 1}, {content, 2}} {
+		if n := len(c.sub.Msgs()); n != c.want {
+			t.Fatalf("subscriber got %d messages, want %d", n, c.want)
+		}
+	}
+
+	// every subscriber reads its own copy of the content
+	other := bus.Subscribe(ChannelFilter("chan1"), 1, DropOldest)
+	bus.Publish(Msg{Name: "chan1", IsChan: true, Content: bytes.NewBufferString("b")})
+	<-chan1.Msgs()
+	if m := <-chan1.Msgs(); m.Content.String() != "b" {
+		t.Fatal("wrong content", m.Content.String())
+	}
+	if m := <-other.Msgs(); m.Content.String() != "b" {
+		t.Fatal("content shared between subscribers", m.Content.String())
+	}
+}
+
+func Test_MsgBus_Backpressure(t *testing.T) {
+	bus := NewMsgBus()
+	oldest := bus.Subscribe(ChannelFilter("c"), 2, DropOldest)
+	newest := bus.Subscribe(ChannelFilter("c"), 2, DropNewest)
+	for _, name := range []string{"1", "2", "3"} {
+		bus.Publish(Msg{Name: "c", IsChan: true, Content: bytes.NewBufferString(name)})
+	}
+	if oldest.Dropped() != 1 || newest.Dropped() != 1 || bus.Dropped() != 2 {
+		t.Fatal("wrong dropped counts:", oldest.Dropped(), newest.Dropped(), bus.Dropped())
+	}
+	if m := <-oldest.Msgs(); m.Content.String() != "2" {
+		t.Fatal("DropOldest kept", m.Content.String())
+	}
+	<-newest.Msgs()
+	if m := <-newest.Msgs(); m.Content.String() != "2" {
+		t.Fatal("DropNewest kept", m.Content.String())
+	}
+	oldest.Close()
+	newest.Close()
+
+	// a blocking subscription holds up Publish until there is room, or it is closed
+	blocking := bus.Subscribe(ChannelFilter("c"), 1, Block)
+	bus.Publish(Msg{Name: "c", IsChan: true})
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(Msg{Name: "c", IsChan: true})
+		bus.Publish(Msg{Name: "c", IsChan: true})
+		close(done)
+	}()
+	<-blocking.Msgs()
+	select {
+	case <-done:
+		t.Fatal("Publish did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	blocking.Close()
+	<-done
+	if blocking.Dropped() != 0 {
+		t.Fatal("blocking subscription dropped messages")
+	}
+}
+
+func Test_MsgBus_HandleFunc(t *testing.T) {
+	bus := NewMsgBus()
+	got := make(chan string, 2)
+	sub := bus.HandleFunc(ProfileFilter("me"), 2, Block, func(m Msg) { got <- m.Content.String() })
+	bus.Publish(Msg{Name: "me", Content: bytes.NewBufferString("x")})
+	bus.Publish(Msg{Name: "me", Content: bytes.NewBufferString("y")})
+	if a, b := <-got, <-got; a != "x" || b != "y" {
+		t.Fatal("handler called with", a, b)
+	}
+	sub.Close()
+	if bus.Publish(Msg{Name: "me"}) {
+		t.Fatal("closed subscription still selected messages")
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+
 	"github.com/awgh/bencrypt/bc"
 )
 
@@ -16,6 +18,8 @@ type Node interface {
 	SetRouter(router Router)
 	GetChannelPrivKey(name string) (string, error)
 	Handle(msg Msg) (bool, error)
+	// PeelOnion - decrypt an onion layer with the routing key, returns TagOK false if it is for another relay
+	PeelOnion(msg Msg) (bool, []byte, error)
 	Forward(msg Msg) error
 	IsRunning() bool
 
@@ -25,7 +29,7 @@ type Node interface {
 	// AddChunk - inform node of receipt of a chunk
 	AddChunk(streamID uint32, chunkNum uint32, data []byte) error
 
-	// FlushOutbox : Empties the outbox of messages older than maxAgeSeconds
+	// FlushOutbox : Empties the outbox of messages older than their channel's retention (see ConfigOutboxRetention), or maxAgeSeconds
 	FlushOutbox(maxAgeSeconds int64)
 
 	// RPC Entrypoints
@@ -93,6 +97,31 @@ type Node interface {
 	AddPeer(name string, enabled bool, uri string, group ...string) error
 	// DeletePeer : Remove a peer from this node's database (33)
 	DeletePeer(name string) error
+	// SetPeerIdentity : Pin the routing pubkey (b64) and/or TLS certificate fingerprint (hex SHA-256) a peer must present, "" disables a check (37)
+	SetPeerIdentity(name string, pubkey string, fingerprint string) error
+
+	// GetConfig : Retrieve a node configuration value by name, "" if it is not set (38)
+	GetConfig(name string) (string, error)
+	// SetConfig : Set a node configuration value, "" removes it (39)
+	SetConfig(name string, value string) error
+
+	// GetHealth : Report the status of this node, its policies and their transports (40)
+	GetHealth() (Health, error)
+
+	// GetOutbox : List the messages queued for the given channels, or every channel if none are given, "" for messages to content keys (41)
+	GetOutbox(channelNames ...string) ([]OutboxEntry, error)
+	// GetOutboxCounts : Count the messages and bytes queued for each channel (42)
+	GetOutboxCounts() ([]OutboxCount, error)
+	// DeleteOutbox : Remove messages older than maxAgeSeconds (0 for all of them) queued for the given channels, or every channel if none are given, returns how many were removed (43)
+	DeleteOutbox(maxAgeSeconds int64, channelNames ...string) (int64, error)
+
+	// FetchInbox : Lease up to max received messages (0 for all of them) for leaseSeconds (0 for DefaultInboxLease), oldest first,
+	// leased messages are fetched again once their lease ends unless they are acknowledged (44)
+	FetchInbox(max int64, leaseSeconds int64) ([]InboxMsg, error)
+	// AckInbox : Remove fetched messages from the inbox, unknown IDs are ignored (45)
+	AckInbox(ids ...int64) error
+	// NackInbox : End the lease of fetched messages, so they are fetched again (46)
+	NackInbox(ids ...int64) error
 
 	// Send : Transmit a message to a single key (34) <deprecated>
 	Send(contactName string, data []byte, pubkey ...bc.PubKey) error
@@ -111,8 +140,15 @@ type Node interface {
 	In() chan Msg
 	// Out : Returns the Out channel of this node
 	Out() chan Msg
-	// Events : Returns the Err channel of this node
-	Events() chan Event
+	// Events : Returns the channel of this node's default subscription to its EventBus, which receives every event
+	Events() <-chan Event
+	// EventBus : Returns the bus this node publishes its events on, subscribe to it for more consumers
+	EventBus() *EventBus
+	// MsgBus : Returns the bus this node delivers received messages on, messages no subscription selects go to Out,
+	// with ConfigInbox set every message also goes to the inbox
+	MsgBus() *MsgBus
+	// PeerVersions : Returns the protocol versions this node's peers reported, Pickup downgrades messages for them
+	PeerVersions() *PeerVersions
 
 	ImportExport
 }
@@ -169,12 +205,17 @@ type Peer struct {
 	Enabled bool   `db:"enabled"`
 	URI     string `db:"uri"`
 	Group   string `db:"peergroup"`
+
+	// expected identity of the peer, checked by PollServer and the transports when set
+	Pubkey      string `db:"pubkey"`      // routing public key, b64
+	Fingerprint string `db:"fingerprint"` // TLS certificate fingerprint, hex SHA-256
 }
 
 // Bundle : mostly-opaque data blob returned by Pickup and passed into Dropoff
 type Bundle struct {
-	Data []byte
-	Time int64
+	Data  []byte
+	Time  int64
+	Stamp []byte // proof-of-work stamp, required by Dropoff when the receiving node sets ConfigStampDifficulty
 }
 
 // OutboxMsg : object that describes an outbox message
@@ -182,6 +223,8 @@ type OutboxMsg struct {
 	Channel   string `db:"channel"`
 	Msg       []byte `db:"msg"`
 	Timestamp int64  `db:"timestamp"`
+	Expiry    int64  `db:"expiry"`  // unix nanoseconds, 0 for never
+	NextHop   string `db:"nexthop"` // routing key (b64) of the only peer an onion layer is released to
 }
 
 // ConfigValue - Name/Value pairs of configuration strings
@@ -189,3 +232,32 @@ type ConfigValue struct {
 	Name  string `db:"name"`
 	Value string `db:"value"`
 }
+
+// ConfigStampDifficulty - config name for the leading zero bits required of Dropoff stamps, unset or 0 disables stamps
+const ConfigStampDifficulty = "stampdifficulty"
+
+// ConfigMaxStampDifficulty - config name for the highest stamp difficulty this node mints for when a peer asks, DefaultMaxStampDifficulty if unset
+const ConfigMaxStampDifficulty = "maxstampdifficulty"
+
+// DefaultMaxStampDifficulty - the highest stamp difficulty a node mints for without ConfigMaxStampDifficulty, a few seconds of work
+const DefaultMaxStampDifficulty = 24
+
+// ConfigHopLimit - config name for the hop limit added to messages this node sends, unset or 0 sends messages without one
+const ConfigHopLimit = "hoplimit"
+
+// ConfigCompression - config name for the algorithm used to compress the content of messages this node sends, "deflate", "gzip", or unset for none
+const ConfigCompression = "compression"
+
+// ConfigPadding - config name for how message content and bundles are padded, "bucket", "fixed", or unset for none
+const ConfigPadding = "padding"
+
+// ConfigChannelTags - config name for sending channel messages with a rotating ChannelTag in place of the channel name, "true" enables
+const ConfigChannelTags = "channeltags"
+
+// ErrReservedConfig - returned by GetConfig and SetConfig for names a Node uses internally
+var ErrReservedConfig = errors.New("Reserved config name")
+
+// IsReservedConfig - reports whether a config name holds a Node's own keys, which are not accessible through GetConfig and SetConfig
+func IsReservedConfig(name string) bool {
+	return name == "contentkey" || name == "routingkey"
+}
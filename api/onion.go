@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/awgh/bencrypt/bc"
+)
+
+// MaxOnionHops : longest relay path a message can be wrapped for
+const MaxOnionHops = 8
+
+// onionHopOverhead : the length and b64 ECC routing key of the next relay, carried inside each onion layer
+const onionHopOverhead = 1 + 44
+
+// onionLayerOverhead : flags byte, next relay, encryption overhead and block padding added by each onion layer
+const onionLayerOverhead = 1 + onionHopOverhead + eccOverhead + 16
+
+// ErrOnionRoute : the relay path is empty or longer than MaxOnionHops
+var ErrOnionRoute = errors.New("Invalid onion route")
+
+// OnionOverhead : bytes added to a message wrapped for the given number of relays
+func OnionOverhead(hops int) uint32 {
+	return uint32(hops * onionLayerOverhead)
+}
+
+// WrapOnion : wraps a complete message, header included, in one layer per relay of route,
+// the first relay's layer is outermost, each layer is encrypted to that relay's routing key
+// and carries only OnionFlag in its header, so relays do not see the channel name,
+// inside each layer the routing key of the next relay, or nothing for the last, precedes the message, see OpenOnion
+func WrapOnion(key bc.KeyPair, message []byte, route []bc.PubKey) ([]byte, error) {
+	if len(route) == 0 || len(route) > MaxOnionHops {
+		return nil, ErrOnionRoute
+	}
+	for i := len(route) - 1; i >= 0; i-- {
+		b := new(bytes.Buffer)
+		if i+1 < len(route) {
+			writeLV(b, []byte(route[i+1].ToB64()))
+		} else {
+			writeLV(b, nil)
+		}
+		b.Write(message)
+		layer, err := key.EncryptMessage(b.Bytes(), route[i])
+		if err != nil {
+			return nil, err
+		}
+		message = append(EncodeMsgHeader(&Msg{Onion: true}), layer...)
+	}
+	return message, nil
+}
+
+// OpenOnion : splits a decrypted onion layer into the routing key (b64) of the relay the message inside is for,
+// "" if this was the last relay, and that message
+func OpenOnion(clear []byte) (nextHop string, message []byte, err error) {
+	r := bytes.NewReader(clear)
+	hop, err := readLV(r)
+	if err != nil {
+		return "", nil, wireError("onion next hop", err)
+	}
+	return string(hop), clear[len(clear)-r.Len():], nil
+}
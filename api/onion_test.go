@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/bencrypt/ecc"
+)
+
+func Test_WrapOnion(t *testing.T) {
+	var relays []*ecc.KeyPair
+	var route []bc.PubKey
+	for i := 0; i < 2; i++ {
+		k := new(ecc.KeyPair)
+		k.GenerateKey()
+		relays = append(relays, k)
+		route = append(route, k.GetPubKey())
+	}
+	inner := append(EncodeMsgHeader(&Msg{Name: "secret", IsChan: true}), make([]byte, 64)...)
+	onion, err := WrapOnion(relays[0], inner, route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := onion
+	for i, k := range relays {
+		h, err := ParseMsgHeader(layer)
+		if err != nil || h.Flags != OnionFlag || h.Name != "" {
+			t.Fatalf("layer %d header exposes more than the onion flag: %+v %v", i, h, err)
+		}
+		if tagOK, _, _ := DecryptMessage(relays[1-i], layer[h.Size:]); tagOK {
+			t.Fatal("layer", i, "opened by the wrong relay")
+		}
+		tagOK, clear, err := DecryptMessage(k, layer[h.Size:])
+		if !tagOK || err != nil {
+			t.Fatal("relay", i, "could not peel its layer:", err)
+		}
+		// each layer names the relay the next one is for
+		next, message, err := OpenOnion(clear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if i+1 < len(relays) {
+			want = route[i+1].ToB64()
+		}
+		if next != want {
+			t.Fatalf("layer %d next hop %q, expected %q", i, next, want)
+		}
+		layer = message
+	}
+	if !bytes.Equal(layer, inner) {
+		t.Fatal("inner message changed")
+	}
+	if _, err := WrapOnion(relays[0], inner, nil); err != ErrOnionRoute {
+		t.Fatal("expected ErrOnionRoute, got", err)
+	}
+	if n := len(onion) - len(inner); uint32(n) > OnionOverhead(2) {
+		t.Fatal("onion overhead", n, "above", OnionOverhead(2))
+	}
+}
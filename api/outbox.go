@@ -0,0 +1,144 @@
+package api
+
+import (
+	"sort"
+	"time"
+)
+
+// ConfigOutboxRetention - config name for the seconds messages stay in the outbox, 0 for until they expire, unset uses
+// DefaultOutboxRetention, or the maxAgeSeconds given to FlushOutbox, OutboxRetentionConfig names the config that overrides it for one channel
+const ConfigOutboxRetention = "outboxretention"
+
+// OutboxRetentionConfig - returns the config name for the outbox retention of one channel, "" for messages to content keys
+func OutboxRetentionConfig(channelName string) string {
+	return ConfigOutboxRetention + "." + channelName
+}
+
+// DefaultOutboxRetention : seconds messages stay in the outbox when neither the sender nor ConfigOutboxRetention sets a limit
+var DefaultOutboxRetention int64 = 300
+
+// OutboxEntry : describes a message in a node's outbox, without its content
+type OutboxEntry struct {
+	Channel   string // "" for messages to content keys
+	Size      int64  // bytes, including the routing header
+	Timestamp int64  // unix nanoseconds, when the message was queued
+	Expiry    int64  // unix nanoseconds, when the message will be dropped, 0 for never
+}
+
+// Expired : reports whether a message with this expiry is past it at now, in unix nanoseconds
+func Expired(expiry, now int64) bool {
+	return expiry != 0 && expiry <= now
+}
+
+// OutboxCount : the number of messages a node's outbox holds for one channel
+type OutboxCount struct {
+	Channel  string
+	Messages int64
+	Bytes    int64
+}
+
+// CountOutbox : totals outbox entries by channel, in channel order
+func CountOutbox(entries []OutboxEntry) []OutboxCount {
+	byChannel := make(map[string]*OutboxCount)
+	var counts []OutboxCount
+	for _, e := range entries {
+		c, ok := byChannel[e.Channel]
+		if !ok {
+			c = &OutboxCount{Channel: e.Channel}
+			byChannel[e.Channel] = c
+		}
+		c.Messages++
+		c.Bytes += e.Size
+	}
+	for _, c := range byChannel {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Channel < counts[j].Channel })
+	return counts
+}
+
+// OutboxCutoff : returns the timestamp before which messages are older than maxAgeSeconds
+func OutboxCutoff(maxAgeSeconds int64) int64 {
+	return time.Now().UnixNano() - maxAgeSeconds*int64(time.Second)
+}
+
+// MatchChannel : reports whether a channel is one of channelNames, an empty list matches every channel
+func MatchChannel(channelName string, channelNames []string) bool {
+	if len(channelNames) == 0 {
+		return true
+	}
+	for _, c := range channelNames {
+		if c == channelName {
+			return true
+		}
+	}
+	return false
+}
+
+// OutboxEntriesToArgs : encodes outbox entries as the result of the GetOutbox action
+func OutboxEntriesToArgs(entries []OutboxEntry) []interface{} {
+	args := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		args = append(args, []interface{}{e.Channel, e.Size, e.Timestamp, e.Expiry})
+	}
+	return args
+}
+
+// OutboxEntriesFromArgs : decodes the result of the GetOutbox action
+func OutboxEntriesFromArgs(args []interface{}) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	for _, a := range args {
+		var ok bool
+		channel, size, ts, err := outboxTriple(a, "outbox entry")
+		if err != nil {
+			return nil, err
+		}
+		entry := OutboxEntry{Channel: channel, Size: size, Timestamp: ts}
+		if fields := a.([]interface{}); len(fields) > 3 {
+			if entry.Expiry, ok = fields[3].(int64); !ok {
+				return nil, &WireError{Op: "outbox entry", Err: ErrUnexpectedType}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// OutboxCountsToArgs : encodes outbox counts as the result of the GetOutboxCounts action
+func OutboxCountsToArgs(counts []OutboxCount) []interface{} {
+	args := make([]interface{}, 0, len(counts))
+	for _, c := range counts {
+		args = append(args, []interface{}{c.Channel, c.Messages, c.Bytes})
+	}
+	return args
+}
+
+// OutboxCountsFromArgs : decodes the result of the GetOutboxCounts action
+func OutboxCountsFromArgs(args []interface{}) ([]OutboxCount, error) {
+	var counts []OutboxCount
+	for _, a := range args {
+		channel, messages, bytes, err := outboxTriple(a, "outbox count")
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, OutboxCount{Channel: channel, Messages: messages, Bytes: bytes})
+	}
+	return counts, nil
+}
+
+func outboxTriple(a interface{}, op string) (string, int64, int64, error) {
+	fields, ok := a.([]interface{})
+	if !ok {
+		return "", 0, 0, &WireError{Op: op, Err: ErrUnexpectedType}
+	}
+	if len(fields) < 3 {
+		return "", 0, 0, &WireError{Op: op, Err: ErrInputTooShort}
+	}
+	s, ok1 := fields[0].(string)
+	x, ok2 := fields[1].(int64)
+	y, ok3 := fields[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return "", 0, 0, &WireError{Op: op, Err: ErrUnexpectedType}
+	}
+	return s, x, y, nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import "testing"
+
+func Test_Outbox_Counts(t *testing.T) {
+	entries := []OutboxEntry{{Channel: "b", Size: 10, Timestamp: 1}, {Size: 5, Timestamp: 2}, {Channel: "b", Size: 7, Timestamp: 3, Expiry: 4}}
+	counts := CountOutbox(entries)
+	if len(counts) != 2 || counts[0] != (OutboxCount{Messages: 1, Bytes: 5}) || counts[1] != (OutboxCount{Channel: "b", Messages: 2, Bytes: 17}) {
+		t.Fatalf("wrong counts: %+v", counts)
+	}
+
+	rr := RemoteResponse{Value: OutboxEntriesToArgs(entries)}
+	resp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&rr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := OutboxEntriesFromArgs(resp.Value.([]interface{}))
+	if err != nil || len(got) != len(entries) || got[2] != entries[2] {
+		t.Fatalf("entries changed on the wire: %+v %v", got, err)
+	}
+	gotCounts, err := OutboxCountsFromArgs(OutboxCountsToArgs(counts))
+	if err != nil || len(gotCounts) != 2 || gotCounts[1] != counts[1] {
+		t.Fatalf("counts changed on the wire: %+v %v", gotCounts, err)
+	}
+	if _, err := OutboxEntriesFromArgs([]interface{}{[]interface{}{"a", int64(1)}}); err == nil {
+		t.Fatal("short entry accepted")
+	}
+	if got, err := OutboxEntriesFromArgs([]interface{}{[]interface{}{"a", int64(1), int64(2)}}); err != nil || got[0].Expiry != 0 {
+		t.Fatalf("entry without an expiry rejected: %+v %v", got, err)
+	}
+	if Expired(0, 5) || Expired(6, 5) || !Expired(5, 5) {
+		t.Fatal("wrong expiry check")
+	}
+}
@@ -0,0 +1,85 @@
+package padding
+
+import (
+	"errors"
+)
+
+// Mode : how content is padded before it is encrypted
+type Mode int
+
+const (
+	// None : content is not padded
+	None Mode = iota
+	// Bucket : content is padded up to the next power of two, at least MinBucket, so only its size class is visible
+	Bucket
+	// Fixed : content is padded up to a fixed size, the transport byte limit, so every message or bundle looks alike
+	Fixed
+)
+
+// MinBucket : smallest padded size in Bucket mode
+const MinBucket = 256
+
+// marker : ends the content and starts the padding (ISO/IEC 7816-4), followed only by zeros
+const marker = 0x80
+
+var (
+	// ErrUnknownMode : the padding mode name is not supported
+	ErrUnknownMode = errors.New("Unknown padding mode")
+	// ErrInvalid : padded content has no padding marker
+	ErrInvalid = errors.New("Invalid padding")
+)
+
+var names = map[string]Mode{"": None, "none": None, "bucket": Bucket, "fixed": Fixed}
+
+// ByName : returns the mode for a config value, "" or "none" disables padding
+func ByName(name string) (Mode, error) {
+	m, ok := names[name]
+	if !ok {
+		return None, ErrUnknownMode
+	}
+	return m, nil
+}
+
+// Size : returns the padded size for n bytes of content, never more than limit unless n itself is larger
+func (m Mode) Size(n, limit int) int {
+	size := n
+	switch m {
+	case Bucket:
+		size = MinBucket
+		for size < n {
+			size <<= 1
+		}
+		if limit > 0 && size > limit {
+			size = limit
+		}
+	case Fixed:
+		size = limit
+	}
+	if size < n {
+		size = n
+	}
+	return size
+}
+
+// Pad : returns data followed by a marker byte and zeros, size bytes long in total, or len(data)+1 if size is too small
+func Pad(data []byte, size int) []byte {
+	if size < len(data)+1 {
+		size = len(data) + 1
+	}
+	out := make([]byte, size)
+	copy(out, data)
+	out[len(data)] = marker
+	return out
+}
+
+// Unpad : strips the padding added by Pad
+func Unpad(data []byte) ([]byte, error) {
+	i := len(data) - 1
+	for i >= 0 && data[i] == 0 {
+		i--
+	}
+	if i < 0 || data[i] != marker {
+		return nil, ErrInvalid
+	}
+	return data[:i], nil
+}
@@ -0,0 +1,49 @@
+package padding
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_padding_Size(t *testing.T) {
+	for _, c := range []struct {
+		m              Mode
+		n, limit, want int
+	}{
+		{None, 10, 1000, 10},
+		{Bucket, 10, 1000, MinBucket},
+		{Bucket, 300, 1000, 512},
+		{Bucket, 600, 1000, 1000},
+		{Bucket, 2000, 1000, 2000},
+		{Fixed, 10, 1000, 1000},
+		{Fixed, 2000, 1000, 2000},
+	} {
+		if got := c.m.Size(c.n, c.limit); got != c.want {
+			t.Fatal("mode", c.m, "size of", c.n, "is", got, "expected", c.want)
+		}
+	}
+}
+
+func Test_padding_PadUnpad(t *testing.T) {
+	for _, data := range [][]byte{{}, {0, 0}, {marker}, []byte("hello")} {
+		p := Pad(data, 64)
+		if len(p) != 64 {
+			t.Fatal("padded to", len(p))
+		}
+		u, err := Unpad(p)
+		if err != nil || !bytes.Equal(u, data) {
+			t.Fatal("did not round trip:", data, u, err)
+		}
+	}
+	if len(Pad([]byte("hello"), 2)) != 6 {
+		t.Fatal("short size not grown to fit the marker")
+	}
+	for _, bad := range [][]byte{nil, {0, 0}, {1, 2}, {marker, 1}} {
+		if _, err := Unpad(bad); err != ErrInvalid {
+			t.Fatal("expected ErrInvalid for", bad, "got", err)
+		}
+	}
+	if _, err := ByName("random"); err != ErrUnknownMode {
+		t.Fatal("expected ErrUnknownMode, got", err)
+	}
+}
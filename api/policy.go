@@ -18,4 +18,12 @@ type PeerInfo struct {
 	TotalBytesTX   int64
 	TotalBytesRX   int64
 	RoutingPub     bc.PubKey
+
+	// proof-of-work difficulty the peer requires for Dropoff, valid once StampChecked is set
+	StampDifficulty int
+	StampChecked    bool
+
+	// protocol version and capabilities the peer reported, valid once VersionChecked is set
+	Version        PeerVersion
+	VersionChecked bool
 }
@@ -30,6 +30,7 @@ const (
 	APITypeChannelArray byte = 0x21
 	APITypeProfileArray byte = 0x22
 	APITypePeerArray    byte = 0x23
+	APITypePeerIDArray  byte = 0x24 // peer array including pinned identities
 
 	APITypeContact byte = 0x30
 	APITypeChannel byte = 0x31
@@ -39,11 +40,6 @@ const (
 	APITypeBundle byte = 0x40
 )
 
-var (
-	ErrInputTooShort = errors.New("input too short")
-	ErrLenOverflow   = errors.New("uvarint overflow")
-)
-
 type bytesReader interface {
 	io.Reader
 	io.ByteReader
@@ -53,6 +49,9 @@ type bytesReader interface {
 type RemoteCall struct {
 	Action Action
 	Args   []interface{}
+
+	// Credential : set by the receiving Transport (bearer token or client certificate fingerprint), never serialized
+	Credential string
 }
 
 // RemoteResponse : defines a response returned from a Remote Procedure Call
@@ -77,12 +76,18 @@ func ArgsToBytes(args []interface{}) []byte {
 // ArgsFromBytes - converts a byte array to an interface array
 func ArgsFromBytes(args []byte) ([]interface{}, error) {
 	r := bytes.NewReader(args)
-	rv, err := deserialize(r)
-	var retval []interface{}
-	if rv != nil {
-		retval = rv.([]interface{})
+	rv, err := deserialize(r, 0)
+	if err != nil {
+		return nil, wireError("args", err)
+	}
+	if rv == nil {
+		return nil, nil
+	}
+	retval, ok := rv.([]interface{})
+	if !ok {
+		return nil, wireError("args", ErrUnexpectedType)
 	}
-	return retval, err
+	return retval, nil
 }
 
 // Serialization byte order is BigEndian / network-order
@@ -101,7 +106,7 @@ func RemoteCallToBytes(call *RemoteCall) *[]byte {
 // RemoteCallFromBytes - converts a RemoteCall from a byte array
 func RemoteCallFromBytes(input *[]byte) (*RemoteCall, error) {
 	if len(*input) < 2 {
-		return nil, ErrInputTooShort
+		return nil, wireError("call", ErrInputTooShort)
 	}
 	call := new(RemoteCall)
 	action := (*input)[0]
@@ -127,19 +132,22 @@ func RemoteResponseToBytes(resp *RemoteResponse) *[]byte {
 func RemoteResponseFromBytes(input *[]byte) (*RemoteResponse, error) {
 	resp := new(RemoteResponse)
 	r := bytes.NewReader(*input)
+	var ok bool
 
 	// read the two fields, add to struct
 	// Error string
-	errString, err := deserialize(r)
+	errString, err := deserialize(r, 0)
 	if err != nil {
-		return nil, err
+		return nil, wireError("response error", err)
+	}
+	if resp.Error, ok = errString.(string); !ok {
+		return nil, wireError("response error", ErrUnexpectedType)
 	}
-	resp.Error = errString.(string)
 
 	// Value interface{}
-	value, err := deserialize(r)
+	value, err := deserialize(r, 0)
 	if err != nil {
-		return nil, err
+		return nil, wireError("response value", err)
 	}
 	resp.Value = value
 	return resp, nil
@@ -156,11 +164,17 @@ func BytesBytesToBytes(bba *[][]byte) *[]byte {
 // BytesBytesFromBytes - converts an array of byte arrays from a byte array
 func BytesBytesFromBytes(input *[]byte) (*[][]byte, error) {
 	r := bytes.NewReader(*input)
-	bytesBytesArray, err := deserialize(r)
+	bytesBytesArray, err := deserialize(r, 0)
 	if err != nil {
-		return nil, err
+		return nil, wireError("bytes array", err)
+	}
+	if bytesBytesArray == nil { // an empty array is encoded as nil
+		return &[][]byte{}, nil
+	}
+	retval, ok := bytesBytesArray.([][]byte)
+	if !ok {
+		return nil, wireError("bytes array", ErrUnexpectedType)
 	}
-	retval := bytesBytesArray.([][]byte)
 	return &retval, nil
 }
 
@@ -281,9 +295,21 @@ func serialize(w io.Writer, v interface{}) {
 		} else {
 			b.WriteByte(0)
 		}
+		if ap.Pubkey != "" || ap.Fingerprint != "" { // optional trailing fields, ignored by older readers
+			writeLV(b, []byte(ap.Pubkey))
+			writeLV(b, []byte(ap.Fingerprint))
+		}
 		writeTLV(w, APITypePeer, b.Bytes())
 	case []Peer:
 		ac := v.([]Peer)
+		// identities are only sent in the newer array type when there are any, so older readers still understand the rest
+		typ := APITypePeerArray
+		for _, c := range ac {
+			if c.Pubkey != "" || c.Fingerprint != "" {
+				typ = APITypePeerIDArray
+				break
+			}
+		}
 		lenBuf := make([]byte, binary.MaxVarintLen64)
 		n := binary.PutUvarint(lenBuf, uint64(len(ac))) // number of elements in array
 		b := bytes.NewBuffer(lenBuf[:n])
@@ -296,21 +322,31 @@ func serialize(w io.Writer, v interface{}) {
 			} else {
 				b.WriteByte(0)
 			}
+			if typ == APITypePeerIDArray {
+				writeLV(b, []byte(c.Pubkey))
+				writeLV(b, []byte(c.Fingerprint))
+			}
 		}
-		writeTLV(w, APITypePeerArray, b.Bytes())
+		writeTLV(w, typ, b.Bytes())
 	case Bundle:
 		bundle := v.(Bundle)
 		b := new(bytes.Buffer)
 		writeLV(b, bundle.Data)
 		binary.Write(b, binary.BigEndian, bundle.Time)
+		if len(bundle.Stamp) > 0 { // optional, older nodes ignore trailing bytes
+			writeLV(b, bundle.Stamp)
+		}
 		writeTLV(w, APITypeBundle, b.Bytes())
 		// default:
 		//	log.Printf("Unknown type in serialize: %T\n", v)
 	}
 }
 
-// deserialize - reads the next value from the io.Reader
-func deserialize(r bytesReader) (interface{}, error) {
+// deserialize - reads the next value from the io.Reader, depth is the current nesting of interface arrays
+func deserialize(r bytesReader, depth int) (interface{}, error) {
+	if depth > MaxDepth {
+		return nil, ErrTooDeep
+	}
 	// read the type byte
 	t, err := r.ReadByte()
 	if err != nil {
@@ -349,13 +385,10 @@ func deserialize(r bytesReader) (interface{}, error) {
 		return v, nil
 	case APITypeBytesBytes:
 		var bba [][]byte
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
 			ba, err := readLV(b)
 			if err != nil {
@@ -366,15 +399,12 @@ func deserialize(r bytesReader) (interface{}, error) {
 		return bba, nil
 	case APITypeInterfaceArray:
 		var ia []interface{}
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
-			element, err := deserialize(b)
+			element, err := deserialize(b, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -411,13 +441,10 @@ func deserialize(r bytesReader) (interface{}, error) {
 
 	case APITypeContactArray:
 		var contacts []Contact
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
 			var contact Contact
 			va, err := readLV(b)
@@ -451,13 +478,10 @@ func deserialize(r bytesReader) (interface{}, error) {
 
 	case APITypeChannelArray:
 		var channels []Channel
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
 			var channel Channel
 			va, err := readLV(b)
@@ -500,13 +524,10 @@ func deserialize(r bytesReader) (interface{}, error) {
 
 	case APITypeProfileArray:
 		var profiles []Profile
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
 			var profile Profile
 			va, err := readLV(b)
@@ -560,17 +581,26 @@ func deserialize(r bytesReader) (interface{}, error) {
 		} else {
 			peer.Enabled = false
 		}
+		if b.Len() > 0 {
+			va, err = readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			peer.Pubkey = string(va)
+			va, err = readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			peer.Fingerprint = string(va)
+		}
 		return &peer, nil
 
-	case APITypePeerArray:
+	case APITypePeerArray, APITypePeerIDArray:
 		var peers []Peer
-		l, n := binary.Uvarint(v)
-		if n == 0 {
-			return nil, ErrInputTooShort
-		} else if n < 0 {
-			return nil, ErrLenOverflow
+		l, b, err := readCount(v)
+		if err != nil {
+			return nil, err
 		}
-		b := bytes.NewReader(v[n:])
 		for i := uint64(0); i < l; i++ {
 			var peer Peer
 			va, err := readLV(b)
@@ -597,6 +627,18 @@ func deserialize(r bytesReader) (interface{}, error) {
 			} else {
 				peer.Enabled = false
 			}
+			if t == APITypePeerIDArray {
+				va, err = readLV(b)
+				if err != nil {
+					return nil, err
+				}
+				peer.Pubkey = string(va)
+				va, err = readLV(b)
+				if err != nil {
+					return nil, err
+				}
+				peer.Fingerprint = string(va)
+			}
 			peers = append(peers, peer)
 		}
 		return peers, nil
@@ -614,9 +656,33 @@ func deserialize(r bytesReader) (interface{}, error) {
 			return nil, err
 		}
 		bundle.Time = vint
+		if b.Len() > 0 {
+			stamp, err := readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			bundle.Stamp = stamp
+		}
 		return bundle, nil
 	}
-	return nil, errors.New("Unknown Type")
+	return nil, ErrUnknownType
+}
+
+// readCount - reads the element count at the start of an array, each element takes at least one byte
+func readCount(v []byte) (uint64, *bytes.Reader, error) {
+	l, n := binary.Uvarint(v)
+	if n == 0 {
+		return 0, nil, ErrInputTooShort
+	} else if n < 0 {
+		return 0, nil, ErrLenOverflow
+	}
+	if l > MaxElements {
+		return 0, nil, ErrTooManyElements
+	}
+	if l > uint64(len(v)-n) {
+		return 0, nil, ErrInputTooShort
+	}
+	return l, bytes.NewReader(v[n:]), nil
 }
 
 func writeTLV(w io.Writer, typ byte, value []byte) {
@@ -641,6 +707,12 @@ func readLV(r bytesReader) ([]byte, error) {
 	if l == 0 {
 		return nil, nil
 	}
+	if l > MaxFrameSize {
+		return nil, ErrTooLarge
+	}
+	if lr, ok := r.(interface{ Len() int }); ok && l > uint64(lr.Len()) {
+		return nil, ErrInputTooShort // do not allocate for a length the input cannot hold
+	}
 	v := make([]byte, l)
 	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
 		return nil, err
@@ -663,7 +735,10 @@ func ReadBuffer(reader io.Reader) (*[]byte, error) {
 	}
 	rlen, err := binary.ReadUvarint(br)
 	if err != nil {
-		return nil, err
+		return nil, wireError("frame length", err)
+	}
+	if rlen > MaxFrameSize {
+		return nil, wireError("frame", ErrTooLarge)
 	}
 	buf := make([]byte, rlen)
 	n, err := io.ReadFull(reader, buf)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 )
@@ -101,3 +102,48 @@ func Test_ResponseRoundTrip_2(t *testing.T) {
 		t.Fatal("Before and After Errors do not match")
 	}
 }
+
+func Test_RoundTrip_PeerIdentity_1(t *testing.T) {
+	peer := &Peer{Name: "p1", URI: "localhost:20001", Enabled: true, Pubkey: "pub", Fingerprint: "fp"}
+	rr := RemoteResponse{Value: peer}
+	v, err := RemoteResponseFromBytes(RemoteResponseToBytes(&rr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Value.(*Peer); *got != *peer {
+		t.Fatalf("Peer mismatch: %+v\n", got)
+	}
+
+	// arrays without identities keep the old encoding
+	peers := []Peer{{Name: "a", URI: "a:1"}, {Name: "b", URI: "b:1", Enabled: true}}
+	b := RemoteResponseToBytes(&RemoteResponse{Value: peers})
+	if (*b)[2] != APITypePeerArray { // after the empty Error string
+		t.Fatal("Expected the legacy peer array type")
+	}
+	peers[1].Fingerprint = "fp"
+	v, err = RemoteResponseFromBytes(RemoteResponseToBytes(&RemoteResponse{Value: peers}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := v.Value.([]Peer)
+	if len(got) != 2 || got[0] != peers[0] || got[1] != peers[1] {
+		t.Fatalf("Peer array mismatch: %+v\n", got)
+	}
+}
+
+func Test_RoundTrip_BundleStamp_1(t *testing.T) {
+	for _, bundle := range []Bundle{
+		{Data: []byte("data"), Time: 42},
+		{Data: []byte("data"), Time: 42, Stamp: []byte("0123456789abcdef")},
+	} {
+		call := RemoteCall{Action: Dropoff, Args: []interface{}{bundle}}
+		rc, err := RemoteCallFromBytes(RemoteCallToBytes(&call))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := rc.Args[0].(Bundle)
+		if !bytes.Equal(got.Data, bundle.Data) || got.Time != bundle.Time || !bytes.Equal(got.Stamp, bundle.Stamp) {
+			t.Fatalf("Bundle mismatch: %+v\n", got)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package reqrep
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// magic : prefix of request and response envelopes, followed by their kind and their fields encoded with api.ArgsToBytes
+var magic = []byte("ratrr")
+
+const (
+	kindRequest  byte = 1
+	kindResponse byte = 2
+)
+
+// IDSize : bytes in a correlation ID
+const IDSize = 16
+
+// ErrNotRequest : returned by ParseRequest for messages that are not requests
+var ErrNotRequest = errors.New("Not a request")
+
+// ErrUnknownReplyTo : a request asked for its response to be sent to a key that is not one of the node's channels or contacts
+var ErrUnknownReplyTo = errors.New("Reply-to is not a known channel or contact")
+
+// ResponseError : the error a responder replied with instead of content
+type ResponseError struct {
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// ReplyTo : where the responses to a request are sent
+type ReplyTo struct {
+	Name   string    // channel name, or for direct replies the profile name or api.ContentName they arrive under
+	IsChan bool      // responses are sent to a channel
+	PubKey bc.PubKey // channel, profile or content key responses are encrypted to
+}
+
+// Filter : selects the messages that arrive for this ReplyTo on a node's MsgBus
+func (r ReplyTo) Filter() api.MsgFilter {
+	return api.MsgFilter{Name: r.Name, IsChan: r.IsChan}
+}
+
+// ReplyToChannel : returns a ReplyTo for responses sent to one of the node's channels
+func ReplyToChannel(node api.Node, channelName string) (ReplyTo, error) {
+	c, err := node.GetChannel(channelName)
+	if err != nil {
+		return ReplyTo{}, err
+	}
+	key, err := pubKey(node, c.Pubkey)
+	return ReplyTo{Name: channelName, IsChan: true, PubKey: key}, err
+}
+
+// ReplyToProfile : returns a ReplyTo for responses sent directly to one of the node's profiles,
+// which the node's router must check for, e.g. with DefaultRouter.CheckProfiles
+func ReplyToProfile(node api.Node, profileName string) (ReplyTo, error) {
+	p, err := node.GetProfile(profileName)
+	if err != nil {
+		return ReplyTo{}, err
+	}
+	key, err := pubKey(node, p.Pubkey)
+	return ReplyTo{Name: profileName, PubKey: key}, err
+}
+
+// ReplyToContent : returns a ReplyTo for responses sent directly to the node's content key
+func ReplyToContent(node api.Node) (ReplyTo, error) {
+	key, err := node.CID()
+	return ReplyTo{Name: api.ContentName, PubKey: key}, err
+}
+
+// pubKey - decodes a public key of the same type as the node's content key
+func pubKey(node api.Node, b64 string) (bc.PubKey, error) {
+	cid, err := node.CID()
+	if err != nil {
+		return nil, err
+	}
+	key := cid.Clone()
+	if err := key.FromB64(b64); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Requester : sends requests from a node and matches the responses to them
+type Requester struct {
+	node    api.Node
+	replyTo ReplyTo
+
+	mu      sync.Mutex
+	pending map[string]chan response
+}
+
+type response struct {
+	msg api.Msg
+	err error
+}
+
+// NewRequester : returns a Requester whose requests ask for responses to be sent to replyTo
+func NewRequester(node api.Node, replyTo ReplyTo) *Requester {
+	return &Requester{node: node, replyTo: replyTo, pending: make(map[string]chan response)}
+}
+
+// Request : sends msg, addressed as for SendMsg, with a new correlation ID, and waits for the response or for ctx to be done,
+// a responder's error is returned as a *ResponseError
+func (r *Requester) Request(ctx context.Context, msg api.Msg) (api.Msg, error) {
+	id := make([]byte, IDSize)
+	if _, err := rand.Read(id); err != nil {
+		return api.Msg{}, err
+	}
+	c := make(chan response, 1)
+	r.mu.Lock()
+	r.pending[string(id)] = c
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, string(id))
+		r.mu.Unlock()
+	}()
+
+	var payload []byte
+	if msg.Content != nil {
+		payload = msg.Content.Bytes()
+	}
+	var isChan int64
+	var channelName string
+	if r.replyTo.IsChan {
+		isChan, channelName = 1, r.replyTo.Name
+	}
+	msg.Content = bytes.NewBuffer(seal(kindRequest, []interface{}{id, isChan, channelName, r.replyTo.PubKey.ToB64(), payload}))
+	if err := r.node.SendMsg(msg); err != nil {
+		return api.Msg{}, err
+	}
+	select {
+	case resp := <-c:
+		return resp.msg, resp.err
+	case <-ctx.Done():
+		return api.Msg{}, ctx.Err()
+	}
+}
+
+// Dispatch : hands a received message, e.g. one read from Out(), to the request waiting for it,
+// returns false if it is not a response, responses nobody waits for any more are dropped
+func (r *Requester) Dispatch(msg api.Msg) bool {
+	kind, args, err := open(msg)
+	if err != nil || kind != kindResponse {
+		return false
+	}
+	if len(args) < 3 {
+		return true
+	}
+	id, ok1 := args[0].([]byte)
+	errString, ok2 := args[1].(string)
+	payload, ok3 := args[2].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return true
+	}
+	r.mu.Lock()
+	c, ok := r.pending[string(id)]
+	delete(r.pending, string(id))
+	r.mu.Unlock()
+	if !ok {
+		events.Debug(r.node, "reqrep: dropped a response nobody waits for")
+		return true
+	}
+	msg.Content = bytes.NewBuffer(payload)
+	resp := response{msg: msg}
+	if errString != "" {
+		resp.err = &ResponseError{Message: errString}
+	}
+	c <- resp
+	return true
+}
+
+// Listen : dispatches the responses that arrive for the Requester's ReplyTo on the node's MsgBus, until the
+// returned subscription is closed, it takes every message the ReplyTo selects, so use a channel or profile
+// that only receives responses, or call Dispatch from the loop that reads Out() instead
+func (r *Requester) Listen(size int) *api.MsgSubscription {
+	return r.node.MsgBus().HandleFunc(r.replyTo.Filter(), size, api.Block, func(msg api.Msg) {
+		r.Dispatch(msg)
+	})
+}
+
+// Request : a request received by a responder
+type Request struct {
+	ID      []byte
+	ReplyTo ReplyTo
+	Msg     api.Msg // the request as received, with the content the requester sent
+}
+
+// ParseRequest : decodes a received request, returns ErrNotRequest for other messages.
+// The ReplyTo is whatever the sender put in the request, check it with Known before replying to it.
+func ParseRequest(node api.Node, msg api.Msg) (*Request, error) {
+	kind, args, err := open(msg)
+	if err != nil || kind != kindRequest {
+		return nil, ErrNotRequest
+	}
+	if len(args) < 5 {
+		return nil, &api.WireError{Op: "request", Err: api.ErrInputTooShort}
+	}
+	id, ok1 := args[0].([]byte)
+	isChan, ok2 := args[1].(int64)
+	channelName, ok3 := args[2].(string)
+	key, ok4 := args[3].(string)
+	payload, ok5 := args[4].([]byte)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || len(id) != IDSize {
+		return nil, &api.WireError{Op: "request", Err: api.ErrUnexpectedType}
+	}
+	req := &Request{ID: id, Msg: msg}
+	req.ReplyTo.IsChan, req.ReplyTo.Name = isChan != 0, channelName
+	if req.ReplyTo.PubKey, err = pubKey(node, key); err != nil {
+		return nil, err
+	}
+	req.Msg.Content = bytes.NewBuffer(payload)
+	return req, nil
+}
+
+// Known : reports whether a ReplyTo is one of the node's channels, or the key of one of its contacts
+func Known(node api.Node, r ReplyTo) (bool, error) {
+	key := r.PubKey.ToB64()
+	if r.IsChan {
+		c, err := node.GetChannel(r.Name)
+		if err != nil || c == nil {
+			return false, nil // no such channel
+		}
+		return c.Pubkey == key, nil
+	}
+	contacts, err := node.GetContacts()
+	if err != nil {
+		return false, err
+	}
+	for _, c := range contacts {
+		if c.Pubkey == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Reply : sends the response to a request, a non-nil replyErr is sent instead of content and returned to the requester,
+// the response goes to the request's ReplyTo as given by its sender
+func Reply(node api.Node, req *Request, content []byte, replyErr error) error {
+	var errString string
+	if replyErr != nil {
+		errString, content = replyErr.Error(), nil
+	}
+	msg := api.Msg{IsChan: req.ReplyTo.IsChan, PubKey: req.ReplyTo.PubKey}
+	if msg.IsChan {
+		msg.Name = req.ReplyTo.Name
+	}
+	msg.Content = bytes.NewBuffer(seal(kindResponse, []interface{}{req.ID, errString, content}))
+	return node.SendMsg(msg)
+}
+
+// Serve : answers the requests selected by filter on the node's MsgBus with fn, one at a time, until the returned
+// subscription is closed, other messages the filter selects are dropped. So that the node cannot be used to send
+// to arbitrary keys, requests are only answered if their ReplyTo is Known, use ParseRequest and Reply for others.
+func Serve(node api.Node, filter api.MsgFilter, size int, fn func(req *Request) ([]byte, error)) *api.MsgSubscription {
+	return node.MsgBus().HandleFunc(filter, size, api.Block, func(msg api.Msg) {
+		req, err := ParseRequest(node, msg)
+		if err == ErrNotRequest {
+			return // e.g. a response, when the node also Listens on the same filter
+		} else if err != nil {
+			events.Warning(node, "reqrep: "+err.Error())
+			return
+		}
+		if ok, err := Known(node, req.ReplyTo); err != nil || !ok {
+			if err == nil {
+				err = ErrUnknownReplyTo
+			}
+			events.Warning(node, "reqrep: request dropped: "+err.Error())
+			return
+		}
+		content, replyErr := fn(req)
+		if err := Reply(node, req, content, replyErr); err != nil {
+			events.Warning(node, "reqrep: reply failed: "+err.Error())
+		}
+	})
+}
+
+func seal(kind byte, args []interface{}) []byte {
+	b := append(append([]byte{}, magic...), kind)
+	return append(b, api.ArgsToBytes(args)...)
+}
+
+// open - returns the kind and fields of an envelope
+func open(msg api.Msg) (byte, []interface{}, error) {
+	if msg.Content == nil {
+		return 0, nil, ErrNotRequest
+	}
+	b := msg.Content.Bytes()
+	if len(b) <= len(magic) || !bytes.HasPrefix(b, magic) {
+		return 0, nil, ErrNotRequest
+	}
+	args, err := api.ArgsFromBytes(b[len(magic)+1:])
+	return b[len(magic)], args, err
+}
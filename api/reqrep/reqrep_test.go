@@ -0,0 +1,158 @@
+package reqrep
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+// pump - moves messages from one node's outbox to the other, until quit is closed
+func pump(from, to *ram.Node, quit chan struct{}) {
+	rpk, _ := to.ID()
+	var last int64
+	for {
+		select {
+		case <-quit:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+		bundle, err := from.Pickup(rpk, last, 1<<20)
+		if err != nil {
+			continue
+		}
+		if len(bundle.Data) > 0 {
+			to.Dropoff(bundle)
+		}
+		last = bundle.Time
+	}
+}
+
+func Test_RequestReply(t *testing.T) {
+	a := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	b := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	for _, n := range []*ram.Node{a, b} {
+		if err := n.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer n.Stop()
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	go pump(a, b, quit)
+	go pump(b, a, quit)
+
+	aKey, _ := a.CID()
+	if err := b.AddContact("a", aKey.ToB64()); err != nil {
+		t.Fatal(err)
+	}
+	serve := Serve(b, api.ContentFilter(), 8, func(req *Request) ([]byte, error) {
+		if req.Msg.Content.String() == "fail" {
+			return nil, errors.New("failed")
+		}
+		return append([]byte("re: "), req.Msg.Content.Bytes()...), nil
+	})
+	defer serve.Close()
+	bKey, _ := b.CID()
+
+	// direct replies to a's content key
+	replyTo, err := ReplyToContent(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct := NewRequester(a, replyTo)
+	defer direct.Listen(8).Close()
+
+	// channel replies
+	chanKey := new(ecc.KeyPair)
+	chanKey.GenerateKey()
+	for _, n := range []*ram.Node{a, b} {
+		if err := n.AddChannel("replies", chanKey.ToB64()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if replyTo, err = ReplyToChannel(a, "replies"); err != nil {
+		t.Fatal(err)
+	}
+	channel := NewRequester(a, replyTo)
+	defer channel.Listen(8).Close()
+
+	for _, r := range []*Requester{direct, channel} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		resp, err := r.Request(ctx, api.Msg{PubKey: bKey, Content: bytes.NewBufferString("hello")})
+		if err != nil || resp.Content.String() != "re: hello" {
+			t.Fatalf("Request returned %+v, %v", resp, err)
+		}
+		if resp.IsChan != r.replyTo.IsChan {
+			t.Fatalf("response IsChan %v, expected %v", resp.IsChan, r.replyTo.IsChan)
+		}
+		_, err = r.Request(ctx, api.Msg{PubKey: bKey, Content: bytes.NewBufferString("fail")})
+		if re, ok := err.(*ResponseError); !ok || re.Message != "failed" {
+			t.Fatalf("Request of a failing handler returned %v", err)
+		}
+		cancel()
+	}
+
+	// nobody serves a's own content key, so the request times out
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := direct.Request(ctx, api.Msg{PubKey: aKey, Content: bytes.NewBufferString("x")}); err != context.DeadlineExceeded {
+		t.Fatalf("Request without a responder returned %v", err)
+	}
+
+	direct.mu.Lock()
+	pending := len(direct.pending)
+	direct.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("%d requests still pending after a timeout", pending)
+	}
+}
+
+func Test_Dispatch(t *testing.T) {
+	node := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	replyTo, _ := ReplyToContent(node)
+	r := NewRequester(node, replyTo)
+	if r.Dispatch(api.Msg{Content: bytes.NewBufferString("not a response")}) {
+		t.Fatal("Dispatch took a message that is not a response")
+	}
+	if _, err := ParseRequest(node, api.Msg{Content: bytes.NewBufferString("not a request")}); err != ErrNotRequest {
+		t.Fatal("ParseRequest returned", err)
+	}
+
+	// a response nobody waits for is taken and dropped
+	id := make([]byte, IDSize)
+	resp := api.Msg{Content: bytes.NewBuffer(seal(kindResponse, []interface{}{id, "", []byte("late")}))}
+	if !r.Dispatch(resp) {
+		t.Fatal("Dispatch did not take a response")
+	}
+}
+
+func Test_Known(t *testing.T) {
+	node := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	contact, chanKey, stranger := new(ecc.KeyPair), new(ecc.KeyPair), new(ecc.KeyPair)
+	for _, k := range []*ecc.KeyPair{contact, chanKey, stranger} {
+		k.GenerateKey()
+	}
+	node.AddContact("friend", contact.GetPubKey().ToB64())
+	node.AddChannel("replies", chanKey.ToB64())
+
+	for _, c := range []struct {
+		replyTo ReplyTo
+		known   bool
+	}{
+		{ReplyTo{Name: api.ContentName, PubKey: contact.GetPubKey()}, true},
+		{ReplyTo{Name: api.ContentName, PubKey: stranger.GetPubKey()}, false},
+		{ReplyTo{Name: "replies", IsChan: true, PubKey: chanKey.GetPubKey()}, true},
+		{ReplyTo{Name: "replies", IsChan: true, PubKey: stranger.GetPubKey()}, false},
+		{ReplyTo{Name: "other", IsChan: true, PubKey: chanKey.GetPubKey()}, false},
+	} {
+		if known, err := Known(node, c.replyTo); err != nil || known != c.known {
+			t.Fatalf("Known(%+v) returned %v, %v", c.replyTo, known, err)
+		}
+	}
+}
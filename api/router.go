@@ -12,6 +12,14 @@ type Router interface {
 	JSON
 }
 
+// Mixer : optionally implemented by a Router that holds outgoing messages in a mix pool
+type Mixer interface {
+	// MixLocal : Hand a message this node originated to the mix pool, returns false if the node should queue it at once
+	MixLocal(node Node, msg Msg) (bool, error)
+	// FlushMix : Forward the messages the mix pool holds for node at once, called when the node stops
+	FlushMix(node Node) error
+}
+
 // Patch : defines a mapping from an incoming channel to one or more destination channels.
 type Patch struct {
 	From string
@@ -25,4 +33,8 @@ const (
 	ChunkedFlag = 0x02
 	// ChannelFlag : this message has a channel name prefix
 	ChannelFlag = 0x04
+	// OnionFlag : this message is an onion layer, only the relay holding the routing key can peel it
+	OnionFlag = 0x08
+	// TaggedFlag : the channel name prefix is a ChannelTag instead of the name
+	TaggedFlag = 0x10
 )
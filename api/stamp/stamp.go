@@ -0,0 +1,158 @@
+package stamp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Size : length in bytes of a stamp, an 8 byte time window followed by an 8 byte nonce
+const Size = 16
+
+// Window : length of the time window a stamp is bound to,
+// stamps from the current, previous and next window are accepted to allow for clock skew
+const Window = 5 * time.Minute
+
+// MaxDifficulty : highest difficulty a sender will mint for, larger values would stall the sender
+const MaxDifficulty = 32
+
+var (
+	// ErrMissing : a stamp is required but none was provided
+	ErrMissing = errors.New("Proof-of-work stamp required")
+	// ErrInvalid : the stamp is malformed or does not meet the difficulty
+	ErrInvalid = errors.New("Proof-of-work stamp invalid")
+	// ErrExpired : the stamp was minted for a different time window
+	ErrExpired = errors.New("Proof-of-work stamp expired")
+	// ErrTooDifficult : the requested difficulty is above MaxDifficulty, or the sender's own limit
+	ErrTooDifficult = errors.New("Proof-of-work difficulty too high")
+	// ErrReplayed : the stamp was already accepted once
+	ErrReplayed = errors.New("Proof-of-work stamp already used")
+)
+
+// Mint : finds a hashcash-style stamp for data sent to the node with routing key rpub (b64) with at least difficulty leading zero bits
+// The stamp is bound to the receiving node, the SHA-256 of the data, and the current time window,
+// so it cannot be reused for other bundles, other nodes, or long after it was minted.
+func Mint(rpub string, data []byte, difficulty int, now time.Time) ([]byte, error) {
+	return MintContext(context.Background(), rpub, data, difficulty, now)
+}
+
+// MintContext : Mint, giving up with the context's error once ctx is done
+func MintContext(ctx context.Context, rpub string, data []byte, difficulty int, now time.Time) ([]byte, error) {
+	if difficulty > MaxDifficulty {
+		return nil, ErrTooDifficult
+	}
+	s := make([]byte, Size)
+	if _, err := rand.Read(s[8:]); err != nil { // a random start, so minting again for the same bundle finds a fresh stamp
+		return nil, err
+	}
+	start := binary.BigEndian.Uint64(s[8:])
+	binary.BigEndian.PutUint64(s, uint64(window(now)))
+	prefix := prefix(rpub, data)
+	for nonce := start; ; nonce++ {
+		if (nonce-start)&0xfff == 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+		}
+		binary.BigEndian.PutUint64(s[8:], nonce)
+		if zeroBits(prefix, s) >= difficulty {
+			return s, nil
+		}
+	}
+}
+
+// Verify : checks that stamp is valid for data sent to the node with routing key rpub (b64) at the given difficulty
+func Verify(stamp []byte, rpub string, data []byte, difficulty int, now time.Time) error {
+	if difficulty <= 0 {
+		return nil
+	}
+	if len(stamp) == 0 {
+		return ErrMissing
+	}
+	if len(stamp) != Size {
+		return ErrInvalid
+	}
+	w := int64(binary.BigEndian.Uint64(stamp))
+	if cur := window(now); w < cur-1 || w > cur+1 {
+		return ErrExpired
+	}
+	if zeroBits(prefix(rpub, data), stamp) < difficulty {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Cache : the stamps a node accepted, so each is only accepted once, entries are dropped once their window can no longer verify
+// The proof-of-work each stamp costs bounds how fast the cache can grow.
+type Cache struct {
+	mux  sync.Mutex
+	seen map[int64]map[[sha256.Size]byte]struct{} // by time window
+}
+
+// NewCache : returns an empty stamp cache
+func NewCache() *Cache {
+	return &Cache{seen: make(map[int64]map[[sha256.Size]byte]struct{})}
+}
+
+// Verify : checks a stamp like Verify, and returns ErrReplayed for a stamp this cache already accepted
+func (c *Cache) Verify(stamp []byte, rpub string, data []byte, difficulty int, now time.Time) error {
+	if err := Verify(stamp, rpub, data, difficulty, now); err != nil || difficulty <= 0 {
+		return err
+	}
+	w := int64(binary.BigEndian.Uint64(stamp))
+	key := sha256.Sum256(append(prefix(rpub, data), stamp...))
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	cur := window(now)
+	for k := range c.seen {
+		if k < cur-1 {
+			delete(c.seen, k)
+		}
+	}
+	m, ok := c.seen[w]
+	if !ok {
+		m = make(map[[sha256.Size]byte]struct{})
+		c.seen[w] = m
+	}
+	if _, ok := m[key]; ok {
+		return ErrReplayed
+	}
+	m[key] = struct{}{}
+	return nil
+}
+
+func window(now time.Time) int64 {
+	return now.Unix() / int64(Window/time.Second)
+}
+
+// prefix : the part of the hashed input that does not change while minting
+func prefix(rpub string, data []byte) []byte {
+	dataHash := sha256.Sum256(data)
+	p := make([]byte, 0, len(rpub)+len(dataHash))
+	p = append(p, rpub...)
+	return append(p, dataHash[:]...)
+}
+
+// zeroBits : number of leading zero bits in the SHA-256 of prefix and stamp
+func zeroBits(prefix, stamp []byte) int {
+	h := sha256.New()
+	h.Write(prefix)
+	h.Write(stamp)
+	sum := h.Sum(nil)
+	n := 0
+	for i := 0; i < len(sum); i += 8 {
+		z := bits.LeadingZeros64(binary.BigEndian.Uint64(sum[i:]))
+		n += z
+		if z < 64 {
+			break
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package stamp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_stamp_MintVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	data := []byte("bundle data")
+	s, err := Mint("routingkey", data, 12, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(s, "routingkey", data, 12, now.Add(Window)); err != nil {
+		t.Fatal("valid stamp rejected:", err)
+	}
+	if err := Verify(s, "otherkey", data, 12, now); err != ErrInvalid {
+		t.Fatal("stamp for another node accepted:", err)
+	}
+	if err := Verify(s, "routingkey", []byte("other data"), 12, now); err != ErrInvalid {
+		t.Fatal("stamp for other data accepted:", err)
+	}
+	if err := Verify(s, "routingkey", data, 12, now.Add(3*Window)); err != ErrExpired {
+		t.Fatal("stale stamp accepted:", err)
+	}
+	if err := Verify(nil, "routingkey", data, 12, now); err != ErrMissing {
+		t.Fatal("missing stamp accepted:", err)
+	}
+	if err := Verify(nil, "routingkey", data, 0, now); err != nil {
+		t.Fatal("stamp required at difficulty 0:", err)
+	}
+	if _, err := Mint("routingkey", data, MaxDifficulty+1, now); err != ErrTooDifficult {
+		t.Fatal("expected ErrTooDifficult, got", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := MintContext(ctx, "routingkey", data, MaxDifficulty, now); err != context.Canceled {
+		t.Fatal("cancelled mint returned", err)
+	}
+}
+
+func Test_stamp_Cache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	data := []byte("bundle data")
+	s, err := Mint("routingkey", data, 8, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache()
+	if err := c.Verify(s, "routingkey", data, 8, now); err != nil {
+		t.Fatal("valid stamp rejected:", err)
+	}
+	again, _ := Mint("routingkey", data, 8, now) // minting again for the same bundle finds another stamp
+	if err := c.Verify(again, "routingkey", data, 8, now); err != nil {
+		t.Fatal("second stamp for the same bundle rejected:", err)
+	}
+	if err := c.Verify(s, "routingkey", data, 8, now.Add(Window)); err != ErrReplayed {
+		t.Fatal("replayed stamp accepted:", err)
+	}
+	if err := c.Verify(s, "otherkey", data, 8, now); err != ErrInvalid {
+		t.Fatal("stamp for another node accepted:", err)
+	}
+	later := now.Add(3 * Window)
+	if err := c.Verify(s, "routingkey", data, 8, later); err != ErrExpired {
+		t.Fatal("stale stamp accepted:", err)
+	}
+	s2, _ := Mint("routingkey", data, 8, later)
+	if err := c.Verify(s2, "routingkey", data, 8, later); err != nil || len(c.seen) != 1 {
+		t.Fatal("expired stamps not dropped from the cache:", err, len(c.seen))
+	}
+}
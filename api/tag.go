@@ -0,0 +1,47 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// TagEpoch : how long a channel tag is used before it rotates, so tags cannot be linked over long periods
+const TagEpoch = time.Hour
+
+// TagSize : length in bytes of the HMAC in a channel tag
+const TagSize = 16
+
+// ChannelTag : returns the tag for a channel, identified by its private key (b64), in the epoch containing t.
+// The tag is the epoch followed by an HMAC of it keyed with a hash of the channel private key, hex encoded,
+// so only members of the channel can compute or recognize it. The epoch is carried in the tag, so a message
+// can still be matched however long it was queued or relayed.
+func ChannelTag(privkey string, t time.Time) string {
+	epoch := t.UnixNano() / int64(TagEpoch)
+	b := make([]byte, 8, 8+TagSize)
+	binary.BigEndian.PutUint64(b, uint64(epoch))
+	return hex.EncodeToString(append(b, tagMAC(privkey, epoch)...))
+}
+
+// MatchChannelTag : reports whether tag belongs to the channel with this private key (b64),
+// tags from more than one epoch after now are refused, which allows for clock skew
+func MatchChannelTag(privkey, tag string, now time.Time) bool {
+	b, err := hex.DecodeString(tag)
+	if err != nil || len(b) != 8+TagSize {
+		return false
+	}
+	epoch := int64(binary.BigEndian.Uint64(b[:8]))
+	if epoch > now.UnixNano()/int64(TagEpoch)+1 {
+		return false
+	}
+	return hmac.Equal(b[8:], tagMAC(privkey, epoch))
+}
+
+func tagMAC(privkey string, epoch int64) []byte {
+	key := sha256.Sum256([]byte(privkey))
+	m := hmac.New(sha256.New, key[:])
+	binary.Write(m, binary.BigEndian, epoch)
+	return m.Sum(nil)[:TagSize]
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func Test_ChannelTag(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tag := ChannelTag("channelkey", now)
+	if len(tag) != 2*(8+TagSize) {
+		t.Fatal("tag length", len(tag))
+	}
+	if ChannelTag("otherkey", now) == tag {
+		t.Fatal("two channels share a tag")
+	}
+	if ChannelTag("channelkey", now.Add(TagEpoch)) == tag {
+		t.Fatal("tag did not rotate")
+	}
+	for _, age := range []time.Duration{-TagEpoch, 0, TagEpoch, 30 * 24 * time.Hour} {
+		if !MatchChannelTag("channelkey", tag, now.Add(age)) {
+			t.Fatal("tag not matched after", age)
+		}
+	}
+	if MatchChannelTag("channelkey", tag, now.Add(-2*TagEpoch)) {
+		t.Fatal("tag from the future matched")
+	}
+	if MatchChannelTag("otherkey", tag, now) {
+		t.Fatal("tag matched another channel")
+	}
+	b, _ := hex.DecodeString(tag)
+	b[7]-- // the previous epoch, with the same HMAC
+	if MatchChannelTag("channelkey", hex.EncodeToString(b), now) {
+		t.Fatal("tag matched with a changed epoch")
+	}
+	for _, bad := range []string{"", "zz", tag[:len(tag)-2]} {
+		if MatchChannelTag("channelkey", bad, now) {
+			t.Fatalf("malformed tag %q matched", bad)
+		}
+	}
+}
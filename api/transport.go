@@ -1,5 +1,7 @@
 package api
 
+import "errors"
+
 // Transport - Interface to implement in a RatNet-compatable pluggable transport module
 type Transport interface {
 	Listen(listen string, adminMode bool)
@@ -13,6 +15,15 @@ type Transport interface {
 	JSON
 }
 
+// CertificatePinning : implemented by Transports that can verify the certificate presented by a remote host
+type CertificatePinning interface {
+	// PinCertificate : require host to present a certificate with this hex SHA-256 fingerprint, "" removes the pin
+	PinCertificate(host string, fingerprint string)
+}
+
+// ErrPeerIdentity : returned when a peer presents a routing key or certificate other than the one pinned for it
+var ErrPeerIdentity = errors.New("peer identity mismatch")
+
 // StreamHeader manifest for a chunked transfer (database version)
 type StreamHeader struct {
 	StreamID    uint32 `db:"streamid"`
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/bencrypt/rsa"
+)
+
+// ProtocolVersion : version of the message header and RPC encoding spoken by this node
+const ProtocolVersion = 1
+
+// Capabilities : optional protocol features a node supports, exchanged with the Version action
+type Capabilities uint64
+
+const (
+	// CapExtHeader : understands the extended message header (ExtFlag)
+	CapExtHeader Capabilities = 1 << iota
+	// CapHopLimit : decrements and enforces the ExtHopLimit extension
+	CapHopLimit
+	// CapCompression : can decompress content flagged with ExtCompression
+	CapCompression
+	// CapPadding : can strip padding from content flagged with ExtPadding
+	CapPadding
+	// CapCover : discards cover traffic flagged with ExtCover
+	CapCover
+	// CapOnion : peels onion layers addressed to its routing key and forwards the result
+	CapOnion
+	// CapChannelTags : finds the channel of messages flagged with TaggedFlag by their ChannelTag
+	CapChannelTags
+	// CapExpiry : drops messages from its outbox once their ExtExpiry time has passed
+	CapExpiry
+)
+
+// LocalCapabilities : the capabilities of this build, advertised to peers
+var LocalCapabilities = CapExtHeader | CapHopLimit | CapCompression | CapPadding | CapCover | CapOnion | CapChannelTags | CapExpiry
+
+// Has : reports whether all of the given capabilities are set
+func (c Capabilities) Has(caps Capabilities) bool { return c&caps == caps }
+
+// ErrBadChallenge : a Version challenge that was not encrypted to this node's routing key, or is not a challenge
+var ErrBadChallenge = errors.New("Bad version challenge")
+
+// ExtFlag : this message has an extended header, a version byte and extensions follow the flags byte
+const ExtFlag = 0x80
+
+// Extension types, the high bit marks an extension a node must understand to process the message
+const (
+	// ExtCritical : set on extension types that cannot be ignored
+	ExtCritical byte = 0x80
+
+	// ExtHopLimit : one byte, the number of further hops a message may be forwarded
+	ExtHopLimit byte = 0x01
+	// ExtCompression : one byte, the compress.Algorithm applied to the content before it was encrypted
+	ExtCompression byte = ExtCritical | 0x02
+	// ExtPadding : empty, the content was padded with padding.Pad before it was encrypted
+	ExtPadding byte = ExtCritical | 0x03
+	// ExtCover : empty, a dummy message that hides when a node has real traffic, routers discard it
+	ExtCover byte = ExtCritical | 0x04
+	// ExtExpiry : eight bytes, big-endian unix seconds after which nodes drop the message from their outbox
+	ExtExpiry byte = 0x05
+)
+
+// Extension : a typed value in an extended message header
+type Extension struct {
+	Type  byte
+	Value []byte
+}
+
+// Critical : reports whether a node must understand this extension to process the message
+func (e Extension) Critical() bool { return e.Type&ExtCritical != 0 }
+
+// extCapabilities : the capability a peer needs for each known extension
+var extCapabilities = map[byte]Capabilities{
+	ExtHopLimit:    CapHopLimit,
+	ExtCompression: CapCompression,
+	ExtPadding:     CapPadding,
+	ExtCover:       CapCover,
+	ExtExpiry:      CapExpiry,
+}
+
+// GetExtension : returns the first extension of type t, or nil
+func (m *Msg) GetExtension(t byte) *Extension {
+	for i := range m.Extensions {
+		if m.Extensions[i].Type == t {
+			return &m.Extensions[i]
+		}
+	}
+	return nil
+}
+
+// SetExtension : adds an extension to the message, replacing any of the same type
+func (m *Msg) SetExtension(t byte, value []byte) {
+	if e := m.GetExtension(t); e != nil {
+		e.Value = value
+		return
+	}
+	m.Extensions = append(m.Extensions, Extension{Type: t, Value: value})
+}
+
+// Supported : reports whether this node understands every critical extension in the header, messages that fail this can be forwarded but not handled
+func (h *MsgHeader) Supported() bool {
+	for _, e := range h.Extensions {
+		if !e.Critical() {
+			continue
+		}
+		need, known := extCapabilities[e.Type]
+		if !known || !LocalCapabilities.Has(need) {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeMsgHeader : returns the routing header for a message, the extended form is only used when it has extensions
+func EncodeMsgHeader(msg *Msg) []byte {
+	flags := uint8(0)
+	if msg.IsChan {
+		flags |= ChannelFlag
+	}
+	if msg.Chunked {
+		flags |= ChunkedFlag
+	}
+	if msg.StreamHeader {
+		flags |= StreamHeaderFlag
+	}
+	if msg.Onion {
+		flags |= OnionFlag
+	}
+	if msg.Tagged {
+		flags |= TaggedFlag
+	}
+	if len(msg.Extensions) > 0 {
+		flags |= ExtFlag
+	}
+	b := bytes.NewBuffer([]byte{flags}) // prepend flags byte
+	if len(msg.Extensions) > 0 {
+		b.WriteByte(ProtocolVersion)
+		ext := new(bytes.Buffer)
+		for _, e := range msg.Extensions {
+			ext.WriteByte(e.Type)
+			writeLV(ext, e.Value)
+		}
+		writeLV(b, ext.Bytes())
+	}
+	if msg.IsChan {
+		// prepend a uint16 of channel name length, big-endian
+		t := uint16(len(msg.Name))
+		b.WriteByte(byte(t >> 8))
+		b.WriteByte(byte(t & 0xFF))
+		b.WriteString(msg.Name)
+	}
+	return b.Bytes()
+}
+
+// parseExtensions : reads the extension block of an extended header
+func parseExtensions(block []byte) ([]Extension, error) {
+	var exts []Extension
+	r := bytes.NewReader(block)
+	for r.Len() > 0 {
+		if uint64(len(exts)) >= MaxElements {
+			return nil, ErrTooManyElements
+		}
+		t, _ := r.ReadByte()
+		v, err := readLV(r)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, Extension{Type: t, Value: v})
+	}
+	return exts, nil
+}
+
+// DowngradeMessage : rewrites a message for a peer with the given capabilities,
+// non-critical extensions the peer does not support are removed, and ok is false
+// if the message carries a critical extension the peer does not support
+func DowngradeMessage(message []byte, caps Capabilities) (out []byte, ok bool) {
+	hdr, err := ParseMsgHeader(message)
+	if err != nil {
+		return nil, false
+	}
+	if len(hdr.Extensions) == 0 {
+		return message, true
+	}
+	msg := hdr.Msg()
+	msg.Extensions = nil
+	changed := false
+	for _, e := range hdr.Extensions {
+		need, known := extCapabilities[e.Type]
+		supported := caps.Has(CapExtHeader) && (!known || caps.Has(need))
+		if supported {
+			msg.Extensions = append(msg.Extensions, e)
+		} else if e.Critical() {
+			return nil, false
+		} else {
+			changed = true
+		}
+	}
+	if !changed {
+		return message, true
+	}
+	return append(EncodeMsgHeader(&msg), message[hdr.Size:]...), true
+}
+
+// DowngradeMessages : applies DowngradeMessage to each message, leaving out those the peer cannot process
+func DowngradeMessages(msgs [][]byte, caps Capabilities) [][]byte {
+	out := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		if d, ok := DowngradeMessage(m, caps); ok {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// PeerVersion : the protocol version and capabilities reported by a peer
+type PeerVersion struct {
+	Version int64
+	Caps    Capabilities
+}
+
+// MaxPeerVersions : number of peers remembered by a version registry
+var MaxPeerVersions = 10000
+
+// versionChallengePrefix : starts the plaintext of every Version challenge, a node only answers challenges with it,
+// so the exchange cannot be used to learn anything about other messages to its routing key
+var versionChallengePrefix = []byte("ratnet version challenge ")
+
+// versionTokenSize : random bytes in a Version challenge
+const versionTokenSize = 16
+
+// PeerVersions : the versions a node's peers reported, by routing public key (b64), each node keeps its own,
+// a version is only recorded for a key that is pinned or that answered a challenge, see Challenge and Confirm
+type PeerVersions struct {
+	mux     sync.Mutex
+	m       map[string]PeerVersion
+	pending map[string]pendingVersion
+}
+
+// pendingVersion : a version a caller reported, held until it answers the challenge to its routing key
+type pendingVersion struct {
+	v     PeerVersion
+	token []byte
+}
+
+// NewPeerVersions : returns an empty version registry
+func NewPeerVersions() *PeerVersions {
+	return &PeerVersions{m: make(map[string]PeerVersion), pending: make(map[string]pendingVersion)}
+}
+
+// Set : records the version of a peer whose routing key is authenticated
+func (p *PeerVersions) Set(routingPub string, v PeerVersion) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.m[routingPub]; !ok && len(p.m) >= MaxPeerVersions {
+		for k := range p.m { // forget an arbitrary peer, it will report again on its next poll
+			delete(p.m, k)
+			break
+		}
+	}
+	p.m[routingPub] = v
+}
+
+// Get : returns the version a peer reported, peers that never reported are treated as version 0 with no capabilities
+func (p *PeerVersions) Get(routingPub string) PeerVersion {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.m[routingPub]
+}
+
+// Challenge : holds the version a caller reported for routingPub until it answers, returns the challenge to send it,
+// a random token only the holder of the routing key can read
+func (p *PeerVersions) Challenge(routingPub bc.PubKey, v PeerVersion) ([]byte, error) {
+	token, challenge, err := NewVersionChallenge(routingPub)
+	if err != nil {
+		return nil, err
+	}
+	key := routingPub.ToB64()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.pending[key]; !ok && len(p.pending) >= MaxPeerVersions {
+		for k := range p.pending {
+			delete(p.pending, k)
+			break
+		}
+	}
+	p.pending[key] = pendingVersion{v: v, token: token}
+	return challenge, nil
+}
+
+// Confirm : records the version held for routingPub if answer is ChallengeAnswer of its token and that version,
+// each challenge is only answered once
+func (p *PeerVersions) Confirm(routingPub string, answer []byte) bool {
+	p.mux.Lock()
+	pv, ok := p.pending[routingPub]
+	delete(p.pending, routingPub)
+	p.mux.Unlock()
+	if !ok || !hmac.Equal(answer, ChallengeAnswer(pv.token, pv.v)) {
+		return false
+	}
+	p.Set(routingPub, pv.v)
+	return true
+}
+
+// NewVersionChallenge : returns a random token and the challenge carrying it, encrypted to routingPub
+func NewVersionChallenge(routingPub bc.PubKey) (token, challenge []byte, err error) {
+	token = make([]byte, versionTokenSize)
+	if _, err = rand.Read(token); err != nil {
+		return nil, nil, err
+	}
+	clear := append(append([]byte{}, versionChallengePrefix...), token...)
+	if _, ok := routingPub.(*rsa.PubKey); ok { // both ciphers encrypt with an ephemeral key, no key pair of our own is needed
+		challenge, err = new(rsa.KeyPair).EncryptMessage(clear, routingPub)
+	} else {
+		challenge, err = new(ecc.KeyPair).EncryptMessage(clear, routingPub)
+	}
+	return token, challenge, err
+}
+
+// ChallengeAnswer : the answer to a Version challenge, binds the token to the version the answering node reports,
+// so a challenge passed on to another node cannot vouch for a version that node did not report
+func ChallengeAnswer(token []byte, v PeerVersion) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write(ArgsToBytes(VersionToArgs(v)))
+	return mac.Sum(nil)
+}
+
+// AnswerChallenge : opens a Version challenge to the node's routing key and answers it for the version v
+func AnswerChallenge(node Node, challenge []byte, v PeerVersion) ([]byte, error) {
+	ok, clear, err := node.PeelOnion(Msg{Content: bytes.NewBuffer(challenge)})
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(clear) != len(versionChallengePrefix)+versionTokenSize || !bytes.HasPrefix(clear, versionChallengePrefix) {
+		return nil, ErrBadChallenge
+	}
+	return ChallengeAnswer(clear[len(versionChallengePrefix):], v), nil
+}
+
+// VersionToArgs : encodes a PeerVersion as the arguments or result of the Version action
+func VersionToArgs(v PeerVersion) []interface{} {
+	return []interface{}{v.Version, uint64(v.Caps)}
+}
+
+// VersionFromArgs : decodes the arguments or result of the Version action
+func VersionFromArgs(args []interface{}) (PeerVersion, error) {
+	var v PeerVersion
+	if len(args) < 2 {
+		return v, &WireError{Op: "version", Err: ErrInputTooShort}
+	}
+	ver, ok := args[0].(int64)
+	if !ok {
+		return v, &WireError{Op: "version", Err: ErrUnexpectedType}
+	}
+	caps, ok := args[1].(uint64)
+	if !ok {
+		return v, &WireError{Op: "version capabilities", Err: ErrUnexpectedType}
+	}
+	v.Version, v.Caps = ver, Capabilities(caps)
+	return v, nil
+}
+
+// HopLimit : returns the remaining hop limit of a message, ok is false if it has none
+func (m *Msg) HopLimit() (hops byte, ok bool) {
+	e := m.GetExtension(ExtHopLimit)
+	if e == nil || len(e.Value) != 1 {
+		return 0, false
+	}
+	return e.Value[0], true
+}
+
+// SetExpiryExtension : records an expiry, in unix nanoseconds, in the message header so relays drop it too
+func (m *Msg) SetExpiryExtension(expiry int64) {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(expiry/int64(time.Second)))
+	m.SetExtension(ExtExpiry, v)
+}
+
+// ExpiryExtension : returns the expiry in the message header in unix nanoseconds, ok is false if it has none
+func (m *Msg) ExpiryExtension() (expiry int64, ok bool) {
+	e := m.GetExtension(ExtExpiry)
+	if e == nil || len(e.Value) != 8 {
+		return 0, false
+	}
+	seconds := int64(binary.BigEndian.Uint64(e.Value))
+	if seconds <= 0 || seconds > math.MaxInt64/int64(time.Second) {
+		return 0, false
+	}
+	return seconds * int64(time.Second), true
+}
+
+// DecrementHopLimit : takes one hop from the message's hop limit before it is forwarded,
+// returns false if the limit is used up and the message should be dropped instead
+func (m *Msg) DecrementHopLimit() bool {
+	hops, ok := m.HopLimit()
+	if !ok {
+		return true
+	}
+	if hops == 0 {
+		return false
+	}
+	// copy, the extensions may be shared with the header the message was parsed from
+	exts := make([]Extension, len(m.Extensions))
+	copy(exts, m.Extensions)
+	m.Extensions = exts
+	m.SetExtension(ExtHopLimit, []byte{hops - 1})
+	return true
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+)
+
+func Test_MsgHeader_extensions(t *testing.T) {
+	msg := Msg{Name: "abc", IsChan: true, Chunked: true}
+	msg.SetExtension(ExtHopLimit, []byte{3})
+	msg.SetExtension(ExtCritical|0x7f, []byte("crit"))
+	body := []byte{9, 9, 9}
+	h, err := ParseMsgHeader(append(EncodeMsgHeader(&msg), body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "abc" || !h.IsChan() || h.Flags&ChunkedFlag == 0 || h.Version != ProtocolVersion || len(h.Extensions) != 2 {
+		t.Fatalf("bad header: %+v", h)
+	}
+	if h.Size != len(EncodeMsgHeader(&msg)) {
+		t.Fatal("header size", h.Size, "does not match encoding")
+	}
+	if h.Supported() {
+		t.Fatal("unknown critical extension reported as supported")
+	}
+	// messages without extensions keep the original header
+	plain := Msg{Name: "abc", IsChan: true}
+	if !bytes.Equal(EncodeMsgHeader(&plain), []byte{ChannelFlag, 0, 3, 'a', 'b', 'c'}) {
+		t.Fatal("plain header changed:", EncodeMsgHeader(&plain))
+	}
+}
+
+func Test_DowngradeMessage(t *testing.T) {
+	msg := Msg{}
+	msg.SetExtension(ExtHopLimit, []byte{3})
+	m := append(EncodeMsgHeader(&msg), 1, 2, 3)
+
+	if out, ok := DowngradeMessage(m, LocalCapabilities); !ok || !bytes.Equal(out, m) {
+		t.Fatal("message changed for a peer with every capability")
+	}
+	out, ok := DowngradeMessage(m, 0)
+	if !ok || !bytes.Equal(out, []byte{0, 1, 2, 3}) {
+		t.Fatal("non-critical extension not stripped for a legacy peer:", out, ok)
+	}
+
+	msg.SetExtension(ExtCritical|0x7f, nil)
+	m = append(EncodeMsgHeader(&msg), 1, 2, 3)
+	if _, ok := DowngradeMessage(m, CapExtHeader); !ok {
+		t.Fatal("unknown extensions should be passed to peers that understand the extended header")
+	}
+	if msgs := DowngradeMessages([][]byte{m, {0, 1}}, 0); len(msgs) != 1 || !bytes.Equal(msgs[0], []byte{0, 1}) {
+		t.Fatal("message with a critical extension sent to a legacy peer:", msgs)
+	}
+}
+
+func Test_DecrementHopLimit(t *testing.T) {
+	msg := Msg{}
+	if !msg.DecrementHopLimit() {
+		t.Fatal("message without a hop limit dropped")
+	}
+	msg.SetExtension(ExtHopLimit, []byte{1})
+	shared := msg.Extensions
+	if !msg.DecrementHopLimit() {
+		t.Fatal("message with one hop left dropped")
+	}
+	if h, _ := msg.HopLimit(); h != 0 {
+		t.Fatal("hop limit not decremented:", h)
+	}
+	if shared[0].Value[0] != 1 {
+		t.Fatal("decrement changed the original extensions")
+	}
+	if msg.DecrementHopLimit() {
+		t.Fatal("message forwarded past its hop limit")
+	}
+}
+
+func Test_ExpiryExtension(t *testing.T) {
+	msg := Msg{Name: "abc", IsChan: true}
+	if _, ok := msg.ExpiryExtension(); ok {
+		t.Fatal("expiry found in a message without one")
+	}
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC).UnixNano()
+	msg.SetExpiryExtension(expiry)
+	h, err := ParseMsgHeader(EncodeMsgHeader(&msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := Msg{Extensions: h.Extensions}
+	if got, ok := parsed.ExpiryExtension(); !ok || got != expiry-6 {
+		t.Fatal("expiry not carried to the second:", got, ok)
+	}
+	if !h.Supported() {
+		t.Fatal("expiry extension should not be critical")
+	}
+}
+
+func Test_VersionArgs(t *testing.T) {
+	v := PeerVersion{Version: ProtocolVersion, Caps: LocalCapabilities}
+	args, err := ArgsFromBytes(ArgsToBytes(VersionToArgs(v)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := VersionFromArgs(args); err != nil || got != v {
+		t.Fatal("version did not round trip:", got, err)
+	}
+	if _, err := VersionFromArgs([]interface{}{"1", uint64(0)}); err == nil {
+		t.Fatal("expected an error for a bad version")
+	}
+	versions := NewPeerVersions()
+	versions.Set("x", v)
+	if versions.Get("x") != v || versions.Get("y") != (PeerVersion{}) || NewPeerVersions().Get("x") != (PeerVersion{}) {
+		t.Fatal("peer version registry")
+	}
+}
+
+func Test_PeerVersions_Challenge(t *testing.T) {
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	rpub := key.GetPubKey().ToB64()
+	v := PeerVersion{Version: ProtocolVersion, Caps: LocalCapabilities}
+	versions := NewPeerVersions()
+	open := func(challenge []byte) []byte {
+		ok, clear, err := key.DecryptMessage(challenge)
+		if !ok || err != nil || !bytes.HasPrefix(clear, versionChallengePrefix) {
+			t.Fatal("challenge did not open with the routing key:", ok, err)
+		}
+		return clear[len(versionChallengePrefix):]
+	}
+
+	// nothing is recorded until the holder of the routing key answers
+	challenge, err := versions.Challenge(key.GetPubKey(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := open(challenge)
+	if versions.Get(rpub) != (PeerVersion{}) {
+		t.Fatal("version recorded before the challenge was answered")
+	}
+	if versions.Confirm(rpub, ChallengeAnswer(token, PeerVersion{})) {
+		t.Fatal("answer for another version accepted")
+	}
+	if versions.Confirm(rpub, ChallengeAnswer(token, v)) || versions.Get(rpub) != (PeerVersion{}) {
+		t.Fatal("challenge answered twice")
+	}
+
+	challenge, _ = versions.Challenge(key.GetPubKey(), v)
+	if !versions.Confirm(rpub, ChallengeAnswer(open(challenge), v)) || versions.Get(rpub) != v {
+		t.Fatal("answered version not recorded")
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/bencrypt/ecc"
+)
+
+// Wire limits, checked by every decoder before trusting a length or count read from the network
+var (
+	// MaxFrameSize : largest buffer ReadBuffer will accept, and largest length-prefixed value
+	MaxFrameSize uint64 = 64 * 1024 * 1024
+	// MaxElements : largest number of elements in any decoded array
+	MaxElements uint64 = 1024 * 1024
+	// MaxDepth : deepest nesting of interface arrays
+	MaxDepth = 8
+)
+
+// Wire errors, wrapped in a *WireError by the decoders
+var (
+	ErrInputTooShort    = errors.New("input too short")
+	ErrLenOverflow      = errors.New("uvarint overflow")
+	ErrTooLarge         = errors.New("length exceeds limit")
+	ErrTooManyElements  = errors.New("element count exceeds limit")
+	ErrTooDeep          = errors.New("nesting exceeds limit")
+	ErrUnknownType      = errors.New("unknown type")
+	ErrUnexpectedType   = errors.New("unexpected type")
+	ErrMalformedMessage = errors.New("malformed message")
+	ErrChunkRange       = errors.New("chunk number out of range")
+	ErrDuplicateChunk   = errors.New("duplicate chunk")
+)
+
+// WireError : returned by the decoders for malformed or oversized input
+type WireError struct {
+	Op  string // what was being decoded
+	Err error  // one of the wire errors above, or the error from a key parser
+}
+
+func (e *WireError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap : returns the underlying wire error, for errors.Is
+func (e *WireError) Unwrap() error {
+	return e.Err
+}
+
+// wireError : wraps err for op, mapping short reads to ErrInputTooShort
+func wireError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*WireError); ok {
+		return err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrInputTooShort
+	}
+	return &WireError{Op: op, Err: err}
+}
+
+// MsgHeader : the routing header at the start of every message
+type MsgHeader struct {
+	Flags      byte
+	Version    byte        // protocol version of an extended header, 0 for the original header
+	Extensions []Extension // from an extended header
+	Name       string      // channel name, when Flags has ChannelFlag
+	Size       int         // length of the header, the encrypted body follows
+}
+
+// IsChan : this message has a channel name
+func (h *MsgHeader) IsChan() bool { return h.Flags&ChannelFlag != 0 }
+
+// IsTagged : the channel name of an encoded message is a ChannelTag, pickups filtered by channel name leave it out,
+// since its name in the outbox would link the tag to the channel
+func IsTagged(message []byte) bool { return len(message) > 0 && message[0]&TaggedFlag != 0 }
+
+// Msg : returns a Msg with the routing fields of the header, without content
+func (h *MsgHeader) Msg() Msg {
+	return Msg{
+		Name:         h.Name,
+		IsChan:       h.IsChan(),
+		Chunked:      h.Flags&ChunkedFlag != 0,
+		StreamHeader: h.Flags&StreamHeaderFlag != 0,
+		Onion:        h.Flags&OnionFlag != 0,
+		Tagged:       h.Flags&TaggedFlag != 0,
+		Extensions:   h.Extensions,
+	}
+}
+
+// ParseMsgHeader : reads the flags, extensions and channel name from a message, checking every length against the input
+func ParseMsgHeader(message []byte) (*MsgHeader, error) {
+	if len(message) < 1 {
+		return nil, wireError("message header", ErrInputTooShort)
+	}
+	h := &MsgHeader{Flags: message[0], Size: 1}
+	if h.Flags&ExtFlag != 0 {
+		r := bytes.NewReader(message[1:])
+		v, err := r.ReadByte()
+		if err != nil {
+			return nil, wireError("message version", err)
+		}
+		block, err := readLV(r)
+		if err != nil {
+			return nil, wireError("message extensions", err)
+		}
+		if h.Extensions, err = parseExtensions(block); err != nil {
+			return nil, wireError("message extensions", err)
+		}
+		h.Version = v
+		h.Size = len(message) - r.Len()
+	}
+	if h.IsChan() {
+		if len(message) < h.Size+2 {
+			return nil, wireError("message header", ErrInputTooShort)
+		}
+		channelLen := int(message[h.Size])<<8 | int(message[h.Size+1])
+		if len(message) < h.Size+2+channelLen {
+			return nil, wireError("message channel name", ErrInputTooShort)
+		}
+		h.Name = string(message[h.Size+2 : h.Size+2+channelLen])
+		h.Size += 2 + channelLen
+	}
+	return h, nil
+}
+
+// eccOverhead : ephemeral key, luggage tag, HMAC, AES IV and at least one padded block, as written by ecc.KeyPair.EncryptMessage
+const eccOverhead = 32 + 32 + 32 + 16 + 16
+
+// DecryptMessage : calls key.DecryptMessage, after checking that data is well-formed for the key type,
+// and converts any panic in the key implementation into ErrMalformedMessage
+func DecryptMessage(key bc.KeyPair, data []byte) (tagOK bool, clear []byte, err error) {
+	if _, ok := key.(*ecc.KeyPair); ok {
+		if len(data) < eccOverhead || (len(data)-eccOverhead)%16 != 0 {
+			return false, nil, wireError("ecc message", ErrMalformedMessage)
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tagOK, clear, err = false, nil, wireError("message", fmt.Errorf("%w: %v", ErrMalformedMessage, r))
+		}
+	}()
+	return key.DecryptMessage(data)
+}
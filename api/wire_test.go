@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/awgh/bencrypt/ecc"
+)
+
+func Test_ReadBuffer_limit(t *testing.T) {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lenBuf, MaxFrameSize+1)
+	_, err := ReadBuffer(bytes.NewReader(lenBuf[:n]))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatal("expected ErrTooLarge, got", err)
+	}
+}
+
+func Test_Decode_limits(t *testing.T) {
+	// array claiming more elements than the input holds
+	b := []byte{APITypeInterfaceArray, 3, 100, 0, 0}
+	if _, err := ArgsFromBytes(b); !errors.Is(err, ErrInputTooShort) {
+		t.Fatal("expected ErrInputTooShort, got", err)
+	}
+	// length prefix larger than the input
+	b = []byte{APITypeBytes, 0xff, 0xff, 0x03}
+	if _, err := ArgsFromBytes(b); !errors.Is(err, ErrInputTooShort) {
+		t.Fatal("expected ErrInputTooShort, got", err)
+	}
+	// nesting
+	var args []interface{}
+	for i := 0; i <= MaxDepth+1; i++ {
+		args = []interface{}{args}
+	}
+	if _, err := ArgsFromBytes(ArgsToBytes(args)); !errors.Is(err, ErrTooDeep) {
+		t.Fatal("expected ErrTooDeep, got", err)
+	}
+	// well-formed, but not an array
+	if _, err := ArgsFromBytes([]byte{APITypeInt64, 0, 0, 0, 0, 0, 0, 0, 1}); !errors.Is(err, ErrUnexpectedType) {
+		t.Fatal("expected ErrUnexpectedType, got", err)
+	}
+	rr := []byte{APITypeInt64, 0, 0, 0, 0, 0, 0, 0, 1, APITypeNil}
+	if _, err := RemoteResponseFromBytes(&rr); !errors.Is(err, ErrUnexpectedType) {
+		t.Fatal("expected ErrUnexpectedType, got", err)
+	}
+	if _, err := ArgsFromBytes([]byte{0x7f, 0}); !errors.Is(err, ErrUnknownType) {
+		t.Fatal("expected ErrUnknownType, got", err)
+	}
+	var we *WireError
+	if _, err := ArgsFromBytes([]byte{APITypeString}); !errors.As(err, &we) {
+		t.Fatal("expected a *WireError, got", err)
+	}
+}
+
+func Test_ParseMsgHeader(t *testing.T) {
+	h, err := ParseMsgHeader([]byte{ChannelFlag, 0, 3, 'a', 'b', 'c', 9})
+	if err != nil || h.Name != "abc" || h.Size != 6 || !h.IsChan() {
+		t.Fatalf("bad header: %+v %v", h, err)
+	}
+	for _, m := range [][]byte{nil, {ChannelFlag}, {ChannelFlag, 0, 4, 'a'}} {
+		if _, err := ParseMsgHeader(m); !errors.Is(err, ErrInputTooShort) {
+			t.Fatal("expected ErrInputTooShort for", m, "got", err)
+		}
+	}
+}
+
+func Test_DecryptMessage_malformed(t *testing.T) {
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	for _, n := range []int{0, 63, 95, eccOverhead + 1} {
+		if _, _, err := DecryptMessage(key, make([]byte, n)); !errors.Is(err, ErrMalformedMessage) {
+			t.Fatal("expected ErrMalformedMessage for length", n, "got", err)
+		}
+	}
+	cipher, err := key.EncryptMessage([]byte("hello"), key.GetPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagOK, clear, err := DecryptMessage(key, cipher)
+	if !tagOK || err != nil || string(clear) != "hello" {
+		t.Fatal("well-formed message not decrypted:", tagOK, err)
+	}
+}
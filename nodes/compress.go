@@ -0,0 +1,46 @@
+package nodes
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/compress"
+)
+
+// CompressMsg : compresses the content of a message this node sends with the algorithm set by ConfigCompression,
+// the content is left alone if compression would not make it smaller
+func CompressMsg(node api.Node, msg *api.Msg) error {
+	v, err := node.GetConfig(api.ConfigCompression)
+	if err != nil || v == "" {
+		return err
+	}
+	a, err := compress.ByName(v)
+	if err != nil {
+		return errors.New("Invalid " + api.ConfigCompression + " config: " + v)
+	}
+	if a == compress.None || msg.Content.Len() == 0 || msg.GetExtension(api.ExtCompression) != nil {
+		return nil
+	}
+	c, err := compress.Compress(a, msg.Content.Bytes())
+	if err != nil {
+		return err
+	}
+	if len(c) < msg.Content.Len() {
+		msg.Content = bytes.NewBuffer(c)
+		msg.SetExtension(api.ExtCompression, []byte{byte(a)})
+	}
+	return nil
+}
+
+// DecompressMsg : decompresses the decrypted content of a message flagged with ExtCompression, up to compress.MaxSize
+func DecompressMsg(msg api.Msg, clear []byte) ([]byte, error) {
+	e := msg.GetExtension(api.ExtCompression)
+	if e == nil {
+		return clear, nil
+	}
+	if len(e.Value) != 1 {
+		return nil, compress.ErrUnknownAlgorithm
+	}
+	return compress.Decompress(compress.Algorithm(e.Value[0]), clear, compress.MaxSize)
+}
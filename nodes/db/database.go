@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 
 	"github.com/upper/db/v4"
 )
@@ -306,13 +308,69 @@ func (node *Node) dbAddPeer(name string, enabled bool, uri string, group string)
 	return res.Update(peer)
 }
 
+func (node *Node) dbSetPeerIdentity(name string, pubkey string, fingerprint string) error {
+	col := node.db.Collection("peers")
+	res := col.Find(db.Cond{"name": name})
+	count, err := res.Count()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New("Peer not found")
+	}
+	return res.Update(map[string]interface{}{"pubkey": pubkey, "fingerprint": fingerprint})
+}
+
+func (node *Node) dbGetConfig(name string) (string, error) {
+	col := node.db.Collection("config")
+	res := col.Find(db.Cond{"name": name})
+	count, err := res.Count()
+	if err != nil || count == 0 {
+		return "", err
+	}
+	var cv api.ConfigValue
+	if err := res.One(&cv); err != nil {
+		return "", err
+	}
+	return cv.Value, nil
+}
+
+func (node *Node) dbGetConfigs() ([]api.ConfigValue, error) {
+	col := node.db.Collection("config")
+	var all, configs []api.ConfigValue
+	if err := col.Find().All(&all); err != nil {
+		return nil, err
+	}
+	for _, cv := range all {
+		if !api.IsReservedConfig(cv.Name) {
+			configs = append(configs, cv)
+		}
+	}
+	return configs, nil
+}
+
+func (node *Node) dbSetConfig(name string, value string) error {
+	col := node.db.Collection("config")
+	res := col.Find(db.Cond{"name": name})
+	if value == "" {
+		return res.Delete()
+	}
+	count, err := res.Count()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		_, err = col.Insert(api.ConfigValue{Name: name, Value: value})
+		return err
+	}
+	return res.Update(api.ConfigValue{Name: name, Value: value})
+}
+
 func (node *Node) dbDeletePeer(name string) {
 	col := node.db.Collection("peers")
 	res := col.Find(db.Cond{"name": name})
 	_ = res.Delete()
 }
 
-func (node *Node) dbOutboxEnqueue(channelName string, msg []byte, ts int64, checkExists bool) error {
+func (node *Node) dbOutboxEnqueue(channelName, nextHop string, msg []byte, ts, expiry int64, checkExists bool) error {
 	col := node.db.Collection("outbox")
 	doInsert := !checkExists
 	var outboxmsg api.OutboxMsg
@@ -336,13 +394,15 @@ func (node *Node) dbOutboxEnqueue(channelName string, msg []byte, ts int64, chec
 		outboxmsg.Channel = channelName
 		outboxmsg.Msg = msg
 		outboxmsg.Timestamp = ts
+		outboxmsg.Expiry = expiry
+		outboxmsg.NextHop = nextHop
 		_, err := col.Insert(&outboxmsg)
 		return err
 	}
 	return nil
 }
 
-func (node *Node) outboxBulkInsert(channelName string, timestamp int64, msgs [][]byte) error {
+func (node *Node) outboxBulkInsert(channelName string, timestamp, expiry int64, msgs [][]byte) error {
 	return node.db.Tx(func(tx db.Session) error {
 		col := tx.Collection("outbox")
 		// todo: convert this to BatchInserter?
@@ -351,6 +411,7 @@ func (node *Node) outboxBulkInsert(channelName string, timestamp int64, msgs [][
 			outboxmsg.Channel = channelName
 			outboxmsg.Msg = v
 			outboxmsg.Timestamp = timestamp + int64(i) // increment timestamp by one each message to simplify queueing
+			outboxmsg.Expiry = expiry
 			_, err := col.Insert(outboxmsg)
 			if err != nil {
 				return err
@@ -360,7 +421,8 @@ func (node *Node) outboxBulkInsert(channelName string, timestamp int64, msgs [][
 	})
 }
 
-func (node *Node) dbGetMessages(lastTime, maxBytes int64, channelNames ...string) ([][]byte, int64, error) {
+// dbGetMessages - returns the unexpired messages queued after lastTime, onion layers only if they are for the peer with routing key rpub
+func (node *Node) dbGetMessages(lastTime, maxBytes int64, rpub string, channelNames ...string) ([][]byte, int64, error) {
 	lastTimeReturned := lastTime
 	var args []interface{}
 	var msgs [][]byte
@@ -371,7 +433,7 @@ func (node *Node) dbGetMessages(lastTime, maxBytes int64, channelNames ...string
 	if len(channelNames) < 1 {
 		wildcard = true // if no channels are given, get everything
 	}
-	sqlq := "SELECT msg, timestamp FROM outbox"
+	sqlq := "SELECT msg, timestamp, expiry, nexthop FROM outbox"
 	if lastTime != 0 {
 		sqlq += " WHERE (? < timestamp)"
 		args = append(args, lastTime)
@@ -396,12 +458,17 @@ func (node *Node) dbGetMessages(lastTime, maxBytes int64, channelNames ...string
 	if res == nil || err != nil {
 		return nil, lastTimeReturned, err
 	}
+	now := time.Now().UnixNano()
 	n := 0
 	for res.Next() {
 		n++
 		var msg []byte
-		var ts int64
-		res.Scan(&msg, &ts)
+		var ts, expiry int64
+		var nextHop string
+		res.Scan(&msg, &ts, &expiry, &nextHop)
+		if api.Expired(expiry, now) || (!wildcard && api.IsTagged(msg)) || (nextHop != "" && nextHop != rpub) {
+			continue
+		}
 		if bytesRead+int64(len(msg)) >= maxBytes { // no room for next msg
 			events.Debug(node, "skipping messages after %d results\n", n)
 			if n == 0 {
@@ -443,45 +510,48 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 		stream.NumChunks = totalChunks
 		stream.ChannelName = channelName
 		_, err = col.Insert(stream)
-		return err
+	} else {
+		err = res.One(&stream)
+		if err != nil {
+			return err
+		}
+		events.Warning(node, "Over-writing stream header: %x\n", streamID)
+		stream.StreamID = streamID
+		stream.NumChunks = totalChunks
+		stream.ChannelName = channelName
+		err = res.Update(stream)
 	}
-	err = res.One(&stream)
 	if err != nil {
 		return err
 	}
-	events.Warning(node, "Over-writing stream header: %x\n", streamID)
-	stream.StreamID = streamID
-	stream.NumChunks = totalChunks
-	stream.ChannelName = channelName
-	return res.Update(stream)
+	// drop chunks that arrived ahead of the header but don't fit it
+	return node.db.Collection("chunks").Find(db.Cond{"streamid": streamID}).And(db.Cond{"chunknum >=": totalChunks}).Delete()
 }
 
 // AddChunk - implemented from Node API
 func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error {
-	col := node.db.Collection("chunks")
-	res := col.Find(db.Cond{"streamid": streamID}).And(db.Cond{"chunknum": chunkNum})
-	count, err := res.Count()
-	if err != nil {
+	var totalChunks uint32
+	var streams []api.StreamHeader
+	if err := node.db.Collection("streams").Find(db.Cond{"streamid": streamID}).All(&streams); err != nil {
 		return err
 	}
-	var chunk api.Chunk
-	if count == 0 {
-		// insert new chunk
-		chunk.StreamID = streamID
-		chunk.ChunkNum = chunkNum
-		chunk.Data = data
-		_, err = col.Insert(chunk)
-		return err
+	if len(streams) > 0 {
+		totalChunks = streams[0].NumChunks
 	}
-	err = res.One(&chunk)
+	col := node.db.Collection("chunks")
+	count, err := col.Find(db.Cond{"streamid": streamID}).And(db.Cond{"chunknum": chunkNum}).Count()
 	if err != nil {
 		return err
 	}
-	events.Warning(node, "Over-writing chunk: %x:%x\n", streamID, chunkNum)
+	if err := chunking.CheckChunkNum(chunkNum, totalChunks, count > 0); err != nil {
+		return err
+	}
+	var chunk api.Chunk
 	chunk.StreamID = streamID
 	chunk.ChunkNum = chunkNum
 	chunk.Data = data
-	return res.Update(chunk)
+	_, err = col.Insert(chunk)
+	return err
 }
 
 func (node *Node) dbGetStreams() ([]api.StreamHeader, error) {
@@ -494,6 +564,11 @@ func (node *Node) dbGetStreams() ([]api.StreamHeader, error) {
 	return streams, nil
 }
 
+func (node *Node) streamCount() (int, error) {
+	count, err := node.db.Collection("streams").Find().Count()
+	return int(count), err
+}
+
 func (node *Node) dbGetChunkCount(streamID uint32) (uint64, error) {
 	col := node.db.Collection("chunks")
 	res := col.Find(db.Cond{"streamid": streamID})
@@ -510,13 +585,98 @@ func (node *Node) dbGetChunks(streamID uint32) ([]api.Chunk, error) {
 	return chunks, nil
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than their channel's retention, or maxAgeSeconds seconds
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	ts := time.Now().UnixNano()
-	ts = ts - (maxAgeSeconds * 1000000000)
-	col := node.db.Collection("outbox")
-	res := col.Find("timestamp < ?", ts)
-	_ = res.Delete()
+	nodes.FlushOutbox(node, maxAgeSeconds)
+}
+
+func (node *Node) outboxCount() (int, error) {
+	count, err := node.db.Collection("outbox").Find().Count()
+	return int(count), err
+}
+
+func (node *Node) dbGetOutbox(channelNames ...string) ([]api.OutboxEntry, error) {
+	res := node.db.Collection("outbox").Find().OrderBy("timestamp")
+	if len(channelNames) > 0 {
+		res = res.And(db.Cond{"channel IN": channelNames})
+	}
+	var msgs []api.OutboxMsg
+	if err := res.All(&msgs); err != nil {
+		return nil, err
+	}
+	entries := make([]api.OutboxEntry, 0, len(msgs))
+	for _, m := range msgs {
+		entries = append(entries, api.OutboxEntry{Channel: m.Channel, Size: int64(len(m.Msg)), Timestamp: m.Timestamp, Expiry: m.Expiry})
+	}
+	return entries, nil
+}
+
+func (node *Node) dbDeleteOutbox(cutoff int64, channelNames ...string) (int64, error) {
+	res := node.db.Collection("outbox").Find(db.Cond{"timestamp <": cutoff})
+	if len(channelNames) > 0 {
+		res = res.And(db.Cond{"channel IN": channelNames})
+	}
+	count, err := res.Count()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	return int64(count), res.Delete()
+}
+
+func (node *Node) inboxAppend(msg api.InboxMsg) error {
+	msg.ID = node.inboxIDs.Next()
+	_, err := node.db.Collection("inbox").Insert(&msg)
+	return err
+}
+
+func (node *Node) dbFetchInbox(max, now, lease int64) ([]api.InboxMsg, error) {
+	var msgs []api.InboxMsg
+	err := node.db.Tx(func(tx db.Session) error {
+		col := tx.Collection("inbox")
+		res := col.Find(db.Cond{"lease <=": now}).OrderBy("msgid")
+		if max > 0 {
+			res = res.Limit(int(max))
+		}
+		if err := res.All(&msgs); err != nil {
+			return err
+		}
+		for i := range msgs {
+			msgs[i].Lease = lease
+			msgs[i].Attempts++
+			err := col.Find(db.Cond{"msgid": msgs[i].ID}).Update(map[string]interface{}{"lease": lease, "attempts": msgs[i].Attempts})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (node *Node) dbAckInbox(ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return node.db.Collection("inbox").Find(db.Cond{"msgid IN": ids}).Delete()
+}
+
+func (node *Node) dbNackInbox(ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return node.db.Collection("inbox").Find(db.Cond{"msgid IN": ids}).Update(map[string]interface{}{"lease": int64(0)})
+}
+
+func (node *Node) deleteExpired(now int64) (int64, error) {
+	res := node.db.Collection("outbox").Find(db.Cond{"expiry >": 0}).And(db.Cond{"expiry <=": now})
+	count, err := res.Count()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	return int64(count), res.Delete()
 }
 
 type connectionURL struct {
@@ -575,9 +735,31 @@ func (node *Node) BootstrapDB(dbAdapter, dbConnectionString string) db.Session {
 		CREATE TABLE IF NOT EXISTS outbox (
 			channel		%s, 
 			msg			%s	NOT NULL,
-			timestamp	%s	NOT NULL
+			timestamp	%s	NOT NULL,
+			expiry		%s,
+			nexthop		%s
 		);
-	`, strName, blobName, int64Name))
+	`, strName, blobName, int64Name, int64Name, strName))
+	checkErr(err)
+
+	// databases created before message expiry was added lack the expiry column
+	if rows, err := node.db.SQL().Query("SELECT expiry FROM outbox;"); err != nil {
+		_, err = node.db.SQL().Exec(fmt.Sprintf("ALTER TABLE outbox ADD COLUMN expiry %s;", int64Name))
+		checkErr(err)
+	} else {
+		rows.Close()
+	}
+	_, err = node.db.SQL().Exec("UPDATE outbox SET expiry = ? WHERE expiry IS NULL;", int64(0))
+	checkErr(err)
+
+	// and those created before onion layers were held for their next relay lack the nexthop column
+	if rows, err := node.db.SQL().Query("SELECT nexthop FROM outbox;"); err != nil {
+		_, err = node.db.SQL().Exec(fmt.Sprintf("ALTER TABLE outbox ADD COLUMN nexthop %s;", strName))
+		checkErr(err)
+	} else {
+		rows.Close()
+	}
+	_, err = node.db.SQL().Exec("UPDATE outbox SET nexthop = ? WHERE nexthop IS NULL;", "")
 	checkErr(err)
 
 	_, err = node.db.SQL().Exec(`
@@ -585,15 +767,41 @@ func (node *Node) BootstrapDB(dbAdapter, dbConnectionString string) db.Session {
 	`)
 	checkErr(err)
 
+	_, err = node.db.SQL().Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS inbox (
+			msgid		%s	NOT NULL,
+			name		%s	NOT NULL,
+			ischan		bool	NOT NULL,
+			content		%s,
+			received	%s	NOT NULL,
+			attempts	%s	NOT NULL,
+			lease		%s	NOT NULL
+		);
+	`, int64Name, strName, blobName, int64Name, int64Name, int64Name))
+	checkErr(err)
+
 	_, err = node.db.SQL().Exec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS peers (
 			name		%s		NOT NULL,  
 			uri			%s		NOT NULL,
 			enabled		bool	NOT NULL,
 			peergroup   %s  	NOT NULL,
-			pubkey		%s		
+			pubkey		%s,
+			fingerprint	%s
 		);
-	`, strName, strName, strName, strName))
+	`, strName, strName, strName, strName, strName))
+	checkErr(err)
+
+	// databases created before peer identities were added lack the fingerprint column, and have NULL pubkeys
+	if rows, err := node.db.SQL().Query("SELECT fingerprint FROM peers;"); err != nil {
+		_, err = node.db.SQL().Exec(fmt.Sprintf("ALTER TABLE peers ADD COLUMN fingerprint %s;", strName))
+		checkErr(err)
+	} else {
+		rows.Close()
+	}
+	_, err = node.db.SQL().Exec("UPDATE peers SET pubkey = ? WHERE pubkey IS NULL;", "")
+	checkErr(err)
+	_, err = node.db.SQL().Exec("UPDATE peers SET fingerprint = ? WHERE fingerprint IS NULL;", "")
 	checkErr(err)
 
 	_, err = node.db.SQL().Exec(fmt.Sprintf(`
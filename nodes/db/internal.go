@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // GetChannelPrivKey : Return the private key of a given channel
@@ -18,25 +20,16 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
-	flags := uint8(0)
-	if msg.IsChan {
-		flags |= api.ChannelFlag
-	}
-	if msg.Chunked {
-		flags |= api.ChunkedFlag
-	}
-	if msg.StreamHeader {
-		flags |= api.StreamHeaderFlag
-	}
-	rxsum := []byte{flags} // prepend flags byte
-	if msg.IsChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(msg.Name))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(msg.Name)...)
-	}
+	rxsum := api.EncodeMsgHeader(&msg) // flags, extensions and channel name
 	message := append(rxsum, msg.Content.Bytes()...)
-	return node.dbOutboxEnqueue(msg.Name, message, time.Now().UnixNano(), false)
+	ts := time.Now().UnixNano()
+	channelName := nodes.OutboxChannel(&msg)
+	return node.dbOutboxEnqueue(channelName, msg.NextHop, message, ts, nodes.OutboxExpiry(node, channelName, &msg, ts), false)
+}
+
+// PeelOnion - Decrypt an onion layer addressed to this node's routing key
+func (node *Node) PeelOnion(msg api.Msg) (bool, []byte, error) {
+	return api.DecryptMessage(node.routingKey, msg.Content.Bytes())
 }
 
 // Handle - Decrypt and handle an encrypted message
@@ -52,22 +45,28 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 			return false, errors.New("Cannot Handle message for Unknown Channel")
 		}
 		clearMsg = api.Msg{Name: msg.Name, IsChan: true, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = v.DecryptMessage(msg.Content.Bytes())
+		tagOK, clear, err = api.DecryptMessage(v, msg.Content.Bytes())
 	} else if len(msg.Name) > 0 {
 		clearMsg = api.Msg{Name: msg.Name, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		key, err := node.privProfile(msg.Name)
-		if err != nil {
+		var key bc.KeyPair
+		if key, err = node.privProfile(msg.Name); err != nil {
 			return false, err
 		}
-		tagOK, clear, err = key.DecryptMessage(msg.Content.Bytes())
+		tagOK, clear, err = api.DecryptMessage(key, msg.Content.Bytes())
 	} else {
-		clearMsg = api.Msg{Name: "[content]", IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = node.contentKey.DecryptMessage(msg.Content.Bytes())
+		clearMsg = api.Msg{Name: api.ContentName, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
+		tagOK, clear, err = api.DecryptMessage(node.contentKey, msg.Content.Bytes())
 	}
 	// DecryptMessage will return !tagOK if the quick-check fails, which is common
 	if !tagOK || err != nil {
 		return tagOK, err
 	}
+	if clear, err = nodes.UnpadMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
+	if clear, err = nodes.DecompressMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
 	clearMsg.Content = bytes.NewBuffer(clear)
 
 	if msg.Chunked {
@@ -78,12 +77,17 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 		return true, err
 	}
 
-	select {
-	case node.Out() <- clearMsg:
-		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
-	default:
+	delivered, err := nodes.Deliver(node, clearMsg, node.inboxAppend)
+	if err != nil {
+		events.Dropped(node, clearMsg, api.DropInbox)
+		return tagOK, err
+	} else if !delivered {
 		events.Debug(node, "No message sent")
+		events.Dropped(node, clearMsg, api.DropOutFull)
+		return tagOK, nil
 	}
+	events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+	events.Delivered(node, clearMsg)
 	return tagOK, nil
 }
 
@@ -1,11 +1,14 @@
 package fs
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
@@ -16,6 +19,8 @@ import (
 
 var OutBufferSize = 128
 
+var EventBufferSize = 128
+
 type outboxMsg struct {
 	channel   string
 	msg       []byte
@@ -32,9 +37,12 @@ type Node struct {
 	isRunning uint32
 
 	// external data members
-	in     chan api.Msg
-	out    chan api.Msg
-	events chan api.Event
+	in           chan api.Msg
+	out          chan api.Msg
+	bus          *api.EventBus
+	msgBus       *api.MsgBus
+	peerVersions *api.PeerVersions
+	events       *api.Subscription
 
 	// db -> ram replacements
 	channels map[string]*api.ChannelPriv
@@ -45,9 +53,18 @@ type Node struct {
 	streams  map[uint32]*api.StreamHeader
 	chunks   map[uint32]map[uint32]*api.Chunk
 
+	streamsPending int32 // streams not yet reassembled, accessed atomically for the metrics
+
+	configMux sync.RWMutex // config is read by concurrent Dropoff calls
+
 	// outbox   []*outboxMsg
 	basePath    string
 	outboxIndex uint32
+
+	inboxMux sync.Mutex
+	inboxIDs nodes.InboxIDs
+
+	janitor *nodes.Janitor
 }
 
 // New : creates a new instance of API
@@ -71,7 +88,10 @@ func New(contentKey, routingKey bc.KeyPair, basePath string) *Node {
 	// setup chans
 	node.in = make(chan api.Msg)
 	node.out = make(chan api.Msg, OutBufferSize)
-	node.events = make(chan api.Event)
+	node.bus = api.NewEventBus()
+	node.msgBus = api.NewMsgBus()
+	node.peerVersions = api.NewPeerVersions()
+	node.events = node.bus.Subscribe(EventBufferSize, api.EventFilter{})
 
 	// setup default router
 	node.router = router.NewDefaultRouter()
@@ -86,6 +106,49 @@ func hex(n uint32) string {
 	return fmt.Sprintf("%08x", n)
 }
 
+// onionDir - holds onion layers in one directory per next relay, so Pickup only releases them to that relay
+const onionDir = ".onion"
+
+// onionHopDir - the directory the onion layers for the relay with routing key nextHop (b64) are kept in
+func onionHopDir(basePath, nextHop string) string {
+	sum := sha256.Sum256([]byte(nextHop))
+	return filepath.Join(basePath, onionDir, fmt.Sprintf("%x", sum[:16]))
+}
+
+// outboxDir - creates and returns the directory a message queued on channelName, or for nextHop, is kept in
+func (node *Node) outboxDir(channelName, nextHop string) string {
+	path := node.basePath
+	if nextHop != "" {
+		path = onionHopDir(path, nextHop)
+		os.MkdirAll(path, os.FileMode(int(0700)))
+	} else if channelName != "" {
+		path = filepath.Join(path, channelName)
+		os.Mkdir(path, os.FileMode(int(0700)))
+	}
+	return path
+}
+
+// outboxFileName - names an outbox file after its index, followed by its expiry in unix nanoseconds if it has one
+func outboxFileName(index uint32, expiry int64) string {
+	if expiry == 0 {
+		return hex(index)
+	}
+	return hex(index) + "." + strconv.FormatInt(expiry, 10)
+}
+
+// outboxFileExpiry - returns the expiry in an outbox file name, 0 if it has none
+func outboxFileExpiry(name string) int64 {
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return 0
+	}
+	expiry, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return expiry
+}
+
 // IsRunning - returns true if this node is running
 func (node *Node) IsRunning() bool {
 	return atomic.LoadUint32(&node.isRunning) == 1
@@ -119,24 +182,58 @@ func (node *Node) SetRouter(router api.Router) {
 	node.router = router
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than their channel's retention, or maxAgeSeconds seconds
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	now := time.Now()
-	_ = filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
+	nodes.FlushOutbox(node, maxAgeSeconds)
+}
+
+// walkOutbox - calls fn with the path and description of each queued message, messages for a channel are kept in a directory named after it
+func (node *Node) walkOutbox(fn func(path string, entry api.OutboxEntry) error) error {
+	return filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			events.Warning(node, "FlushOutbox failure accessing a path:", path, err.Error())
+			events.Warning(node, "outbox failure accessing a path:", path, err.Error())
 			return err
 		}
-		if !info.IsDir() {
-			if diff := now.Sub(info.ModTime()); diff > time.Duration(maxAgeSeconds)*time.Second {
-				events.Debug(node, "Deleting file:", filepath.Join(node.basePath, info.Name()), diff)
-				if err = os.Remove(path); err != nil {
-					events.Error(node, "error deleting file: "+err.Error())
-				}
+		if info.IsDir() {
+			if info.Name() == inboxDir {
+				return filepath.SkipDir
 			}
+			return nil
 		}
+		channel, err := filepath.Rel(node.basePath, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if channel == "." || strings.HasPrefix(channel, onionDir+string(filepath.Separator)) {
+			channel = ""
+		}
+		entry := api.OutboxEntry{Channel: channel, Size: info.Size(), Timestamp: info.ModTime().UnixNano()}
+		entry.Expiry = outboxFileExpiry(info.Name())
+		return fn(path, entry)
+	})
+}
+
+func (node *Node) outboxCount() (int, error) {
+	count := 0
+	err := node.walkOutbox(func(string, api.OutboxEntry) error {
+		count++
 		return nil
 	})
+	return count, err
+}
+
+func (node *Node) deleteExpired(now int64) (int64, error) {
+	var deleted int64
+	err := node.walkOutbox(func(path string, entry api.OutboxEntry) error {
+		if api.Expired(entry.Expiry, now) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
 }
 
 // Channels
@@ -152,8 +249,23 @@ func (node *Node) Out() chan api.Msg {
 }
 
 // Events : Returns the Events channel of this node
-func (node *Node) Events() chan api.Event {
-	return node.events
+func (node *Node) Events() <-chan api.Event {
+	return node.events.Events()
+}
+
+// EventBus : Returns the bus this node publishes its events on
+func (node *Node) EventBus() *api.EventBus {
+	return node.bus
+}
+
+// MsgBus : Returns the bus this node delivers received messages on
+func (node *Node) MsgBus() *api.MsgBus {
+	return node.msgBus
+}
+
+// PeerVersions : Returns the protocol versions this node's peers reported
+func (node *Node) PeerVersions() *api.PeerVersions {
+	return node.peerVersions
 }
 
 // RPC set to default handlers
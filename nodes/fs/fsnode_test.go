@@ -2,12 +2,15 @@ package fs
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/awgh/bencrypt/ecc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/nodes"
 )
 
 var node *Node
@@ -69,6 +72,376 @@ func Test_apicall_Channels_1(t *testing.T) {
 	t.Log(message)
 }
 
+func Test_apicall_PeerIdentity_1(t *testing.T) {
+	if err := node.AddPeer("peer1", true, "localhost:20001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.SetPeerIdentity("peer1", pubkeyb64Ecc, "ab12"); err != nil {
+		t.Fatal(err)
+	}
+	// updating the peer must keep its pinned identity
+	if err := node.AddPeer("peer1", false, "localhost:20001"); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := node.GetPeer("peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Pubkey != pubkeyb64Ecc || peer.Fingerprint != "ab12" || peer.Enabled {
+		t.Fatalf("Peer identity not kept: %+v\n", peer)
+	}
+	peers, err := node.GetPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].Pubkey != pubkeyb64Ecc {
+		t.Fatalf("Peer identity missing from GetPeers: %+v\n", peers)
+	}
+	if err := node.SetPeerIdentity("nopeer", "", ""); err == nil {
+		t.Fatal("Expected an error for an unknown peer")
+	}
+}
+
+func Test_apicall_Config_1(t *testing.T) {
+	if err := node.SetConfig(api.ConfigStampDifficulty, "8"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := node.GetConfig(api.ConfigStampDifficulty); err != nil || v != "8" {
+		t.Fatal("GetConfig returned", v, err)
+	}
+	if err := node.SetConfig(api.ConfigStampDifficulty, ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := node.GetConfig(api.ConfigStampDifficulty); err != nil || v != "" {
+		t.Fatal("config value not removed:", v, err)
+	}
+	if _, err := node.GetConfig("routingkey"); err != api.ErrReservedConfig {
+		t.Fatal("routing key readable through GetConfig:", err)
+	}
+}
+
+func Test_apicall_Outbox_1(t *testing.T) {
+	if err := node.Forward(api.Msg{Name: "chan1", IsChan: true, Content: bytes.NewBufferString(testMessage1)}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := node.GetOutbox("chan1")
+	if err != nil || len(entries) != 1 || entries[0].Channel != "chan1" || entries[0].Size <= int64(len(testMessage1)) {
+		t.Fatalf("GetOutbox returned %+v %v", entries, err)
+	}
+	counts, err := node.GetOutboxCounts()
+	if err != nil || len(counts) < 2 || counts[0].Channel != "" || counts[len(counts)-1].Channel != "chan1" || counts[len(counts)-1].Messages != 1 {
+		t.Fatalf("GetOutboxCounts returned %+v %v", counts, err)
+	}
+
+	// a channel's retention overrides the age FlushOutbox is given
+	if err := node.SetConfig(api.OutboxRetentionConfig("chan1"), "3600"); err != nil {
+		t.Fatal(err)
+	}
+	node.FlushOutbox(0)
+	if entries, err = node.GetOutbox(); err != nil || len(entries) == 0 || entries[0].Channel != "chan1" {
+		t.Fatalf("FlushOutbox left %+v %v", entries, err)
+	}
+	if n, err := node.DeleteOutbox(3600, "chan1"); err != nil || n != 0 {
+		t.Fatal("DeleteOutbox removed a recent message:", n, err)
+	}
+	if n, err := node.DeleteOutbox(0, "chan1"); err != nil || n != 1 {
+		t.Fatal("DeleteOutbox returned", n, err)
+	}
+	if err := node.SetConfig(api.OutboxRetentionConfig("chan1"), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_apicall_Expiry_1(t *testing.T) {
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// messages without an expiry of their own leave when their channel's retention runs out
+	if err := node.Forward(api.Msg{Name: "chan2", IsChan: true, Content: bytes.NewBufferString(testMessage1)}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := node.GetOutbox("chan2")
+	retention := api.DefaultOutboxRetention * int64(time.Second)
+	if err != nil || len(entries) != 1 || entries[0].Expiry < entries[0].Timestamp+retention-int64(time.Second) ||
+		entries[0].Expiry > entries[0].Timestamp+retention+int64(time.Second) {
+		t.Fatalf("GetOutbox returned %+v %v", entries, err)
+	}
+	if err := node.SetConfig(api.ConfigOutboxRetention, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Forward(api.Msg{Name: "chan2", IsChan: true, Content: bytes.NewBufferString(testMessage1)}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = node.GetOutbox("chan2"); err != nil || len(entries) != 2 || entries[1].Expiry != 0 {
+		t.Fatalf("message expires without a retention: %+v %v", entries, err)
+	}
+	// a sender's expiry later than the channel's retention does not keep the message longer
+	if err := node.SetConfig(api.ConfigOutboxRetention, "60"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Forward(api.Msg{Name: "chan2", IsChan: true, Content: bytes.NewBufferString(testMessage1),
+		Expiry: time.Now().Add(time.Hour).UnixNano()}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = node.GetOutbox("chan2"); err != nil || len(entries) != 3 ||
+		entries[2].Expiry > entries[2].Timestamp+61*int64(time.Second) {
+		t.Fatalf("sender's expiry outlasted the retention: %+v %v", entries, err)
+	}
+	if err := node.SetConfig(api.ConfigOutboxRetention, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.DeleteOutbox(0, "chan2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a sender's expiry hides the message from Pickup until the janitor deletes it
+	if err := node.SendMsg(api.Msg{Name: "chan2", IsChan: true, Content: bytes.NewBufferString(testMessage1),
+		PubKey: rpk, Expiry: time.Now().Add(-time.Second).UnixNano()}); err == nil {
+		t.Fatal("expired message sent")
+	}
+	expiry := time.Now().Add(50 * time.Millisecond).UnixNano()
+	if err := node.Forward(api.Msg{Name: "chan2", IsChan: true, Content: bytes.NewBufferString(testMessage1), Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = node.GetOutbox("chan2"); err != nil || len(entries) != 1 || entries[0].Expiry != expiry {
+		t.Fatalf("GetOutbox returned %+v %v", entries, err)
+	}
+	if b, err := node.Pickup(rpk, entries[0].Timestamp-1, 1<<20, "chan2"); err != nil || len(b.Data) == 0 {
+		t.Fatal("Pickup missed an unexpired message:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if b, err := node.Pickup(rpk, entries[0].Timestamp-1, 1<<20, "chan2"); err != nil || len(b.Data) != 0 {
+		t.Fatal("Pickup returned an expired message:", err)
+	}
+	if n, err := node.deleteExpired(time.Now().UnixNano()); err != nil || n != 1 {
+		t.Fatal("deleteExpired returned", n, err)
+	}
+	if entries, err = node.GetOutbox("chan2"); err != nil || len(entries) != 0 {
+		t.Fatalf("expired message left in the outbox: %+v %v", entries, err)
+	}
+}
+
+func Test_apicall_Inbox_1(t *testing.T) {
+	if err := node.SetConfig(api.ConfigInbox, "true"); err != nil {
+		t.Fatal(err)
+	}
+	// a subscription that drops messages does not keep them from the inbox
+	sub := node.MsgBus().Subscribe(api.ChannelFilter("chan1"), 1, api.DropNewest)
+	for _, content := range []string{"one", "two"} {
+		msg := api.Msg{Name: "chan1", IsChan: true, Content: bytes.NewBufferString(content)}
+		if delivered, err := nodes.Deliver(node, msg, node.inboxAppend); err != nil || !delivered {
+			t.Fatal("Deliver returned", delivered, err)
+		}
+	}
+	sub.Close()
+	if sub.Dropped() != 1 {
+		t.Fatal("subscription dropped", sub.Dropped(), "messages")
+	}
+	select {
+	case msg := <-node.Out():
+		t.Fatalf("message sent to Out with the inbox enabled: %+v", msg)
+	default:
+	}
+	// the inbox is kept on disk, so a node opened on the same directory sees it
+	inbox := New(new(ecc.KeyPair), new(ecc.KeyPair), "tmp")
+	msgs, err := inbox.FetchInbox(1, 60)
+	if err != nil || len(msgs) != 1 || string(msgs[0].Content) != "one" || msgs[0].Name != "chan1" || !msgs[0].IsChan || msgs[0].Attempts != 1 {
+		t.Fatalf("FetchInbox returned %+v %v", msgs, err)
+	}
+	// leased messages are not fetched again until they are nacked or their lease ends
+	rest, err := inbox.FetchInbox(0, 60)
+	if err != nil || len(rest) != 1 || string(rest[0].Content) != "two" {
+		t.Fatalf("FetchInbox returned %+v %v", rest, err)
+	}
+	if more, err := inbox.FetchInbox(0, 60); err != nil || len(more) != 0 {
+		t.Fatalf("FetchInbox returned leased messages %+v %v", more, err)
+	}
+	if err := inbox.NackInbox(msgs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := inbox.FetchInbox(0, 60)
+	if err != nil || len(again) != 1 || again[0].ID != msgs[0].ID || again[0].Attempts != 2 {
+		t.Fatalf("FetchInbox after NackInbox returned %+v %v", again, err)
+	}
+	if err := inbox.AckInbox(msgs[0].ID, rest[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := inbox.NackInbox(rest[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := inbox.FetchInbox(0, 60); err != nil || len(left) != 0 {
+		t.Fatalf("acknowledged messages fetched %+v %v", left, err)
+	}
+	if err := node.SetConfig(api.ConfigInbox, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_apicall_Subscribe_1(t *testing.T) {
+	sub := node.MsgBus().Subscribe(api.ContentFilter(), 1, api.Block)
+	handle := func() {
+		cipher, err := node.contentKey.EncryptMessage([]byte(testMessage1), node.contentKey.GetPubKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tagOK, err := node.Handle(api.Msg{Content: bytes.NewBuffer(cipher)}); !tagOK || err != nil {
+			t.Fatal("Handle returned", tagOK, err)
+		}
+	}
+	handle()
+	select {
+	case msg := <-sub.Msgs():
+		if msg.Name != api.ContentName || msg.Content.String() != testMessage1 {
+			t.Fatalf("subscription received %+v", msg)
+		}
+	default:
+		t.Fatal("subscription received nothing")
+	}
+	select {
+	case msg := <-node.Out():
+		t.Fatalf("message for a subscription sent to Out: %+v", msg)
+	default:
+	}
+
+	// without a subscription, messages go to Out again
+	sub.Close()
+	handle()
+	select {
+	case msg := <-node.Out():
+		if msg.Content.String() != testMessage1 {
+			t.Fatalf("Out received %+v", msg)
+		}
+	default:
+		t.Fatal("Out received nothing")
+	}
+}
+
+func Test_apicall_Subscribe_Profile_1(t *testing.T) {
+	if err := node.AddProfile("subprofile", true); err != nil {
+		t.Fatal(err)
+	}
+	defer node.DeleteProfile("subprofile")
+	p, err := node.GetProfile("subprofile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := node.contentKey.GetPubKey().Clone()
+	if err := pub.FromB64(p.Pubkey); err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := node.contentKey.EncryptMessage([]byte(testMessage1), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := node.MsgBus().Subscribe(api.ProfileFilter("subprofile"), 1, api.Block)
+	defer sub.Close()
+
+	// the content key does not open a message for the profile
+	if tagOK, _ := node.Handle(api.Msg{Content: bytes.NewBuffer(cipher)}); tagOK {
+		t.Fatal("message for a profile handled with the content key")
+	}
+	if tagOK, err := node.Handle(api.Msg{Name: "subprofile", Content: bytes.NewBuffer(cipher)}); !tagOK || err != nil {
+		t.Fatal("Handle returned", tagOK, err)
+	}
+	select {
+	case msg := <-sub.Msgs():
+		if msg.Name != "subprofile" || msg.IsChan || msg.Content.String() != testMessage1 {
+			t.Fatalf("profile subscription received %+v", msg)
+		}
+	default:
+		t.Fatal("profile subscription received nothing")
+	}
+}
+
+func Test_apicall_Outbox_Tags_1(t *testing.T) {
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chanKey := new(ecc.KeyPair)
+	chanKey.GenerateKey()
+	if err := node.AddChannel("tagchan", chanKey.ToB64()); err != nil {
+		t.Fatal(err)
+	}
+	defer node.DeleteChannel("tagchan")
+	if err := node.SetConfig(api.ConfigChannelTags, "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer node.SetConfig(api.ConfigChannelTags, "")
+
+	// a tagged message is filed under its channel name, not the tag on the wire
+	if err := node.SendChannel("tagchan", []byte(testMessage1)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := node.GetOutbox("tagchan")
+	if err != nil || len(entries) != 1 || entries[0].Channel != "tagchan" {
+		t.Fatalf("GetOutbox returned %+v %v", entries, err)
+	}
+	counts, err := node.GetOutboxCounts()
+	if err != nil || counts[len(counts)-1].Channel != "tagchan" || counts[len(counts)-1].Messages != 1 {
+		t.Fatalf("GetOutboxCounts returned %+v %v", counts, err)
+	}
+	if b, err := node.Pickup(rpk, entries[0].Timestamp-1, 1<<20); err != nil || len(b.Data) == 0 {
+		t.Fatal("Pickup did not return a tagged message:", err)
+	}
+	if n, err := node.DeleteOutbox(0, "tagchan"); err != nil || n != 1 {
+		t.Fatal("DeleteOutbox returned", n, err)
+	}
+}
+
+func Test_apicall_Pickup_NextHop_1(t *testing.T) {
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := new(ecc.KeyPair)
+	relay.GenerateKey()
+	if err := node.Forward(api.Msg{Onion: true, NextHop: relay.GetPubKey().ToB64(), Content: bytes.NewBufferString(testMessage1)}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := node.GetOutbox()
+	if err != nil || len(entries) == 0 {
+		t.Fatal("GetOutbox returned", entries, err)
+	}
+	since := entries[len(entries)-1].Timestamp - 1
+
+	// an onion layer is only released to the relay it is for
+	if b, err := node.Pickup(rpk, since, 1<<20); err != nil || len(b.Data) != 0 {
+		t.Fatal("onion layer released to another peer:", err)
+	}
+	if b, err := node.Pickup(relay.GetPubKey(), since, 1<<20); err != nil || len(b.Data) == 0 {
+		t.Fatal("onion layer not released to its relay:", err)
+	}
+	if _, err := node.DeleteOutbox(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_apicall_Chunks_1(t *testing.T) {
+	// a chunk past the end of its stream, or one received twice, is rejected
+	if err := node.AddStream(0xc0ffee01, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddChunk(0xc0ffee01, 5, []byte("stray")); !errors.Is(err, api.ErrChunkRange) {
+		t.Fatal("AddChunk accepted an out of range chunk:", err)
+	}
+	// a stray chunk that arrives before its header is dropped when the header arrives
+	if err := node.AddChunk(0xc0ffee02, 5, []byte("stray")); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddChunk(0xc0ffee02, 5, []byte("stray")); !errors.Is(err, api.ErrDuplicateChunk) {
+		t.Fatal("AddChunk accepted a duplicate chunk:", err)
+	}
+	if err := node.AddStream(0xc0ffee02, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the reassembly loop see the incomplete streams
+	if n, err := node.streamCount(); err != nil || n < 2 {
+		t.Fatal("incomplete streams were dropped:", n, err)
+	}
+}
+
 func Test_stop(t *testing.T) {
 	node.Stop()
 }
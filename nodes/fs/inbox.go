@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// inboxDir - directory under basePath that holds received messages, one file per message named after its ID,
+// it is skipped when walking the outbox
+const inboxDir = ".inbox"
+
+func (node *Node) inboxPath(id int64) string {
+	return filepath.Join(node.basePath, inboxDir, fmt.Sprintf("%016x", id))
+}
+
+// writeInboxMsg - writes a message to a temporary file and renames it into place, so a crash cannot leave it half written
+func (node *Node) writeInboxMsg(msg api.InboxMsg) error {
+	if err := os.MkdirAll(filepath.Join(node.basePath, inboxDir), os.FileMode(int(0700))); err != nil {
+		return err
+	}
+	path := node.inboxPath(msg.ID)
+	if err := ioutil.WriteFile(path+".tmp", api.ArgsToBytes(api.InboxMsgToArgs(msg)), os.FileMode(int(0600))); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readInbox - returns the received messages in ID order
+func (node *Node) readInbox() ([]api.InboxMsg, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(node.basePath, inboxDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var msgs []api.InboxMsg
+	for _, info := range infos {
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".tmp") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(node.basePath, inboxDir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		args, err := api.ArgsFromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := api.InboxMsgFromArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (node *Node) inboxAppend(msg api.InboxMsg) error {
+	node.inboxMux.Lock()
+	defer node.inboxMux.Unlock()
+	msg.ID = node.inboxIDs.Next()
+	return node.writeInboxMsg(msg)
+}
+
+func (node *Node) inboxFetch(max, now, lease int64) ([]api.InboxMsg, error) {
+	node.inboxMux.Lock()
+	defer node.inboxMux.Unlock()
+	all, err := node.readInbox()
+	if err != nil {
+		return nil, err
+	}
+	var msgs []api.InboxMsg
+	for _, m := range all {
+		if max > 0 && int64(len(msgs)) >= max {
+			break
+		}
+		if !m.Leased(now) {
+			m.Lease = lease
+			m.Attempts++
+			if err := node.writeInboxMsg(m); err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+func (node *Node) inboxAck(ids ...int64) error {
+	node.inboxMux.Lock()
+	defer node.inboxMux.Unlock()
+	for _, id := range ids {
+		if err := os.Remove(node.inboxPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (node *Node) inboxNack(ids ...int64) error {
+	node.inboxMux.Lock()
+	defer node.inboxMux.Unlock()
+	for _, id := range ids {
+		b, err := ioutil.ReadFile(node.inboxPath(id))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		args, err := api.ArgsFromBytes(b)
+		if err != nil {
+			return err
+		}
+		msg, err := api.InboxMsgFromArgs(args)
+		if err != nil {
+			return err
+		}
+		msg.Lease = 0
+		if err := node.writeInboxMsg(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
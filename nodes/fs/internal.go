@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
+	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // GetChannelPrivKey : Return the private key of a given channel
@@ -24,29 +28,10 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
-	flags := uint8(0)
-	if msg.IsChan {
-		flags |= api.ChannelFlag
-	}
-	if msg.Chunked {
-		flags |= api.ChunkedFlag
-	}
-	if msg.StreamHeader {
-		flags |= api.StreamHeaderFlag
-	}
-	rxsum := []byte{flags} // prepend flags byte
+	rxsum := api.EncodeMsgHeader(&msg) // flags, extensions and channel name
 	m := new(outboxMsg)
-	path := node.basePath
-	if msg.IsChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(msg.Name))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(msg.Name)...)
-		m.channel = msg.Name
-		// create channel dir if not exist
-		path = filepath.Join(path, msg.Name)
-		os.Mkdir(path, os.FileMode(int(0700)))
-	}
+	m.channel = nodes.OutboxChannel(&msg)
+	path := node.outboxDir(m.channel, msg.NextHop)
 	message := append(rxsum, msg.Content.Bytes()...)
 	/*
 		for _, mail := range node.outbox {
@@ -56,7 +41,8 @@ func (node *Node) Forward(msg api.Msg) error {
 		}
 	*/
 
-	f, err := os.Create(filepath.Join(path, hex(node.outboxIndex)))
+	expiry := nodes.OutboxExpiry(node, m.channel, &msg, time.Now().UnixNano())
+	f, err := os.Create(filepath.Join(path, outboxFileName(node.outboxIndex, expiry)))
 	if err != nil {
 		return err
 	}
@@ -69,6 +55,11 @@ func (node *Node) Forward(msg api.Msg) error {
 	return nil
 }
 
+// PeelOnion - Decrypt an onion layer addressed to this node's routing key
+func (node *Node) PeelOnion(msg api.Msg) (bool, []byte, error) {
+	return api.DecryptMessage(node.routingKey, msg.Content.Bytes())
+}
+
 // Handle - Decrypt and handle an encrypted message
 func (node *Node) Handle(msg api.Msg) (bool, error) {
 	var clear []byte
@@ -82,15 +73,28 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 			return tagOK, errors.New("Cannot Handle message for Unknown Channel")
 		}
 		clearMsg = api.Msg{Name: msg.Name, IsChan: true, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = v.Privkey.DecryptMessage(msg.Content.Bytes())
+		tagOK, clear, err = api.DecryptMessage(v.Privkey, msg.Content.Bytes())
+	} else if len(msg.Name) > 0 {
+		clearMsg = api.Msg{Name: msg.Name, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
+		var key bc.KeyPair
+		if key, err = node.privProfile(msg.Name); err != nil {
+			return false, err
+		}
+		tagOK, clear, err = api.DecryptMessage(key, msg.Content.Bytes())
 	} else {
-		clearMsg = api.Msg{Name: "[content]", IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = node.contentKey.DecryptMessage(msg.Content.Bytes())
+		clearMsg = api.Msg{Name: api.ContentName, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
+		tagOK, clear, err = api.DecryptMessage(node.contentKey, msg.Content.Bytes())
 	}
 	// DecryptMessage will return !tagOK if the quick-check fails, which is common
 	if !tagOK || err != nil {
 		return tagOK, err
 	}
+	if clear, err = nodes.UnpadMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
+	if clear, err = nodes.DecompressMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
 	clearMsg.Content = bytes.NewBuffer(clear)
 
 	if msg.Chunked {
@@ -101,12 +105,17 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 		return true, err
 	}
 
-	select {
-	case node.Out() <- clearMsg:
-		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
-	default:
+	delivered, err := nodes.Deliver(node, clearMsg, node.inboxAppend)
+	if err != nil {
+		events.Dropped(node, clearMsg, api.DropInbox)
+		return tagOK, err
+	} else if !delivered {
 		events.Debug(node, "No message sent")
+		events.Dropped(node, clearMsg, api.DropOutFull)
+		return tagOK, nil
 	}
+	events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+	events.Delivered(node, clearMsg)
 	return tagOK, nil
 }
 
@@ -116,12 +125,32 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 	stream.StreamID = streamID
 	stream.NumChunks = totalChunks
 	stream.ChannelName = channelName
+	if node.streams[streamID] == nil {
+		atomic.AddInt32(&node.streamsPending, 1)
+	}
 	node.streams[streamID] = stream
+	for chunkNum := range node.chunks[streamID] { // drop chunks that arrived ahead of the header but don't fit it
+		if chunkNum >= totalChunks {
+			delete(node.chunks[streamID], chunkNum)
+		}
+	}
 	return nil
 }
 
+func (node *Node) streamCount() (int, error) {
+	return int(atomic.LoadInt32(&node.streamsPending)), nil
+}
+
 // AddChunk - adds a chunk of a partial message to internal storage
 func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error {
+	var totalChunks uint32
+	if stream := node.streams[streamID]; stream != nil {
+		totalChunks = stream.NumChunks
+	}
+	_, received := node.chunks[streamID][chunkNum]
+	if err := chunking.CheckChunkNum(chunkNum, totalChunks, received); err != nil {
+		return err
+	}
 	chunk := new(api.Chunk)
 	chunk.StreamID = streamID
 	chunk.ChunkNum = chunkNum
@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // ID : Return routing key
@@ -18,12 +20,17 @@ func (node *Node) ID() (bc.PubKey, error) {
 }
 
 // Dropoff : Deliver a batch of  messages to a remote node
-func (node *Node) Dropoff(bundle api.Bundle) error {
+func (node *Node) Dropoff(bundle api.Bundle) (err error) {
+	defer func() {
+		if err != nil {
+			events.Rejected(node, bundle, err)
+		}
+	}()
 	events.Debug(node, "Dropoff called")
 	if len(bundle.Data) < 1 { // todo: correct min length
 		return errors.New("Dropoff called with no data")
 	}
-	tagOK, data, err := node.routingKey.DecryptMessage(bundle.Data)
+	tagOK, data, err := api.DecryptMessage(node.routingKey, bundle.Data)
 	if err != nil {
 		return err
 	} else if !tagOK {
@@ -36,7 +43,7 @@ func (node *Node) Dropoff(bundle api.Bundle) error {
 	}
 	for i := 0; i < len(*msgs); i++ {
 		if len((*msgs)[i]) < 16 { // aes.BlockSize == 16
-			continue // todo: remove padding before here?
+			continue // too short to be a message, bundle padding is ignored by BytesBytesFromBytes
 		}
 		err = node.router.Route(node, (*msgs)[i])
 		if err != nil {
@@ -56,14 +63,23 @@ func (node *Node) Pickup(rpub bc.PubKey, lastTime int64, maxBytes int64, channel
 	var msgs [][]byte
 	retval.Time = lastTime
 	var bytesRead int64
+	now := time.Now().UnixNano()
+	hopDir := onionHopDir(node.basePath, rpub.ToB64())
 
 	err := filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			events.Error(node, "Pickup failure accessing a path:", path, err)
 			return err
 		}
+		if info.IsDir() && info.Name() == inboxDir {
+			return filepath.SkipDir
+		}
+		// onion layers only go to their next relay
+		if info.IsDir() && filepath.Dir(path) == filepath.Join(node.basePath, onionDir) && path != hopDir {
+			return filepath.SkipDir
+		}
 		fileTime := info.ModTime().UnixNano()
-		if !info.IsDir() && fileTime > lastTime {
+		if !info.IsDir() && fileTime > lastTime && !api.Expired(outboxFileExpiry(info.Name()), now) {
 			b, err := ioutil.ReadFile(path) // filepath.Join(node.basePath, path))
 			if err != nil {
 				events.Error(node, "prevent panic by handling failure reading a file:", path, err)
@@ -89,10 +105,16 @@ func (node *Node) Pickup(rpub bc.PubKey, lastTime int64, maxBytes int64, channel
 		return retval, err
 	}
 
+	// strip or withhold extensions the peer did not report support for
+	msgs = api.DowngradeMessages(msgs, node.peerVersions.Get(rpub.ToB64()).Caps)
+
 	// transmit
 	if len(msgs) > 0 {
-		buf := api.BytesBytesToBytes(&msgs)
-		cipher, err := node.routingKey.EncryptMessage(*buf, rpub)
+		buf, err := nodes.PadBundle(node, *api.BytesBytesToBytes(&msgs), maxBytes)
+		if err != nil {
+			return retval, err
+		}
+		cipher, err := node.routingKey.EncryptMessage(buf, rpub)
 		if err != nil {
 			return retval, err
 		}
@@ -0,0 +1,19 @@
+package nodes
+
+import "github.com/awgh/ratnet/api"
+
+// Health : aggregates the status reported by a node's policies and their transports
+func Health(node api.Node) api.Health {
+	var components []api.ComponentHealth
+	seen := make(map[api.HealthReporter]bool) // policies may share a transport
+	for _, p := range node.GetPolicies() {
+		reporters := []interface{}{p, p.GetTransport()}
+		for _, r := range reporters {
+			if hr, ok := r.(api.HealthReporter); ok && !seen[hr] {
+				seen[hr] = true
+				components = append(components, hr.Health())
+			}
+		}
+	}
+	return api.NewHealth(node.IsRunning(), components...)
+}
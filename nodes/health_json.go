@@ -0,0 +1,45 @@
+// +build !no_json
+
+package nodes
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// HealthHandler : serves the Health of a node as JSON, /health answers 200 unless the node is down,
+// and /ready answers 200 only when it is ready, both answer 503 otherwise
+func HealthHandler(node api.Node) http.Handler {
+	mux := http.NewServeMux()
+	serve := func(ok func(api.Health) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h, err := node.GetHealth()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if !ok(h) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			_ = json.NewEncoder(w).Encode(h)
+		}
+	}
+	mux.Handle("/health", serve(func(h api.Health) bool { return h.State != api.HealthDown }))
+	mux.Handle("/ready", serve(func(h api.Health) bool { return h.Ready }))
+	return mux
+}
+
+// ListenHealth : serves HealthHandler on addr until the returned server is closed
+func ListenHealth(addr string, node api.Node) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: l.Addr().String(), Handler: HealthHandler(node)}
+	go func() { _ = srv.Serve(l) }()
+	return srv, nil
+}
@@ -0,0 +1,59 @@
+package nodes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// InboxEnabled : reports whether received messages go to the inbox instead of Out(), see api.ConfigInbox
+func InboxEnabled(node api.Node) bool {
+	v, err := node.GetConfig(api.ConfigInbox)
+	return err == nil && v == "true"
+}
+
+// Deliver : hands a received message to the application. If api.ConfigInbox is set, it is written to the inbox
+// and published to the subscriptions on the node's MsgBus that select it, which may drop it. Otherwise it goes
+// to those subscriptions, or if there are none, on Out() without blocking.
+// delivered is false if Out() is full or the inbox could not be written
+func Deliver(node api.Node, msg api.Msg, inbox func(api.InboxMsg) error) (delivered bool, err error) {
+	if InboxEnabled(node) {
+		m := api.InboxMsg{Name: msg.Name, IsChan: msg.IsChan, Received: time.Now().UnixNano()}
+		if msg.Content != nil {
+			m.Content = msg.Content.Bytes()
+		}
+		if err := inbox(m); err != nil {
+			return false, err
+		}
+		node.MsgBus().Publish(msg)
+		return true, nil
+	}
+	if node.MsgBus().Publish(msg) {
+		return true, nil
+	}
+	select {
+	case node.Out() <- msg:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// InboxIDs : hands out increasing inbox message IDs, which stay increasing across restarts as they follow the clock
+type InboxIDs struct {
+	mu   sync.Mutex
+	last int64
+}
+
+// Next : returns an ID greater than any returned before
+func (g *InboxIDs) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := time.Now().UnixNano()
+	if id <= g.last {
+		id = g.last + 1
+	}
+	g.last = id
+	return id
+}
@@ -0,0 +1,47 @@
+package nodes
+
+import (
+	"math"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/metrics"
+)
+
+// RegisterOutboxMetric - reports the depth of a node's outbox in metrics.Default, labelled with its routing key
+func RegisterOutboxMetric(node api.Node, count func() (int, error)) {
+	registerCount(node, metrics.OutboxMessages, count)
+}
+
+// UnregisterOutboxMetric - stops reporting the depth of a node's outbox
+func UnregisterOutboxMetric(node api.Node) {
+	registerCount(node, metrics.OutboxMessages, nil)
+}
+
+// RegisterStreamsMetric - reports the chunked streams a node is waiting on in metrics.Default, labelled with its routing key
+func RegisterStreamsMetric(node api.Node, count func() (int, error)) {
+	registerCount(node, metrics.StreamsPending, count)
+}
+
+// UnregisterStreamsMetric - stops reporting the chunked streams a node is waiting on
+func UnregisterStreamsMetric(node api.Node) {
+	registerCount(node, metrics.StreamsPending, nil)
+}
+
+// registerCount - sets the gauge of one node to count, or removes it if count is nil
+func registerCount(node api.Node, name string, count func() (int, error)) {
+	id, err := node.ID()
+	if err != nil || id == nil {
+		return
+	}
+	if count == nil {
+		metrics.Default.SetFunc(name, nil, "node", id.ToB64())
+		return
+	}
+	metrics.Default.SetFunc(name, func() float64 {
+		n, err := count()
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(n)
+	}, "node", id.ToB64())
+}
@@ -0,0 +1,38 @@
+package nodes
+
+import (
+	"bytes"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// MixLocal - hands an encoded message the node is sending to its router's mix pool, if the router pools local messages,
+// sent is the message data was encoded from, its Channel and NextHop are kept for the outbox,
+// returns false if the node should queue the message in its outbox itself
+func MixLocal(node api.Node, sent *api.Msg, data []byte) (bool, error) {
+	mixer, ok := node.Router().(api.Mixer)
+	if !ok {
+		return false, nil
+	}
+	hdr, err := api.ParseMsgHeader(data)
+	if err != nil {
+		return false, err
+	}
+	msg := hdr.Msg()
+	if msg.Tagged {
+		msg.Channel = sent.Channel
+	}
+	msg.NextHop = sent.NextHop
+	msg.Content = bytes.NewBuffer(data[hdr.Size:])
+	return mixer.MixLocal(node, msg)
+}
+
+// FlushMix - forwards the messages the node's router holds in its mix pool to the outbox, so they are not lost when the node stops
+func FlushMix(node api.Node) {
+	if mixer, ok := node.Router().(api.Mixer); ok {
+		if err := mixer.FlushMix(node); err != nil {
+			events.Warning(node, "mix flush error: "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package nodes
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// OutboxRetention : returns the seconds messages of a channel stay in the outbox, from api.OutboxRetentionConfig
+// or api.ConfigOutboxRetention, or maxAgeSeconds if neither is set, 0 keeps them until they expire
+func OutboxRetention(node api.Node, channelName string, maxAgeSeconds int64) int64 {
+	for _, name := range []string{api.OutboxRetentionConfig(channelName), api.ConfigOutboxRetention} {
+		v, err := node.GetConfig(name)
+		if err != nil || v == "" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seconds < 0 {
+			events.Warning(node, "invalid outbox retention "+name+": "+v)
+			continue
+		}
+		return seconds
+	}
+	return maxAgeSeconds
+}
+
+// FlushOutbox : deletes the messages of each channel that are older than its retention,
+// maxAgeSeconds applies to channels without a retention configured
+func FlushOutbox(node api.Node, maxAgeSeconds int64) {
+	counts, err := node.GetOutboxCounts()
+	if err != nil {
+		events.Error(node, "FlushOutbox: "+err.Error())
+		return
+	}
+	for _, c := range counts {
+		retention := OutboxRetention(node, c.Channel, -1)
+		if retention == 0 { // configured to keep messages until they expire
+			continue
+		} else if retention < 0 {
+			retention = maxAgeSeconds
+		}
+		if _, err := node.DeleteOutbox(retention, c.Channel); err != nil {
+			events.Error(node, "FlushOutbox: "+err.Error())
+		}
+	}
+	events.Emit(node, api.Info, api.OutboxFlushed, api.FlushEvent{MaxAge: maxAgeSeconds})
+}
+
+// AddExpiry : records the expiry the sender set in the message header, so relays drop the message too
+func AddExpiry(node api.Node, msg *api.Msg) error {
+	if msg.Expiry == 0 {
+		return nil
+	}
+	if msg.Expiry <= time.Now().UnixNano() {
+		return errors.New("Message has already expired")
+	}
+	msg.SetExpiryExtension(msg.Expiry)
+	return nil
+}
+
+// OutboxChannel : returns the channel name a message is queued under in the outbox, "" for a message to a content key,
+// a Tagged message is queued under its channel name, not the tag on the wire, if the node knows it
+func OutboxChannel(msg *api.Msg) string {
+	if !msg.IsChan {
+		return ""
+	}
+	if msg.Tagged && msg.Channel != "" {
+		return msg.Channel
+	}
+	return msg.Name
+}
+
+// OutboxExpiry : returns when a message queued at now on channelName leaves the outbox, in unix nanoseconds:
+// the expiry the sender set, or the end of the channel's retention, whichever comes first, 0 for never
+func OutboxExpiry(node api.Node, channelName string, msg *api.Msg, now int64) int64 {
+	var expiry int64
+	if retention := OutboxRetention(node, channelName, api.DefaultOutboxRetention); retention > 0 {
+		expiry = now + retention*int64(time.Second)
+	}
+	if msg.Expiry != 0 && (expiry == 0 || msg.Expiry < expiry) {
+		expiry = msg.Expiry
+	}
+	if e, ok := msg.ExpiryExtension(); ok && (expiry == 0 || e < expiry) {
+		expiry = e
+	}
+	return expiry
+}
+
+// JanitorInterval : how often a Janitor deletes expired messages
+var JanitorInterval = 10 * time.Second
+
+// Janitor : deletes expired messages from a node's outbox in the background
+type Janitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartJanitor : calls purge with the current time in unix nanoseconds every JanitorInterval until Stop,
+// purge returns how many expired messages it deleted
+func StartJanitor(node api.Node, purge func(now int64) (int64, error)) *Janitor {
+	j := &Janitor{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(JanitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				n, err := purge(time.Now().UnixNano())
+				if err != nil {
+					events.Error(node, "Janitor: "+err.Error())
+				} else if n > 0 {
+					events.Debug(node, "Janitor deleted "+strconv.FormatInt(n, 10)+" expired messages")
+				}
+			}
+		}
+	}()
+	return j
+}
+
+// Stop : stops the janitor and waits for it to finish, safe to call on a nil Janitor
+func (j *Janitor) Stop() {
+	if j == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+}
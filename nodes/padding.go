@@ -0,0 +1,63 @@
+package nodes
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/padding"
+)
+
+// paddingMode : returns the padding mode set by ConfigPadding
+func paddingMode(node api.Node) (padding.Mode, error) {
+	v, err := node.GetConfig(api.ConfigPadding)
+	if err != nil {
+		return padding.None, err
+	}
+	m, err := padding.ByName(v)
+	if err != nil {
+		return padding.None, errors.New("Invalid " + api.ConfigPadding + " config: " + v)
+	}
+	return m, nil
+}
+
+// PadMsg : pads the content of a message this node sends as set by ConfigPadding,
+// fixed padding fills the message up to chunkSize, the size SendMsg chunks at after the onion overhead of its route,
+// less the message header, so it is called once every other extension is set
+func PadMsg(node api.Node, msg *api.Msg, chunkSize uint32) error {
+	m, err := paddingMode(node)
+	if err != nil || m == padding.None || msg.GetExtension(api.ExtPadding) != nil {
+		return err
+	}
+	msg.SetExtension(api.ExtPadding, nil)
+	limit := int(chunkSize) - len(api.EncodeMsgHeader(msg))
+	n := msg.Content.Len() + 1 // the padding marker
+	msg.Content = bytes.NewBuffer(padding.Pad(msg.Content.Bytes(), m.Size(n, limit)))
+	return nil
+}
+
+// UnpadMsg : strips the padding from the decrypted content of a message flagged with ExtPadding
+func UnpadMsg(msg api.Msg, clear []byte) ([]byte, error) {
+	if msg.GetExtension(api.ExtPadding) == nil {
+		return clear, nil
+	}
+	return padding.Unpad(clear)
+}
+
+// bundleOverhead : room left in fixed padding for the encryption and RPC framing of a bundle
+const bundleOverhead = 256
+
+// PadBundle : pads the serialized messages of a bundle before Pickup encrypts them, as set by ConfigPadding,
+// fixed padding fills the bundle up to the maxBytes the transport allows.
+// Only zeros are added, decoders stop at the end of the messages array, so Dropoff needs no flag to ignore them.
+func PadBundle(node api.Node, buf []byte, maxBytes int64) ([]byte, error) {
+	m, err := paddingMode(node)
+	if err != nil || m == padding.None {
+		return buf, err
+	}
+	size := m.Size(len(buf), int(maxBytes)-bundleOverhead)
+	if size <= len(buf) {
+		return buf, nil
+	}
+	return append(buf, make([]byte, size-len(buf))...), nil
+}
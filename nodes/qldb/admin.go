@@ -11,6 +11,7 @@ import (
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // CID : Return content key
@@ -119,6 +120,20 @@ func (node *Node) LoadProfile(name string) (bc.PubKey, error) {
 	return profileKey.GetPubKey(), nil
 }
 
+// privProfile : Internal call to load secret key only for decryption operation
+func (node *Node) privProfile(name string) (bc.KeyPair, error) {
+	pk := node.qlGetProfilePrivateKey(name)
+	if pk == "" {
+		return nil, errors.New("No matching profile key found")
+	}
+	profileKey := node.contentKey.Clone()
+	if err := profileKey.FromB64(pk); err != nil {
+		events.Error(node, err)
+		return nil, err
+	}
+	return profileKey, nil
+}
+
 // GetPeer : Retrieve a peer by name
 func (node *Node) GetPeer(name string) (*api.Peer, error) {
 	return node.qlGetPeer(name)
@@ -144,6 +159,67 @@ func (node *Node) DeletePeer(name string) error {
 	return nil
 }
 
+// SetPeerIdentity : Pin the routing pubkey and/or certificate fingerprint a peer must present
+func (node *Node) SetPeerIdentity(name string, pubkey string, fingerprint string) error {
+	return node.qlSetPeerIdentity(name, pubkey, fingerprint)
+}
+
+// GetConfig : Retrieve a node configuration value, "" if it is not set
+func (node *Node) GetConfig(name string) (string, error) {
+	if api.IsReservedConfig(name) {
+		return "", api.ErrReservedConfig
+	}
+	return node.qlGetConfig(name)
+}
+
+// SetConfig : Set a node configuration value, "" removes it
+func (node *Node) SetConfig(name string, value string) error {
+	if api.IsReservedConfig(name) {
+		return api.ErrReservedConfig
+	}
+	return node.qlSetConfig(name, value)
+}
+
+// GetHealth : Report the status of this node, its policies and their transports
+func (node *Node) GetHealth() (api.Health, error) {
+	return nodes.Health(node), nil
+}
+
+// GetOutbox : List the messages queued for the given channels, or every channel if none are given
+func (node *Node) GetOutbox(channelNames ...string) ([]api.OutboxEntry, error) {
+	return node.qlGetOutbox(channelNames...)
+}
+
+// GetOutboxCounts : Count the messages and bytes queued for each channel
+func (node *Node) GetOutboxCounts() ([]api.OutboxCount, error) {
+	entries, err := node.qlGetOutbox()
+	if err != nil {
+		return nil, err
+	}
+	return api.CountOutbox(entries), nil
+}
+
+// DeleteOutbox : Remove messages older than maxAgeSeconds queued for the given channels, or every channel if none are given
+func (node *Node) DeleteOutbox(maxAgeSeconds int64, channelNames ...string) (int64, error) {
+	return node.qlDeleteOutbox(api.OutboxCutoff(maxAgeSeconds), channelNames...)
+}
+
+// FetchInbox : Lease up to max received messages for leaseSeconds, oldest first
+func (node *Node) FetchInbox(max int64, leaseSeconds int64) ([]api.InboxMsg, error) {
+	now := time.Now().UnixNano()
+	return node.qlFetchInbox(max, now, api.InboxLease(now, leaseSeconds))
+}
+
+// AckInbox : Remove fetched messages from the inbox
+func (node *Node) AckInbox(ids ...int64) error {
+	return node.qlAckInbox(ids...)
+}
+
+// NackInbox : End the lease of fetched messages, so they are fetched again
+func (node *Node) NackInbox(ids ...int64) error {
+	return node.qlNackInbox(ids...)
+}
+
 // Send : Transmit a message to a single key
 func (node *Node) Send(contactName string, data []byte, pubkey ...bc.PubKey) error {
 	var destkey bc.PubKey
@@ -187,42 +263,47 @@ func (node *Node) SendChannel(channelName string, data []byte, pubkey ...bc.PubK
 // SendMsg : Transmits a message
 func (node *Node) SendMsg(msg api.Msg) error {
 	// determine if we need to chunk
-	chunkSize := chunking.ChunkSize(node)                               // finds the minimum transport byte limit
-	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize { // we need to chunk
+	chunkSize := chunking.ChunkSize(node) - api.OnionOverhead(len(msg.Route)) // finds the minimum transport byte limit
+	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize {       // we need to chunk
 		if msg.Chunked { // we're already chunked, freak out!
 			return errors.New("Chunked message needs to be chunked, bailing out")
 		}
 		return chunking.SendChunked(node, chunkSize, msg)
 	}
-	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
-	if err != nil {
+	if err := nodes.CompressMsg(node, &msg); err != nil {
 		return err
 	}
-
-	flags := uint8(0)
-	if msg.IsChan {
-		flags |= api.ChannelFlag
+	if err := nodes.AddHopLimit(node, &msg); err != nil {
+		return err
 	}
-	if msg.Chunked {
-		flags |= api.ChunkedFlag
+	if err := nodes.AddExpiry(node, &msg); err != nil {
+		return err
 	}
-	if msg.StreamHeader {
-		flags |= api.StreamHeaderFlag
+	if err := nodes.TagChannel(node, &msg); err != nil {
+		return err
 	}
-	rxsum := []byte{flags} // prepend flags byte
-
-	if msg.IsChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(msg.Name))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(msg.Name)...)
+	if err := nodes.PadMsg(node, &msg, chunkSize); err != nil { // last, it leaves room for the header
+		return err
 	}
+	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
+	if err != nil {
+		return err
+	}
+	rxsum := api.EncodeMsgHeader(&msg) // flags, extensions and channel name
 	data = append(rxsum, data...)
-	ts := time.Now().UnixNano()
-	if msg.IsChan {
-		return node.qlOutboxEnqueue(msg.Name, data, ts, false)
+	if len(msg.Route) > 0 { // the channel name is only visible to the last relay
+		if data, err = api.WrapOnion(node.routingKey, data, msg.Route); err != nil {
+			return err
+		}
+		msg.IsChan = false
+		msg.NextHop = msg.Route[0].ToB64() // only the first relay picks it up
 	}
-	return node.qlOutboxEnqueue("", data, ts, false)
+	if mixed, err := nodes.MixLocal(node, &msg, data); mixed || err != nil {
+		return err
+	}
+	ts := time.Now().UnixNano()
+	channelName := nodes.OutboxChannel(&msg)
+	return node.qlOutboxEnqueue(channelName, msg.NextHop, data, ts, nodes.OutboxExpiry(node, channelName, &msg, ts), false)
 }
 
 // SendBulk : Transmit messages to a single key
@@ -271,17 +352,11 @@ func (node *Node) SendChannelBulk(channelName string, data [][]byte, pubkey ...b
 
 func (node *Node) sendBulk(channelName string, destkey bc.PubKey, msg [][]byte) error {
 	isChan := (channelName != "")
-	flags := uint8(0)
-	if isChan {
-		flags |= api.ChannelFlag
-	}
-	rxsum := []byte{flags} // prepend flags byte
-	if isChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(channelName))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(channelName)...)
+	hdr := api.Msg{Name: channelName, IsChan: isChan}
+	if err := nodes.AddHopLimit(node, &hdr); err != nil {
+		return err
 	}
+	rxsum := api.EncodeMsgHeader(&hdr) // flags, extensions and channel name
 
 	// todo: is this passing msg by reference or not???
 	data := make([][]byte, len(msg))
@@ -294,7 +369,7 @@ func (node *Node) sendBulk(channelName string, destkey bc.PubKey, msg [][]byte)
 		data[i] = append(rxsum, data[i]...)
 	}
 	ts := time.Now().UnixNano()
-	node.outboxBulkInsert(channelName, ts, data)
+	node.outboxBulkInsert(channelName, ts, nodes.OutboxExpiry(node, channelName, &hdr, ts), data)
 	return nil
 }
 
@@ -305,6 +380,9 @@ func (node *Node) Start() error {
 		return nil
 	}
 	node.setIsRunning(true)
+	nodes.RegisterOutboxMetric(node, node.outboxCount)
+	nodes.RegisterStreamsMetric(node, node.streamCount)
+	node.janitor = nodes.StartJanitor(node, node.deleteExpired)
 
 	// start the policies
 	if node.policies != nil {
@@ -360,9 +438,19 @@ func (node *Node) Start() error {
 						events.Critical(node, err.Error())
 					}
 					buf := bytes.NewBuffer([]byte{})
-					for _, chunk := range chunks {
+					missing := false
+					for i, chunk := range chunks {
+						if chunk.ChunkNum != uint32(i) {
+							events.Error(node, "Dropping stream %x: chunk %d missing", stream.StreamID, i)
+							missing = true
+							break
+						}
 						buf.Write(chunk.Data)
 					}
+					if missing {
+						node.qlClearStream(stream.StreamID)
+						continue
+					}
 					var msg api.Msg
 					if len(stream.ChannelName) > 0 {
 						msg.IsChan = true
@@ -370,11 +458,13 @@ func (node *Node) Start() error {
 					}
 					msg.Content = buf
 
-					select {
-					case node.Out() <- msg:
+					if delivered, err := nodes.Deliver(node, msg, node.inboxAppend); err != nil {
+						events.Error(node, "Inbox: "+err.Error())
+					} else if delivered {
 						events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+						events.Emit(node, api.Info, api.StreamCompleted, api.StreamEvent{StreamID: stream.StreamID, NumChunks: stream.NumChunks, Channel: stream.ChannelName})
 						node.qlClearStream(stream.StreamID)
-					default:
+					} else {
 						events.Debug(node, "No message sent")
 					}
 				}
@@ -387,11 +477,16 @@ func (node *Node) Start() error {
 
 // Stop : sets the isRunning flag to false, indicating that all go routines should end
 func (node *Node) Stop() {
+	nodes.UnregisterOutboxMetric(node)
+	nodes.UnregisterStreamsMetric(node)
+	node.janitor.Stop()
+	node.janitor = nil
 	for _, policy := range node.policies {
 		policy.Stop()
 	}
+	nodes.FlushMix(node)
 	node.setIsRunning(false)
 	close(node.in)
 	close(node.out)
-	close(node.events)
+	node.bus.Close()
 }
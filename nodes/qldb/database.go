@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // THIS SHOULD BE THE ONLY FILE THAT INCLUDES database/sql !!!
@@ -366,12 +368,13 @@ func (node *Node) qlGetProfilePrivateKey(name string) string {
 func (node *Node) qlGetPeer(name string) (*api.Peer, error) {
 	c := node.db()
 	defer closeDB(c)
-	sqlq := "SELECT uri,enabled FROM peers WHERE name==$1;"
+	sqlq := "SELECT uri,enabled,peergroup,pubkey,fingerprint FROM peers WHERE name==$1;"
 	events.Info(node, sqlq, name)
 	r := c.QueryRow(sqlq, name)
-	var u string
+	var u, g string
 	var e bool
-	if err := r.Scan(&u, &e); err == sql.ErrNoRows {
+	var pk, fp sql.NullString
+	if err := r.Scan(&u, &e, &g, &pk, &fp); err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
@@ -380,13 +383,16 @@ func (node *Node) qlGetPeer(name string) (*api.Peer, error) {
 	peer.Name = name
 	peer.Enabled = e
 	peer.URI = u
+	peer.Group = g
+	peer.Pubkey = pk.String
+	peer.Fingerprint = fp.String
 	return peer, nil
 }
 
 func (node *Node) qlGetPeers(group string) ([]api.Peer, error) {
 	c := node.db()
 	defer closeDB(c)
-	sqlq := "SELECT name,uri,enabled,peergroup FROM peers WHERE peergroup==$1;"
+	sqlq := "SELECT name,uri,enabled,peergroup,pubkey,fingerprint FROM peers WHERE peergroup==$1;"
 	events.Info(node, sqlq, group)
 	r, err := c.Query(sqlq, group)
 	if r == nil || err != nil {
@@ -396,9 +402,12 @@ func (node *Node) qlGetPeers(group string) ([]api.Peer, error) {
 	var peers []api.Peer
 	for r.Next() {
 		var s api.Peer
-		if err := r.Scan(&s.Name, &s.URI, &s.Enabled, &s.Group); err != nil {
+		var pk, fp sql.NullString
+		if err := r.Scan(&s.Name, &s.URI, &s.Enabled, &s.Group, &pk, &fp); err != nil {
 			return nil, err
 		}
+		s.Pubkey = pk.String
+		s.Fingerprint = fp.String
 		peers = append(peers, s)
 	}
 	return peers, nil
@@ -425,11 +434,73 @@ func (node *Node) qlAddPeer(name string, enabled bool, uri string, group string)
 	return nil
 }
 
+func (node *Node) qlSetPeerIdentity(name string, pubkey string, fingerprint string) error {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT name FROM peers WHERE name==$1;"
+	events.Info(node, sqlq, name)
+	r := c.QueryRow(sqlq, name)
+	var n string
+	if err := r.Scan(&n); err == sql.ErrNoRows {
+		return errors.New("Peer not found")
+	} else if err != nil {
+		return err
+	}
+	node.transactExec("UPDATE peers SET pubkey=$1,fingerprint=$2 WHERE name==$3;", pubkey, fingerprint, name)
+	return nil
+}
+
+func (node *Node) qlGetConfig(name string) (string, error) {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT value FROM config WHERE name==$1;"
+	events.Info(node, sqlq, name)
+	r := c.QueryRow(sqlq, name)
+	var v string
+	if err := r.Scan(&v); err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+func (node *Node) qlGetConfigs() ([]api.ConfigValue, error) {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT name,value FROM config;"
+	events.Info(node, sqlq)
+	r, err := c.Query(sqlq)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var configs []api.ConfigValue
+	for r.Next() {
+		var cv api.ConfigValue
+		if err := r.Scan(&cv.Name, &cv.Value); err != nil {
+			return nil, err
+		}
+		if !api.IsReservedConfig(cv.Name) {
+			configs = append(configs, cv)
+		}
+	}
+	return configs, nil
+}
+
+func (node *Node) qlSetConfig(name string, value string) error {
+	node.transactExec("DELETE FROM config WHERE name==$1;", name)
+	if value != "" {
+		node.transactExec("INSERT INTO config VALUES( $1, $2 );", name, value)
+	}
+	return nil
+}
+
 func (node *Node) qlDeletePeer(name string) {
 	node.transactExec("DELETE FROM peers WHERE name==$1;", name)
 }
 
-func (node *Node) qlOutboxEnqueue(channelName string, msg []byte, ts int64, checkExists bool) error {
+func (node *Node) qlOutboxEnqueue(channelName, nextHop string, msg []byte, ts, expiry int64, checkExists bool) error {
 	doInsert := !checkExists
 
 	if checkExists {
@@ -449,27 +520,27 @@ func (node *Node) qlOutboxEnqueue(channelName string, msg []byte, ts int64, chec
 		}
 	}
 	if doInsert {
-		node.transactExec("INSERT INTO outbox(channel,msg,timestamp) VALUES($1,$2,$3);",
-			channelName, msg, ts)
+		node.transactExec("INSERT INTO outbox(channel,msg,timestamp,expiry,nexthop) VALUES($1,$2,$3,$4,$5);",
+			channelName, msg, ts, expiry, nextHop)
 	}
 	return nil
 }
 
-func (node *Node) outboxBulkInsert(channelName string, timestamp int64, msgs [][]byte) {
+func (node *Node) outboxBulkInsert(channelName string, timestamp, expiry int64, msgs [][]byte) {
 	c := node.db()
 	defer closeDB(c)
 	tx, err := c.Begin()
 	if err != nil {
 		events.Critical(node, err.Error())
 	}
-	args := make([]interface{}, 1+(2*len(msgs)))
+	args := make([]interface{}, 2+(2*len(msgs)))
 	args[0] = channelName
-	// args[1] = timestamp
-	idx := 2                                                    // starting 1-based index for 2nd arg
-	sql := "INSERT INTO outbox(channel, msg, timestamp) VALUES" //($1,$2, $3);
+	args[1] = expiry
+	idx := 3                                                            // starting 1-based index for 3rd arg
+	sql := "INSERT INTO outbox(channel, msg, timestamp, expiry, nexthop) VALUES" //($1,$3, $4, $2, "");
 	for i, v := range msgs {
 		// sql += "($1,$" + strconv.Itoa(i+3) + ", $2)"
-		sql += "($1,$" + strconv.Itoa(idx) + ", $" + strconv.Itoa(idx+1) + ")"
+		sql += "($1,$" + strconv.Itoa(idx) + ", $" + strconv.Itoa(idx+1) + ", $2, \"\")"
 		if i != len(msgs) {
 			sql += ", "
 		} else {
@@ -491,7 +562,8 @@ func (node *Node) outboxBulkInsert(channelName string, timestamp int64, msgs [][
 	}
 }
 
-func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string) ([][]byte, int64, error) {
+// qlGetMessages - returns the unexpired messages queued after lastTime, onion layers only if they are for the peer with routing key rpub
+func (node *Node) qlGetMessages(lastTime, maxBytes int64, rpub string, channelNames ...string) ([][]byte, int64, error) {
 	c := node.db()
 	defer closeDB(c)
 	lastTimeReturned := lastTime
@@ -510,7 +582,7 @@ func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string
 			}
 		}
 	}
-	sqlq := "SELECT msg, timestamp FROM outbox"
+	sqlq := "SELECT msg, timestamp, expiry, nexthop FROM outbox"
 	if lastTime != 0 {
 		sqlq += " WHERE (int64(" + strconv.FormatInt(lastTime, 10) +
 			") < timestamp)"
@@ -538,12 +610,17 @@ func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string
 	}
 	defer r.Close()
 
+	now := time.Now().UnixNano()
 	n := 0
 	for r.Next() {
 		n++
 		var msg []byte
-		var ts int64
-		r.Scan(&msg, &ts)
+		var ts, expiry int64
+		var nextHop string
+		r.Scan(&msg, &ts, &expiry, &nextHop)
+		if api.Expired(expiry, now) || (!wildcard && api.IsTagged(msg)) || (nextHop != "" && nextHop != rpub) {
+			continue
+		}
 		if bytesRead+int64(len(msg)) >= maxBytes { // no room for next msg
 			events.Debug(node, "skipping messages after # results:", n)
 			if n == 0 {
@@ -576,11 +653,13 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 			streamID, totalChunks, channelName)
 	} else if err == nil {
 		events.Debug(node, "Update Server")
-		node.transactExec("UPDATE streams SET parts=$1,channel=$2 WHERE streamid==$4;",
+		node.transactExec("UPDATE streams SET parts=$1,channel=$2 WHERE streamid==$3;",
 			totalChunks, channelName, streamID)
 	} else {
 		return err
 	}
+	// drop chunks that arrived ahead of the header but don't fit it
+	node.transactExec("DELETE FROM chunks WHERE streamid==$1 AND chunknum>=$2;", streamID, totalChunks)
 	return nil
 }
 
@@ -588,21 +667,25 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error {
 	c := node.db()
 	defer closeDB(c)
-	sqlq := "SELECT chunknum FROM chunks WHERE streamid==$1 AND chunknum==$2;"
+	var totalChunks uint32
+	sqlq := "SELECT parts FROM streams WHERE streamid==$1;"
+	events.Info(node, sqlq, streamID)
+	if err := c.QueryRow(sqlq, streamID).Scan(&totalChunks); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	sqlq = "SELECT chunknum FROM chunks WHERE streamid==$1 AND chunknum==$2;"
 	events.Info(node, sqlq, streamID, chunkNum)
-	r := c.QueryRow(sqlq, streamID, chunkNum)
 	var n int64
-	if err := r.Scan(&n); err == sql.ErrNoRows {
-		events.Debug(node, "New Chunk")
-		node.transactExec("INSERT INTO chunks (streamid,chunknum,data) VALUES( $1, $2, $3 );",
-			streamID, chunkNum, data)
-	} else if err == nil {
-		events.Debug(node, "Update Chunk")
-		node.transactExec("UPDATE chunks SET data=$1 WHERE streamid==$2 AND chunknum==$3;",
-			data, streamID, chunkNum)
-	} else {
+	err := c.QueryRow(sqlq, streamID, chunkNum).Scan(&n)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err = chunking.CheckChunkNum(chunkNum, totalChunks, err == nil); err != nil {
 		return err
 	}
+	events.Debug(node, "New Chunk")
+	node.transactExec("INSERT INTO chunks (streamid,chunknum,data) VALUES( $1, $2, $3 );",
+		streamID, chunkNum, data)
 	return nil
 }
 
@@ -633,6 +716,14 @@ func (node *Node) qlGetStreams() ([]api.StreamHeader, error) {
 	return streams, nil
 }
 
+func (node *Node) streamCount() (int, error) {
+	c := node.db()
+	defer closeDB(c)
+	var count int
+	err := c.QueryRow("SELECT count() FROM streams;").Scan(&count)
+	return count, err
+}
+
 func (node *Node) qlGetChunkCount(streamID uint32) (uint64, error) {
 	c := node.db()
 	defer closeDB(c)
@@ -647,6 +738,14 @@ func (node *Node) qlGetChunkCount(streamID uint32) (uint64, error) {
 	return uint64(count), nil
 }
 
+func (node *Node) outboxCount() (int, error) {
+	c := node.db()
+	defer closeDB(c)
+	var count int
+	err := c.QueryRow("SELECT count() FROM outbox;").Scan(&count)
+	return count, err
+}
+
 func (node *Node) qlGetChunks(streamID uint32) ([]api.Chunk, error) {
 	c := node.db()
 	defer closeDB(c)
@@ -668,13 +767,168 @@ func (node *Node) qlGetChunks(streamID uint32) ([]api.Chunk, error) {
 	return chunks, nil
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than their channel's retention, or maxAgeSeconds seconds
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	ts := time.Now().UnixNano()
-	ts = ts - (maxAgeSeconds * 1000000000)
-	sql := "DELETE FROM outbox WHERE timestamp < ($1);"
-	events.Info(node, "Flushed Database (seconds): ", maxAgeSeconds)
-	node.transactExec(sql, ts)
+	events.Info(node, "Flushing Database (seconds): ", maxAgeSeconds)
+	nodes.FlushOutbox(node, maxAgeSeconds)
+}
+
+func (node *Node) qlGetOutbox(channelNames ...string) ([]api.OutboxEntry, error) {
+	c := node.db()
+	defer closeDB(c)
+	r, err := c.Query("SELECT channel, msg, timestamp, expiry FROM outbox ORDER BY timestamp ASC;")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var entries []api.OutboxEntry
+	for r.Next() {
+		var channel sql.NullString
+		var msg []byte
+		var ts, expiry int64
+		if err := r.Scan(&channel, &msg, &ts, &expiry); err != nil {
+			return nil, err
+		}
+		if api.MatchChannel(channel.String, channelNames) {
+			entries = append(entries, api.OutboxEntry{Channel: channel.String, Size: int64(len(msg)), Timestamp: ts, Expiry: expiry})
+		}
+	}
+	return entries, r.Err()
+}
+
+func (node *Node) qlDeleteOutbox(cutoff int64, channelNames ...string) (int64, error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	c := node.db()
+	defer closeDB(c)
+	tx, err := c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	exec := func(sqlq string, args ...interface{}) error {
+		res, err := tx.Exec(sqlq, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		deleted += n
+		return err
+	}
+	if len(channelNames) == 0 {
+		err = exec("DELETE FROM outbox WHERE timestamp < $1;", cutoff)
+	}
+	for _, channelName := range channelNames {
+		if err = exec("DELETE FROM outbox WHERE channel==$1 AND timestamp < $2;", channelName, cutoff); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// inboxTransact - runs fn in a transaction, rolled back if fn fails
+func (node *Node) inboxTransact(fn func(tx *sql.Tx) error) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	c := node.db()
+	defer closeDB(c)
+	tx, err := c.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (node *Node) inboxAppend(msg api.InboxMsg) error {
+	msg.ID = node.inboxIDs.Next()
+	return node.inboxTransact(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO inbox(msgid,name,ischan,content,received,attempts,lease) VALUES($1,$2,$3,$4,$5,$6,$7);",
+			msg.ID, msg.Name, msg.IsChan, msg.Content, msg.Received, msg.Attempts, msg.Lease)
+		return err
+	})
+}
+
+func (node *Node) qlFetchInbox(max, now, lease int64) ([]api.InboxMsg, error) {
+	var msgs []api.InboxMsg
+	err := node.inboxTransact(func(tx *sql.Tx) error {
+		r, err := tx.Query("SELECT msgid,name,ischan,content,received,attempts,lease FROM inbox WHERE lease <= $1 ORDER BY msgid ASC;", now)
+		if err != nil {
+			return err
+		}
+		for r.Next() && (max <= 0 || int64(len(msgs)) < max) {
+			var m api.InboxMsg
+			if err := r.Scan(&m.ID, &m.Name, &m.IsChan, &m.Content, &m.Received, &m.Attempts, &m.Lease); err != nil {
+				r.Close()
+				return err
+			}
+			msgs = append(msgs, m)
+		}
+		r.Close()
+		for i := range msgs {
+			msgs[i].Lease = lease
+			msgs[i].Attempts++
+			if _, err := tx.Exec("UPDATE inbox SET lease=$1, attempts=$2 WHERE msgid==$3;", lease, msgs[i].Attempts, msgs[i].ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (node *Node) qlAckInbox(ids ...int64) error {
+	return node.inboxTransact(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.Exec("DELETE FROM inbox WHERE msgid==$1;", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (node *Node) qlNackInbox(ids ...int64) error {
+	return node.inboxTransact(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.Exec("UPDATE inbox SET lease=int64(0) WHERE msgid==$1;", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (node *Node) deleteExpired(now int64) (int64, error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	c := node.db()
+	defer closeDB(c)
+	tx, err := c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM outbox WHERE expiry > 0 AND expiry <= $1;", now)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return deleted, tx.Commit()
 }
 
 // BootstrapDB - Initialize or open a database file
@@ -722,12 +976,41 @@ func (node *Node) BootstrapDB(database string) func() *sql.DB {
 		CREATE TABLE IF NOT EXISTS outbox (
 			channel		string	DEFAULT "",
 			msg			blob	NOT NULL,
-			timestamp	int64	NOT NULL
+			timestamp	int64	NOT NULL,
+			expiry		int64	DEFAULT 0,
+			nexthop		string	DEFAULT ""
 		);
 	`)
 	node.transactExec(`
 			CREATE INDEX IF NOT EXISTS outboxID ON outbox (timestamp);
 	`)
+	node.transactExec(`
+		CREATE TABLE IF NOT EXISTS inbox (
+			msgid		int64	NOT NULL,
+			name		string	NOT NULL,
+			ischan		bool	NOT NULL,
+			content		blob,
+			received	int64	NOT NULL,
+			attempts	int64	NOT NULL,
+			lease		int64	NOT NULL
+		);
+	`)
+	// databases created before message expiry was added lack the expiry column
+	oc := node.db()
+	if rows, err := oc.Query("SELECT expiry FROM outbox;"); err != nil {
+		node.transactExec("ALTER TABLE outbox ADD expiry int64;")
+		node.transactExec("UPDATE outbox SET expiry = int64(0) WHERE expiry IS NULL;")
+	} else {
+		rows.Close()
+	}
+	// and those created before onion layers were held for their next relay lack the nexthop column
+	if rows, err := oc.Query("SELECT nexthop FROM outbox;"); err != nil {
+		node.transactExec("ALTER TABLE outbox ADD nexthop string;")
+		node.transactExec(`UPDATE outbox SET nexthop = "" WHERE nexthop IS NULL;`)
+	} else {
+		rows.Close()
+	}
+	closeDB(oc)
 
 	node.transactExec(`
 		CREATE TABLE IF NOT EXISTS peers (
@@ -735,9 +1018,18 @@ func (node *Node) BootstrapDB(database string) func() *sql.DB {
 			uri			string	NOT NULL,
 			enabled		bool	NOT NULL,
 			peergroup   string  NOT NULL,
-			pubkey	string	DEFAULT NULL
+			pubkey	string	DEFAULT NULL,
+			fingerprint	string	DEFAULT NULL
 		);
 	`)
+	// databases created before peer identities were added lack the fingerprint column
+	mc := node.db()
+	if rows, err := mc.Query("SELECT fingerprint FROM peers;"); err != nil {
+		node.transactExec("ALTER TABLE peers ADD fingerprint string;")
+	} else {
+		rows.Close()
+	}
+	closeDB(mc)
 
 	node.transactExec(`
 		CREATE TABLE IF NOT EXISTS profiles (
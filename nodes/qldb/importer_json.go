@@ -57,6 +57,16 @@ func (node *Node) Import(jsonConfig []byte) error {
 		if err := node.AddPeer(nj.Peers[i].Name, nj.Peers[i].Enabled, nj.Peers[i].URI); err != nil {
 			return err
 		}
+		if nj.Peers[i].Pubkey != "" || nj.Peers[i].Fingerprint != "" {
+			if err := node.SetPeerIdentity(nj.Peers[i].Name, nj.Peers[i].Pubkey, nj.Peers[i].Fingerprint); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < len(nj.Config); i++ {
+		if err := node.SetConfig(nj.Config[i].Name, nj.Config[i].Value); err != nil {
+			return err
+		}
 	}
 	for i := 0; i < len(nj.Profiles); i++ {
 		cp := new(api.ProfilePriv)
@@ -139,8 +149,14 @@ func (node *Node) Export() ([]byte, error) {
 		nj.Peers[i].Name = v.Name
 		nj.Peers[i].Enabled = v.Enabled
 		nj.Peers[i].URI = v.URI
+		nj.Peers[i].Pubkey = v.Pubkey
+		nj.Peers[i].Fingerprint = v.Fingerprint
 		i++
 	}
+	nj.Config, err = node.qlGetConfigs()
+	if err != nil {
+		return nil, err
+	}
 	nj.Router = node.router
 	nj.Policies = node.policies
 	return json.MarshalIndent(nj, "", "    ")
@@ -20,6 +20,9 @@ import (
 // OutBufferSize - channel size for the node.Out() channel
 var OutBufferSize = 128
 
+// EventBufferSize - channel size for the node.Events() channel
+var EventBufferSize = 128
+
 // Node : defines an instance of the API with a ql-DB backed Node
 type Node struct {
 	contentKey  bc.KeyPair
@@ -34,9 +37,15 @@ type Node struct {
 	isRunning uint32
 
 	// external data members
-	in     chan api.Msg
-	out    chan api.Msg
-	events chan api.Event
+	in           chan api.Msg
+	out          chan api.Msg
+	bus          *api.EventBus
+	msgBus       *api.MsgBus
+	peerVersions *api.PeerVersions
+	events       *api.Subscription
+
+	janitor  *nodes.Janitor
+	inboxIDs nodes.InboxIDs
 }
 
 // New : creates a new instance of API
@@ -55,7 +64,10 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 	// setup chans
 	node.in = make(chan api.Msg)
 	node.out = make(chan api.Msg, OutBufferSize)
-	node.events = make(chan api.Event)
+	node.bus = api.NewEventBus()
+	node.msgBus = api.NewMsgBus()
+	node.peerVersions = api.NewPeerVersions()
+	node.events = node.bus.Subscribe(EventBufferSize, api.EventFilter{})
 
 	// setup default router
 	node.router = router.NewDefaultRouter()
@@ -109,8 +121,23 @@ func (node *Node) Out() chan api.Msg {
 }
 
 // Events : Returns the Events channel of this node
-func (node *Node) Events() chan api.Event {
-	return node.events
+func (node *Node) Events() <-chan api.Event {
+	return node.events.Events()
+}
+
+// EventBus : Returns the bus this node publishes its events on
+func (node *Node) EventBus() *api.EventBus {
+	return node.bus
+}
+
+// MsgBus : Returns the bus this node delivers received messages on
+func (node *Node) MsgBus() *api.MsgBus {
+	return node.msgBus
+}
+
+// PeerVersions : Returns the protocol versions this node's peers reported
+func (node *Node) PeerVersions() *api.PeerVersions {
+	return node.peerVersions
 }
 
 // RPC set to default handlers
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/awgh/bencrypt/bc"
@@ -12,6 +13,7 @@ import (
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // CID : Return content key
@@ -170,6 +172,15 @@ func (node *Node) LoadProfile(name string) (bc.PubKey, error) {
 	return node.contentKey.GetPubKey(), nil
 }
 
+// privProfile : Internal call to load secret key only for decryption operation
+func (node *Node) privProfile(name string) (bc.KeyPair, error) {
+	p, ok := node.profiles[name]
+	if !ok || p.Privkey == nil {
+		return nil, errors.New("No matching profile key found")
+	}
+	return p.Privkey, nil
+}
+
 // GetPeer : Retrieve a peer from this node's database
 func (node *Node) GetPeer(name string) (*api.Peer, error) {
 	peer, ok := node.peers[name]
@@ -180,6 +191,9 @@ func (node *Node) GetPeer(name string) (*api.Peer, error) {
 	p.Name = name
 	p.Enabled = peer.Enabled
 	p.URI = peer.URI
+	p.Group = peer.Group
+	p.Pubkey = peer.Pubkey
+	p.Fingerprint = peer.Fingerprint
 	return p, nil
 }
 
@@ -193,7 +207,7 @@ func (node *Node) GetPeers(group ...string) ([]api.Peer, error) {
 	var peers []api.Peer
 	for _, v := range node.peers {
 		if v.Group == groupName {
-			peers = append(peers, api.Peer{Name: v.Name, Enabled: v.Enabled, URI: v.URI, Group: groupName})
+			peers = append(peers, *v)
 		}
 	}
 	return peers, nil
@@ -211,10 +225,25 @@ func (node *Node) AddPeer(name string, enabled bool, uri string, group ...string
 	peer.Enabled = enabled
 	peer.URI = uri
 	peer.Group = groupName
+	if old, ok := node.peers[name]; ok { // keep pinned identity on update
+		peer.Pubkey = old.Pubkey
+		peer.Fingerprint = old.Fingerprint
+	}
 	node.peers[name] = peer
 	return nil
 }
 
+// SetPeerIdentity : Pin the routing pubkey and/or certificate fingerprint a peer must present
+func (node *Node) SetPeerIdentity(name string, pubkey string, fingerprint string) error {
+	peer, ok := node.peers[name]
+	if !ok {
+		return errors.New("Peer not found")
+	}
+	peer.Pubkey = pubkey
+	peer.Fingerprint = fingerprint
+	return nil
+}
+
 // DeletePeer : Remove a peer from this node's database
 func (node *Node) DeletePeer(name string) error {
 	if _, ok := node.peers[name]; !ok {
@@ -224,6 +253,69 @@ func (node *Node) DeletePeer(name string) error {
 	return nil
 }
 
+// GetConfig : Retrieve a node configuration value, "" if it is not set
+func (node *Node) GetConfig(name string) (string, error) {
+	if api.IsReservedConfig(name) {
+		return "", api.ErrReservedConfig
+	}
+	node.configMux.RLock()
+	defer node.configMux.RUnlock()
+	return node.config[name], nil
+}
+
+// SetConfig : Set a node configuration value, "" removes it
+func (node *Node) SetConfig(name string, value string) error {
+	if api.IsReservedConfig(name) {
+		return api.ErrReservedConfig
+	}
+	node.configMux.Lock()
+	defer node.configMux.Unlock()
+	if value == "" {
+		delete(node.config, name)
+	} else {
+		node.config[name] = value
+	}
+	return nil
+}
+
+// GetHealth : Report the status of this node, its policies and their transports
+func (node *Node) GetHealth() (api.Health, error) {
+	return nodes.Health(node), nil
+}
+
+// GetOutbox : List the messages queued for the given channels, or every channel if none are given
+func (node *Node) GetOutbox(channelNames ...string) ([]api.OutboxEntry, error) {
+	return node.outbox.List(channelNames...), nil
+}
+
+// GetOutboxCounts : Count the messages and bytes queued for each channel
+func (node *Node) GetOutboxCounts() ([]api.OutboxCount, error) {
+	return api.CountOutbox(node.outbox.List()), nil
+}
+
+// DeleteOutbox : Remove messages older than maxAgeSeconds queued for the given channels, or every channel if none are given
+func (node *Node) DeleteOutbox(maxAgeSeconds int64, channelNames ...string) (int64, error) {
+	return node.outbox.Delete(api.OutboxCutoff(maxAgeSeconds), channelNames...), nil
+}
+
+// FetchInbox : Lease up to max received messages for leaseSeconds, oldest first
+func (node *Node) FetchInbox(max int64, leaseSeconds int64) ([]api.InboxMsg, error) {
+	now := time.Now().UnixNano()
+	return node.inbox.Fetch(max, now, api.InboxLease(now, leaseSeconds)), nil
+}
+
+// AckInbox : Remove fetched messages from the inbox
+func (node *Node) AckInbox(ids ...int64) error {
+	node.inbox.Ack(ids...)
+	return nil
+}
+
+// NackInbox : End the lease of fetched messages, so they are fetched again
+func (node *Node) NackInbox(ids ...int64) error {
+	node.inbox.Nack(ids...)
+	return nil
+}
+
 // Send : Transmit a message to a single key
 func (node *Node) Send(contactName string, data []byte, pubkey ...bc.PubKey) error {
 	var destkey bc.PubKey
@@ -270,45 +362,52 @@ func (node *Node) SendChannel(channelName string, data []byte, pubkey ...bc.PubK
 // SendMsg : Transmits a message
 func (node *Node) SendMsg(msg api.Msg) error {
 	// determine if we need to chunk
-	chunkSize := chunking.ChunkSize(node)                               // finds the minimum transport byte limit
-	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize { // we need to chunk
+	chunkSize := chunking.ChunkSize(node) - api.OnionOverhead(len(msg.Route)) // finds the minimum transport byte limit
+	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize {       // we need to chunk
 		if msg.Chunked { // we're already chunked, freak out!
 			return errors.New("Chunked message needs to be chunked, bailing out")
 		}
 		return chunking.SendChunked(node, chunkSize, msg)
 	}
 
-	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
-	if err != nil {
+	if err := nodes.CompressMsg(node, &msg); err != nil {
 		return err
 	}
-
-	flags := uint8(0)
-	if msg.IsChan {
-		flags |= api.ChannelFlag
+	if err := nodes.AddHopLimit(node, &msg); err != nil {
+		return err
 	}
-	if msg.Chunked {
-		flags |= api.ChunkedFlag
+	if err := nodes.AddExpiry(node, &msg); err != nil {
+		return err
 	}
-	if msg.StreamHeader {
-		flags |= api.StreamHeaderFlag
+	if err := nodes.TagChannel(node, &msg); err != nil {
+		return err
 	}
-	rxsum := []byte{flags} // prepend flags byte
-
-	if msg.IsChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(msg.Name))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(msg.Name)...)
+	if err := nodes.PadMsg(node, &msg, chunkSize); err != nil { // last, it leaves room for the header
+		return err
 	}
+	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
+	if err != nil {
+		return err
+	}
+	rxsum := api.EncodeMsgHeader(&msg) // flags, extensions and channel name
 	data = append(rxsum, data...)
+	if len(msg.Route) > 0 { // the channel name is only visible to the last relay
+		if data, err = api.WrapOnion(node.routingKey, data, msg.Route); err != nil {
+			return err
+		}
+		msg.IsChan = false
+		msg.NextHop = msg.Route[0].ToB64() // only the first relay picks it up
+	}
+	if mixed, err := nodes.MixLocal(node, &msg, data); mixed || err != nil {
+		return err
+	}
 	ts := time.Now().UnixNano()
 
 	m := new(outboxMsg)
-	if msg.IsChan {
-		m.channel = msg.Name
-	}
+	m.channel = nodes.OutboxChannel(&msg)
+	m.nextHop = msg.NextHop
 	m.timeStamp = ts
+	m.expiry = nodes.OutboxExpiry(node, m.channel, &msg, ts)
 	m.msg = data
 	node.outbox.Append(m)
 	return nil
@@ -341,6 +440,9 @@ func (node *Node) Start() error {
 	}
 
 	node.setIsRunning(true)
+	nodes.RegisterOutboxMetric(node, node.outboxCount)
+	nodes.RegisterStreamsMetric(node, node.streamCount)
+	node.janitor = nodes.StartJanitor(node, node.deleteExpired)
 
 	// input loop
 	go func() {
@@ -375,13 +477,22 @@ func (node *Node) Start() error {
 				// if chunks == total chunks, re-assemble Msg and call Handle
 				if uint32(count) == uint32(stream.NumChunks) {
 					buf := bytes.NewBuffer([]byte{})
+					missing := false
 					for i := uint32(0); i < stream.NumChunks; i++ {
 						chunk, ok := node.chunks[stream.StreamID][i]
 						if !ok {
-							events.Critical(node, "Chunk count miscalculated - code broken")
+							events.Error(node, "Dropping stream %x: chunk %d missing", stream.StreamID, i)
+							missing = true
+							break
 						}
 						buf.Write(chunk.Data)
 					}
+					if missing {
+						atomic.AddInt32(&node.streamsPending, -1)
+						node.streams[stream.StreamID] = nil
+						node.chunks[stream.StreamID] = make(map[uint32]*api.Chunk)
+						continue
+					}
 
 					var msg api.Msg
 					if len(stream.ChannelName) > 0 {
@@ -390,12 +501,15 @@ func (node *Node) Start() error {
 					}
 					msg.Content = buf
 
-					select {
-					case node.Out() <- msg:
+					if delivered, err := nodes.Deliver(node, msg, node.inboxAppend); err != nil {
+						events.Error(node, "Inbox: "+err.Error())
+					} else if delivered {
 						events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+						events.Emit(node, api.Info, api.StreamCompleted, api.StreamEvent{StreamID: stream.StreamID, NumChunks: stream.NumChunks, Channel: stream.ChannelName})
+						atomic.AddInt32(&node.streamsPending, -1)
 						node.streams[stream.StreamID] = nil
 						node.chunks[stream.StreamID] = make(map[uint32]*api.Chunk)
-					default:
+					} else {
 						events.Debug(node, "No message sent")
 					}
 				}
@@ -408,8 +522,13 @@ func (node *Node) Start() error {
 
 // Stop : sets the isRunning flag to false, indicating that all go routines should end
 func (node *Node) Stop() {
+	nodes.UnregisterOutboxMetric(node)
+	nodes.UnregisterStreamsMetric(node)
+	node.janitor.Stop()
+	node.janitor = nil
 	for _, policy := range node.policies {
 		policy.Stop()
 	}
+	nodes.FlushMix(node)
 	node.setIsRunning(false)
 }
@@ -0,0 +1,28 @@
+// +build !no_json
+
+package ram
+
+import (
+	"testing"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+)
+
+func Test_export_OutboxRetention(t *testing.T) {
+	src := New(new(ecc.KeyPair), new(ecc.KeyPair))
+	if err := src.SetConfig(api.ConfigOutboxRetention, "600"); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := src.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := New(new(ecc.KeyPair), new(ecc.KeyPair))
+	if err := dst.Import(exported); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := dst.GetConfig(api.ConfigOutboxRetention); err != nil || v != "600" {
+		t.Fatal("outbox retention not imported:", v, err)
+	}
+}
@@ -0,0 +1,79 @@
+package ram
+
+import (
+	"sync"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/nodes"
+)
+
+// Received Message Queue / Inbox
+
+type inboxQueue struct {
+	mux   sync.Mutex
+	inbox []*api.InboxMsg
+	ids   nodes.InboxIDs
+}
+
+// Append : Adds a received message to the queue
+func (q *inboxQueue) Append(msg api.InboxMsg) error {
+	msg.ID = q.ids.Next()
+	q.mux.Lock()
+	q.inbox = append(q.inbox, &msg)
+	q.mux.Unlock()
+	return nil
+}
+
+// Fetch : Leases up to max messages that are not leased at now, 0 for all of them, until lease
+func (q *inboxQueue) Fetch(max, now, lease int64) []api.InboxMsg {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var msgs []api.InboxMsg
+	for _, m := range q.inbox {
+		if max > 0 && int64(len(msgs)) >= max {
+			break
+		}
+		if !m.Leased(now) {
+			m.Lease = lease
+			m.Attempts++
+			msgs = append(msgs, *m)
+		}
+	}
+	return msgs
+}
+
+// Ack : Deletes the messages with the given IDs
+func (q *inboxQueue) Ack(ids ...int64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	kept := q.inbox[:0]
+	for _, m := range q.inbox {
+		if !hasID(ids, m.ID) {
+			kept = append(kept, m)
+		}
+	}
+	for i := len(kept); i < len(q.inbox); i++ {
+		q.inbox[i] = nil
+	}
+	q.inbox = kept
+}
+
+// Nack : Ends the lease of the messages with the given IDs
+func (q *inboxQueue) Nack(ids ...int64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for _, m := range q.inbox {
+		if hasID(ids, m.ID) {
+			m.Lease = 0
+		}
+	}
+}
+
+func hasID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // GetChannelPrivKey : Return the private key of a given channel
@@ -22,25 +25,10 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
-	flags := uint8(0)
-	if msg.IsChan {
-		flags |= api.ChannelFlag
-	}
-	if msg.Chunked {
-		flags |= api.ChunkedFlag
-	}
-	if msg.StreamHeader {
-		flags |= api.StreamHeaderFlag
-	}
-	rxsum := []byte{flags} // prepend flags byte
+	rxsum := api.EncodeMsgHeader(&msg) // flags, extensions and channel name
 	m := new(outboxMsg)
-	if msg.IsChan {
-		// prepend a uint16 of channel name length, little-endian
-		t := uint16(len(msg.Name))
-		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
-		rxsum = append(rxsum, []byte(msg.Name)...)
-		m.channel = msg.Name
-	}
+	m.channel = nodes.OutboxChannel(&msg)
+	m.nextHop = msg.NextHop
 	message := append(rxsum, msg.Content.Bytes()...)
 
 	/* todo: commented out, this is not what other nodes do
@@ -49,11 +37,17 @@ func (node *Node) Forward(msg api.Msg) error {
 	}
 	*/
 	m.timeStamp = time.Now().UnixNano()
+	m.expiry = nodes.OutboxExpiry(node, m.channel, &msg, m.timeStamp)
 	m.msg = message
 	node.outbox.Append(m)
 	return nil
 }
 
+// PeelOnion - Decrypt an onion layer addressed to this node's routing key
+func (node *Node) PeelOnion(msg api.Msg) (bool, []byte, error) {
+	return api.DecryptMessage(node.routingKey, msg.Content.Bytes())
+}
+
 // Handle - Decrypt and handle an encrypted message
 // 			returns TagOK, which is true if the message is intended for a key we have
 func (node *Node) Handle(msg api.Msg) (bool, error) {
@@ -68,15 +62,28 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 			return tagOK, errors.New("Cannot Handle message for Unknown Channel")
 		}
 		clearMsg = api.Msg{Name: msg.Name, IsChan: true, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = v.Privkey.DecryptMessage(msg.Content.Bytes())
+		tagOK, clear, err = api.DecryptMessage(v.Privkey, msg.Content.Bytes())
+	} else if len(msg.Name) > 0 {
+		clearMsg = api.Msg{Name: msg.Name, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
+		var key bc.KeyPair
+		if key, err = node.privProfile(msg.Name); err != nil {
+			return false, err
+		}
+		tagOK, clear, err = api.DecryptMessage(key, msg.Content.Bytes())
 	} else {
-		clearMsg = api.Msg{Name: "[content]", IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
-		tagOK, clear, err = node.contentKey.DecryptMessage(msg.Content.Bytes())
+		clearMsg = api.Msg{Name: api.ContentName, IsChan: false, Chunked: msg.Chunked, StreamHeader: msg.StreamHeader}
+		tagOK, clear, err = api.DecryptMessage(node.contentKey, msg.Content.Bytes())
 	}
 	// DecryptMessage will return !tagOK if the quick-check fails, which is common
 	if !tagOK || err != nil {
 		return tagOK, err
 	}
+	if clear, err = nodes.UnpadMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
+	if clear, err = nodes.DecompressMsg(msg, clear); err != nil {
+		return tagOK, err
+	}
 
 	clearMsg.Content = bytes.NewBuffer(clear)
 
@@ -89,12 +96,17 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 		return true, err
 	}
 
-	select {
-	case node.Out() <- clearMsg:
-		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
-	default:
+	delivered, err := nodes.Deliver(node, clearMsg, node.inboxAppend)
+	if err != nil {
+		events.Dropped(node, clearMsg, api.DropInbox)
+		return tagOK, err
+	} else if !delivered {
 		events.Debug(node, "No message sent")
+		events.Dropped(node, clearMsg, api.DropOutFull)
+		return tagOK, nil
 	}
+	events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+	events.Delivered(node, clearMsg)
 	return tagOK, nil
 }
 
@@ -104,13 +116,33 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 	stream.StreamID = streamID
 	stream.NumChunks = totalChunks
 	stream.ChannelName = channelName
+	if node.streams[streamID] == nil {
+		atomic.AddInt32(&node.streamsPending, 1)
+	}
 	node.streams[streamID] = stream
+	for chunkNum := range node.chunks[streamID] { // drop chunks that arrived ahead of the header but don't fit it
+		if chunkNum >= totalChunks {
+			delete(node.chunks[streamID], chunkNum)
+		}
+	}
 	node.debouncer.Trigger()
 	return nil
 }
 
+func (node *Node) streamCount() (int, error) {
+	return int(atomic.LoadInt32(&node.streamsPending)), nil
+}
+
 // AddChunk - adds a chunk of a partial message to internal storage
 func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error {
+	var totalChunks uint32
+	if stream := node.streams[streamID]; stream != nil {
+		totalChunks = stream.NumChunks
+	}
+	_, received := node.chunks[streamID][chunkNum]
+	if err := chunking.CheckChunkNum(chunkNum, totalChunks, received); err != nil {
+		return err
+	}
 	chunk := new(api.Chunk)
 	chunk.StreamID = streamID
 	chunk.ChunkNum = chunkNum
@@ -15,6 +15,8 @@ type outboxMsg struct {
 	channel   string
 	msg       []byte
 	timeStamp int64
+	expiry    int64
+	nextHop   string // routing key (b64) of the only peer an onion layer is released to
 }
 
 type outboxQueue struct {
@@ -30,49 +32,91 @@ func (o *outboxQueue) Append(msg *outboxMsg) {
 	o.mux.Unlock()
 }
 
+// Len : Returns the number of messages in the queue
+func (o *outboxQueue) Len() int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return len(o.outbox)
+}
+
 // MsgExists : Returns true iff a matching message is already in the outbound queue
 func (o *outboxQueue) MsgExists(channelName string, message []byte) bool {
 	o.mux.Lock()
+	defer o.mux.Unlock()
 	for _, mail := range o.outbox {
 		if mail.channel == channelName && bytes.Equal(mail.msg, message) {
 			return true // already have a copy... //todo: do we really need this check? or can it be more efficient?
 		}
 	}
-	o.mux.Unlock()
 	return false
 }
 
-// Flush : Deletes outbound messages older than maxAgeSeconds seconds
-func (o *outboxQueue) Flush(maxAgeSeconds int64) {
-	c := (time.Now().UnixNano()) - (maxAgeSeconds * 1000000000)
+// List : Describes the queued messages of the given channels, or of every channel if none are given
+func (o *outboxQueue) List(channelNames ...string) []api.OutboxEntry {
 	o.mux.Lock()
-	for index, mail := range o.outbox {
-		if mail.timeStamp < c {
-			if len(o.outbox) > index {
-				o.outbox = append(o.outbox[:index], o.outbox[index+1:]...)
-			} else {
-				o.outbox = []*outboxMsg{}
-			}
+	defer o.mux.Unlock()
+	var entries []api.OutboxEntry
+	for _, mail := range o.outbox {
+		if api.MatchChannel(mail.channel, channelNames) {
+			entries = append(entries, api.OutboxEntry{Channel: mail.channel, Size: int64(len(mail.msg)), Timestamp: mail.timeStamp, Expiry: mail.expiry})
 		}
 	}
-	o.mux.Unlock()
+	return entries
+}
+
+// Delete : Deletes the messages of the given channels, or of every channel if none are given,
+// that were queued before cutoff, returns how many were deleted
+func (o *outboxQueue) Delete(cutoff int64, channelNames ...string) int64 {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	kept := o.outbox[:0]
+	for _, mail := range o.outbox {
+		if mail.timeStamp >= cutoff || !api.MatchChannel(mail.channel, channelNames) {
+			kept = append(kept, mail)
+		}
+	}
+	deleted := int64(len(o.outbox) - len(kept))
+	for i := len(kept); i < len(o.outbox); i++ {
+		o.outbox[i] = nil // let the deleted messages be collected
+	}
+	o.outbox = kept
+	return deleted
+}
+
+// DeleteExpired : Deletes the messages that expired by now, returns how many were deleted
+func (o *outboxQueue) DeleteExpired(now int64) int64 {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	kept := o.outbox[:0]
+	for _, mail := range o.outbox {
+		if !api.Expired(mail.expiry, now) {
+			kept = append(kept, mail)
+		}
+	}
+	deleted := int64(len(o.outbox) - len(kept))
+	for i := len(kept); i < len(o.outbox); i++ {
+		o.outbox[i] = nil
+	}
+	o.outbox = kept
+	return deleted
 }
 
-// MsgsSince : Get messages after the given timestamp
-func (o *outboxQueue) MsgsSince(lastTime int64, maxBytes int64, channelNames ...string) ([][]byte, int64) {
+// MsgsSince : Get unexpired messages after the given timestamp, onion layers only if they are for the peer with routing key rpub
+func (o *outboxQueue) MsgsSince(lastTime int64, maxBytes int64, rpub string, channelNames ...string) ([][]byte, int64) {
 	var msgs [][]byte
 	retvalTime := lastTime
+	now := time.Now().UnixNano()
 	o.mux.Lock()
 	for _, mail := range o.outbox {
-		if lastTime < mail.timeStamp {
+		if lastTime < mail.timeStamp && !api.Expired(mail.expiry, now) && (mail.nextHop == "" || mail.nextHop == rpub) {
 			pickupMsg := false
-			if len(channelNames) > 0 {
+			if len(channelNames) > 0 && !api.IsTagged(mail.msg) {
 				for _, channelName := range channelNames {
 					if channelName == mail.channel {
 						pickupMsg = true
 					}
 				}
-			} else {
+			} else if len(channelNames) == 0 {
 				pickupMsg = true
 			}
 			if pickupMsg {
@@ -6,6 +6,7 @@ import (
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes"
 )
 
 // ID : Return routing key
@@ -14,12 +15,17 @@ func (node *Node) ID() (bc.PubKey, error) {
 }
 
 // Dropoff : Deliver a batch of  messages to a remote node
-func (node *Node) Dropoff(bundle api.Bundle) error {
+func (node *Node) Dropoff(bundle api.Bundle) (err error) {
+	defer func() {
+		if err != nil {
+			events.Rejected(node, bundle, err)
+		}
+	}()
 	events.Debug(node, "Dropoff called")
 	if len(bundle.Data) < 1 { // todo: correct min length
 		return errors.New("Dropoff called with no data")
 	}
-	tagOK, data, err := node.routingKey.DecryptMessage(bundle.Data)
+	tagOK, data, err := api.DecryptMessage(node.routingKey, bundle.Data)
 	if err != nil {
 		return err
 	} else if !tagOK {
@@ -32,7 +38,7 @@ func (node *Node) Dropoff(bundle api.Bundle) error {
 	}
 	for i := 0; i < len(*msgs); i++ {
 		if len((*msgs)[i]) < 16 { // aes.BlockSize == 16
-			continue // todo: remove padding before here?
+			continue // too short to be a message, bundle padding is ignored by BytesBytesFromBytes
 		}
 		err = node.router.Route(node, (*msgs)[i])
 		if err != nil {
@@ -51,13 +57,19 @@ func (node *Node) Pickup(rpub bc.PubKey, lastTime int64, maxBytes int64, channel
 	var retval api.Bundle
 	var msgs [][]byte
 
-	msgs, rvts := node.outbox.MsgsSince(lastTime, maxBytes, channelNames...)
+	msgs, rvts := node.outbox.MsgsSince(lastTime, maxBytes, rpub.ToB64(), channelNames...)
 	retval.Time = rvts
 
+	// strip or withhold extensions the peer did not report support for
+	msgs = api.DowngradeMessages(msgs, node.peerVersions.Get(rpub.ToB64()).Caps)
+
 	// transmit
 	if len(msgs) > 0 {
-		buf := api.BytesBytesToBytes(&msgs)
-		cipher, err := node.routingKey.EncryptMessage(*buf, rpub)
+		buf, err := nodes.PadBundle(node, *api.BytesBytesToBytes(&msgs), maxBytes)
+		if err != nil {
+			return retval, err
+		}
+		cipher, err := node.routingKey.EncryptMessage(buf, rpub)
 		if err != nil {
 			return retval, err
 		}
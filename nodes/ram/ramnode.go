@@ -1,6 +1,7 @@
 package ram
 
 import (
+	"sync"
 	"sync/atomic"
 
 	"github.com/awgh/bencrypt/ecc"
@@ -15,6 +16,9 @@ import (
 // OutBufferSize - size of the buffer in messages for the Out() channel
 var OutBufferSize = 128
 
+// EventBufferSize - size of the buffer in events for the Events() channel
+var EventBufferSize = 128
+
 // Node : defines an instance of the API with a ql-DB backed Node
 type Node struct {
 	contentKey bc.KeyPair
@@ -25,21 +29,30 @@ type Node struct {
 	isRunning uint32
 
 	// external data members
-	in     chan api.Msg
-	out    chan api.Msg
-	events chan api.Event
+	in           chan api.Msg
+	out          chan api.Msg
+	bus          *api.EventBus
+	msgBus       *api.MsgBus
+	peerVersions *api.PeerVersions
+	events       *api.Subscription
 
 	// db -> ram replacements
 	channels map[string]*api.ChannelPriv
 	config   map[string]string
 	contacts map[string]*api.Contact
 	outbox   outboxQueue
+	inbox    inboxQueue
 	peers    map[string]*api.Peer
 	profiles map[string]*api.ProfilePriv
 	streams  map[uint32]*api.StreamHeader
 	chunks   map[uint32]map[uint32]*api.Chunk
 
+	streamsPending int32 // streams not yet reassembled, accessed atomically for the metrics
+
+	configMux sync.RWMutex // config is read by concurrent Dropoff calls
+
 	debouncer *debouncer.Debouncer
+	janitor   *nodes.Janitor
 }
 
 // New : creates a new instance of API
@@ -75,7 +88,10 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 	// setup chans
 	node.in = make(chan api.Msg)
 	node.out = make(chan api.Msg, OutBufferSize)
-	node.events = make(chan api.Event)
+	node.bus = api.NewEventBus()
+	node.msgBus = api.NewMsgBus()
+	node.peerVersions = api.NewPeerVersions()
+	node.events = node.bus.Subscribe(EventBufferSize, api.EventFilter{})
 
 	// setup default router
 	node.router = router.NewDefaultRouter()
@@ -117,9 +133,21 @@ func (node *Node) SetRouter(router api.Router) {
 	node.router = router
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than their channel's retention, or maxAgeSeconds seconds
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	node.outbox.Flush(maxAgeSeconds)
+	nodes.FlushOutbox(node, maxAgeSeconds)
+}
+
+func (node *Node) outboxCount() (int, error) {
+	return node.outbox.Len(), nil
+}
+
+func (node *Node) deleteExpired(now int64) (int64, error) {
+	return node.outbox.DeleteExpired(now), nil
+}
+
+func (node *Node) inboxAppend(msg api.InboxMsg) error {
+	return node.inbox.Append(msg)
 }
 
 // Channels
@@ -135,8 +163,23 @@ func (node *Node) Out() chan api.Msg {
 }
 
 // Events : Returns the Events channel of this node
-func (node *Node) Events() chan api.Event {
-	return node.events
+func (node *Node) Events() <-chan api.Event {
+	return node.events.Events()
+}
+
+// EventBus : Returns the bus this node publishes its events on
+func (node *Node) EventBus() *api.EventBus {
+	return node.bus
+}
+
+// MsgBus : Returns the bus this node delivers received messages on
+func (node *Node) MsgBus() *api.MsgBus {
+	return node.msgBus
+}
+
+// PeerVersions : Returns the protocol versions this node's peers reported
+func (node *Node) PeerVersions() *api.PeerVersions {
+	return node.peerVersions
 }
 
 // RPC set to default handlers
@@ -4,9 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/stamp"
 )
 
 // PublicRPC : Entrypoint for RPC functions that are exposed to the public/Internet
@@ -56,8 +59,63 @@ func PublicRPC(transport api.Transport, node api.Node, call api.RemoteCall) (int
 		if !ok {
 			return nil, errors.New("Invalid argument")
 		}
+		if err := checkStamp(node, bundle); err != nil {
+			events.Warning(node, "Dropoff rejected: "+err.Error())
+			events.Rejected(node, bundle, err)
+			return nil, err
+		}
 		return nil, node.Dropoff(bundle)
 
+	case api.StampDifficulty:
+		d, err := StampDifficulty(node)
+		if err != nil {
+			return nil, err
+		}
+		return int64(d), nil
+
+	case api.Version:
+		// callers send their own version, capabilities and routing key, so Pickup can downgrade messages for them,
+		// the version is only recorded once the caller answers the challenge to that key in a second call,
+		// a challenge from the caller to our routing key is answered in the result
+		local := api.PeerVersion{Version: api.ProtocolVersion, Caps: api.LocalCapabilities}
+		result := api.VersionToArgs(local)
+		if len(call.Args) < 3 {
+			return result, nil
+		}
+		v, err := api.VersionFromArgs(call.Args)
+		if err != nil {
+			return nil, err
+		}
+		rpk, ok := call.Args[2].(bc.PubKey)
+		if !ok {
+			return nil, errors.New("Invalid argument 3")
+		}
+		if len(call.Args) >= 5 {
+			answer, ok := call.Args[4].([]byte)
+			if !ok {
+				return nil, errors.New("Invalid argument 5")
+			}
+			if !node.PeerVersions().Confirm(rpk.ToB64(), answer) {
+				events.Warning(node, "version challenge failed for "+rpk.ToB64())
+			}
+			return result, nil
+		}
+		answer := []byte{}
+		if len(call.Args) >= 4 {
+			c, ok := call.Args[3].([]byte)
+			if !ok {
+				return nil, errors.New("Invalid argument 4")
+			}
+			if answer, err = api.AnswerChallenge(node, c, local); err != nil {
+				return nil, err
+			}
+		}
+		challenge, err := node.PeerVersions().Challenge(rpk, v)
+		if err != nil {
+			return nil, err
+		}
+		return append(result, answer, challenge), nil
+
 	default:
 		return nil, fmt.Errorf("No such method: %d", call.Action)
 	}
@@ -65,6 +123,10 @@ func PublicRPC(transport api.Transport, node api.Node, call api.RemoteCall) (int
 
 // AdminRPC : Entrypoint for administrative RPC functions that should not be exposed to the Internet
 func AdminRPC(transport api.Transport, node api.Node, call api.RemoteCall) (interface{}, error) {
+	if err := authorize(transport, node, call); err != nil {
+		return nil, err
+	}
+
 	switch call.Action {
 
 	case api.CID:
@@ -303,6 +365,127 @@ func AdminRPC(transport api.Transport, node api.Node, call api.RemoteCall) (inte
 		}
 		return nil, node.DeletePeer(peerName)
 
+	case api.SetPeerIdentity:
+		if len(call.Args) < 3 {
+			return nil, errors.New("Invalid argument count")
+		}
+		peerName, ok := call.Args[0].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument 1")
+		}
+		pubkey, ok := call.Args[1].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument 2")
+		}
+		fingerprint, ok := call.Args[2].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument 3")
+		}
+		return nil, node.SetPeerIdentity(peerName, pubkey, fingerprint)
+
+	case api.GetConfig:
+		if len(call.Args) < 1 {
+			return nil, errors.New("Invalid argument count")
+		}
+		name, ok := call.Args[0].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument")
+		}
+		return node.GetConfig(name)
+
+	case api.SetConfig:
+		if len(call.Args) < 2 {
+			return nil, errors.New("Invalid argument count")
+		}
+		name, ok := call.Args[0].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument 1")
+		}
+		value, ok := call.Args[1].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument 2")
+		}
+		return nil, node.SetConfig(name, value)
+
+	case api.GetHealth:
+		h, err := node.GetHealth()
+		if err != nil {
+			return nil, err
+		}
+		return api.HealthToArgs(h), nil
+
+	case api.GetOutbox:
+		var channelNames []string
+		for _, v := range call.Args {
+			vs, ok := v.(string)
+			if !ok {
+				return nil, errors.New("Invalid argument")
+			}
+			channelNames = append(channelNames, vs)
+		}
+		entries, err := node.GetOutbox(channelNames...)
+		if err != nil {
+			return nil, err
+		}
+		return api.OutboxEntriesToArgs(entries), nil
+
+	case api.GetOutboxCounts:
+		counts, err := node.GetOutboxCounts()
+		if err != nil {
+			return nil, err
+		}
+		return api.OutboxCountsToArgs(counts), nil
+
+	case api.DeleteOutbox:
+		if len(call.Args) < 1 {
+			return nil, errors.New("Invalid argument count")
+		}
+		maxAge, ok := call.Args[0].(int64)
+		if !ok {
+			return nil, errors.New("Invalid argument 1")
+		}
+		var channelNames []string
+		for _, v := range call.Args[1:] {
+			vs, ok := v.(string)
+			if !ok {
+				return nil, errors.New("Invalid argument 2+")
+			}
+			channelNames = append(channelNames, vs)
+		}
+		return node.DeleteOutbox(maxAge, channelNames...)
+
+	case api.FetchInbox:
+		if len(call.Args) < 2 {
+			return nil, errors.New("Invalid argument count")
+		}
+		max, ok := call.Args[0].(int64)
+		if !ok {
+			return nil, errors.New("Invalid argument 1")
+		}
+		lease, ok := call.Args[1].(int64)
+		if !ok {
+			return nil, errors.New("Invalid argument 2")
+		}
+		msgs, err := node.FetchInbox(max, lease)
+		if err != nil {
+			return nil, err
+		}
+		return api.InboxMsgsToArgs(msgs), nil
+
+	case api.AckInbox, api.NackInbox:
+		ids := make([]int64, 0, len(call.Args))
+		for _, v := range call.Args {
+			id, ok := v.(int64)
+			if !ok {
+				return nil, errors.New("Invalid argument")
+			}
+			ids = append(ids, id)
+		}
+		if call.Action == api.AckInbox {
+			return nil, node.AckInbox(ids...)
+		}
+		return nil, node.NackInbox(ids...)
+
 	case api.Send:
 		if len(call.Args) < 2 {
 			return nil, errors.New("Invalid argument count")
@@ -349,3 +532,82 @@ func AdminRPC(transport api.Transport, node api.Node, call api.RemoteCall) (inte
 		return node.PublicRPC(transport, call)
 	}
 }
+
+// StampDifficulty : returns the proof-of-work difficulty a node requires for Dropoff, 0 if stamps are disabled
+func StampDifficulty(node api.Node) (int, error) {
+	v, err := node.GetConfig(api.ConfigStampDifficulty)
+	if err != nil || v == "" {
+		return 0, err
+	}
+	d, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New("Invalid " + api.ConfigStampDifficulty + " config: " + v)
+	}
+	return d, nil
+}
+
+// AddHopLimit : adds the hop limit set by ConfigHopLimit to a message this node sends, unless it already has one
+func AddHopLimit(node api.Node, msg *api.Msg) error {
+	if _, ok := msg.HopLimit(); ok {
+		return nil
+	}
+	v, err := node.GetConfig(api.ConfigHopLimit)
+	if err != nil || v == "" {
+		return err
+	}
+	hops, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return errors.New("Invalid " + api.ConfigHopLimit + " config: " + v)
+	}
+	if hops > 0 {
+		msg.SetExtension(api.ExtHopLimit, []byte{byte(hops)})
+	}
+	return nil
+}
+
+// seenStamps : the Dropoff stamps accepted in this process, a stamp is bound to the routing key of the node that checks it,
+// so the nodes of one process do not share entries
+var seenStamps = stamp.NewCache()
+
+// checkStamp : verifies the proof-of-work stamp on a bundle received through Dropoff
+func checkStamp(node api.Node, bundle api.Bundle) error {
+	d, err := StampDifficulty(node)
+	if err != nil || d <= 0 {
+		return err
+	}
+	rpub, err := node.ID()
+	if err != nil {
+		return err
+	}
+	return seenStamps.Verify(bundle.Stamp, rpub.ToB64(), bundle.Data, d, time.Now())
+}
+
+// authorize : checks the caller's credential against the transport's Authorizer, if it has one
+func authorize(transport api.Transport, node api.Node, call api.RemoteCall) error {
+	t, ok := transport.(api.Authorizing)
+	if !ok {
+		return nil
+	}
+	auth := t.Authorizer()
+	if auth == nil {
+		return nil
+	}
+	role, err := auth.Authorize(call.Credential, call.Action)
+	if err != nil {
+		events.Warning(node, fmt.Sprintf("AdminRPC denied: action %s, role %q, credential %s", call.Action, role, redact(call.Credential)))
+		return err
+	}
+	events.Info(node, fmt.Sprintf("AdminRPC allowed: action %s, role %q", call.Action, role))
+	return nil
+}
+
+// redact : shortens a credential for logging, so that audit events do not leak tokens
+func redact(credential string) string {
+	if credential == "" {
+		return "(none)"
+	}
+	if len(credential) > 8 {
+		return credential[:8] + "..."
+	}
+	return "..."
+}
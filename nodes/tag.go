@@ -0,0 +1,27 @@
+package nodes
+
+import (
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// TagChannel : replaces the channel name of a message this node sends with its ChannelTag, if ConfigChannelTags is set,
+// the tag is keyed with the channel private key, so messages to channels the node is not a member of keep their name
+func TagChannel(node api.Node, msg *api.Msg) error {
+	if !msg.IsChan || msg.Tagged {
+		return nil
+	}
+	v, err := node.GetConfig(api.ConfigChannelTags)
+	if err != nil || v != "true" {
+		return err
+	}
+	privkey, err := node.GetChannelPrivKey(msg.Name)
+	if err != nil || privkey == "" {
+		return nil // not a member
+	}
+	msg.Channel = msg.Name
+	msg.Name = api.ChannelTag(privkey, time.Now())
+	msg.Tagged = true
+	return nil
+}
@@ -1,11 +1,18 @@
 package policy
 
 import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
+	"github.com/awgh/ratnet/api/stamp"
 )
 
 var (
@@ -30,8 +37,151 @@ func init() {
 	peerTable = make(map[string]*api.PeerInfo)
 }
 
+// ErrPollFailed : recorded for a poll that was not happy but returned no error
+var ErrPollFailed = errors.New("Poll failed")
+
+// PollError : returns the error a poll failed with, ErrPollFailed if it was not happy and returned none
+func PollError(happy bool, err error) error {
+	if err == nil && !happy {
+		return ErrPollFailed
+	}
+	return err
+}
+
+// PollPeer does a Push/Pull with a configured Peer, refusing it if it does not present the identity pinned in the Peer record
+func PollPeer(transport api.Transport, node api.Node, peer api.Peer, pubsrv bc.PubKey) (bool, error) {
+	if pinner, ok := transport.(api.CertificatePinning); ok {
+		pinner.PinCertificate(peer.URI, peer.Fingerprint)
+	} else if peer.Fingerprint != "" {
+		events.Error(node, "transport "+transport.Name()+" cannot verify the certificate pinned for peer "+peer.Name)
+		return pollEvent(node, peer.URI, time.Now(), false, api.ErrPeerIdentity)
+	}
+	start := time.Now()
+	happy, err := pollServer(transport, node, peer.URI, pubsrv, peer.Pubkey)
+	return pollEvent(node, peer.URI, start, happy, err)
+}
+
 // PollServer does a Push/Pull between a local and remote Node
 func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.PubKey) (bool, error) {
+	start := time.Now()
+	happy, err := pollServer(transport, node, host, pubsrv, "")
+	return pollEvent(node, host, start, happy, err)
+}
+
+// pollEvent - emits PeerPollSucceeded or PeerPollFailed, records the poll metrics, and passes the result of a poll through
+func pollEvent(node api.Node, host string, start time.Time, happy bool, err error) (bool, error) {
+	result := "ok"
+	if !happy {
+		result = "error"
+	}
+	metrics.Default.Add(metrics.Polls, 1, "result", result)
+	metrics.Default.Observe(metrics.PollSeconds, time.Since(start).Seconds())
+	if happy {
+		events.Emit(node, api.Info, api.PeerPollSucceeded, api.PollEvent{Host: host})
+	} else {
+		events.Emit(node, api.Warning, api.PeerPollFailed, api.PollEvent{Host: host, Err: err})
+	}
+	return happy, err
+}
+
+// nodeLabel - the "node" label of per-node metrics, the node's routing key
+func nodeLabel(node api.Node) string {
+	if id, err := node.ID(); err == nil && id != nil {
+		return id.ToB64()
+	}
+	return ""
+}
+
+// StampTimeout : how long a poll spends minting a stamp before it gives up
+var StampTimeout = 30 * time.Second
+
+// maxStampDifficulty : the highest stamp difficulty the node mints for, from ConfigMaxStampDifficulty
+func maxStampDifficulty(node api.Node) (int, error) {
+	v, err := node.GetConfig(api.ConfigMaxStampDifficulty)
+	if err != nil || v == "" {
+		return api.DefaultMaxStampDifficulty, err
+	}
+	d, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New("Invalid " + api.ConfigMaxStampDifficulty + " config: " + v)
+	}
+	return d, nil
+}
+
+// stampBundle : adds a proof-of-work stamp to a bundle for Dropoff if the remote node requires one
+func stampBundle(transport api.Transport, node api.Node, host string, peer *api.PeerInfo, bundle *api.Bundle) error {
+	if !peer.StampChecked {
+		peer.StampDifficulty = 0
+		d, err := transport.RPC(host, api.StampDifficulty)
+		if err != nil {
+			// older nodes do not have this method, and do not check stamps either
+			events.Debug(node, "stamp difficulty not available from "+host+": "+err.Error())
+		} else if di, ok := d.(int64); ok {
+			peer.StampDifficulty = int(di)
+		}
+		peer.StampChecked = true
+	}
+	if peer.StampDifficulty <= 0 {
+		return nil
+	}
+	limit, err := maxStampDifficulty(node)
+	if err != nil {
+		return err
+	}
+	if peer.StampDifficulty > limit {
+		return errors.New("Stamp difficulty " + strconv.Itoa(peer.StampDifficulty) + " asked by " + host + " is above " + strconv.Itoa(limit))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), StampTimeout)
+	defer cancel()
+	s, err := stamp.MintContext(ctx, peer.RoutingPub.ToB64(), bundle.Data, peer.StampDifficulty, time.Now())
+	if err != nil {
+		return err
+	}
+	bundle.Stamp = s
+	return nil
+}
+
+// exchangeVersion : sends our protocol version and capabilities to the remote node and records its own,
+// the remote's version is only recorded for its routing key if the key is pinned or the remote answers our challenge to it,
+// and we answer the remote's challenge so it records ours,
+// older nodes do not have the Version action and are treated as version 0 with no capabilities
+func exchangeVersion(transport api.Transport, node api.Node, host string, peer *api.PeerInfo, pubsrv bc.PubKey, pinned bool) {
+	var v api.PeerVersion
+	authentic := false
+	local := api.PeerVersion{Version: api.ProtocolVersion, Caps: api.LocalCapabilities}
+	token, challenge, err := api.NewVersionChallenge(peer.RoutingPub)
+	if err != nil {
+		events.Error(node, "version challenge error: "+err.Error())
+		return
+	}
+	res, err := transport.RPC(host, api.Version, append(api.VersionToArgs(local), pubsrv, challenge)...)
+	if err != nil {
+		events.Debug(node, "version not available from "+host+": "+err.Error())
+	} else if args, ok := res.([]interface{}); ok {
+		if v, err = api.VersionFromArgs(args); err != nil {
+			events.Warning(node, "bad version from "+host+": "+err.Error())
+		} else if len(args) >= 4 {
+			answer, _ := args[2].([]byte)
+			authentic = hmac.Equal(answer, api.ChallengeAnswer(token, v))
+			if c, ok := args[3].([]byte); ok {
+				if reply, err := api.AnswerChallenge(node, c, local); err != nil {
+					events.Warning(node, "bad version challenge from "+host+": "+err.Error())
+				} else if _, err := transport.RPC(host, api.Version, append(api.VersionToArgs(local), pubsrv, []byte{}, reply)...); err != nil {
+					events.Debug(node, "version answer not delivered to "+host+": "+err.Error())
+				}
+			}
+		}
+	}
+	peer.Version = v
+	peer.VersionChecked = true
+	if pinned || authentic {
+		node.PeerVersions().Set(peer.RoutingPub.ToB64(), v)
+	} else if v.Version > 0 {
+		events.Warning(node, "version from "+host+" not recorded, it did not prove it holds routing key "+peer.RoutingPub.ToB64())
+	}
+}
+
+func pollServer(transport api.Transport, node api.Node, host string, pubsrv bc.PubKey, expectedPub string) (bool, error) {
 	// make PeerInfo for this host if doesn't exist
 	if _, ok := readPeerTable(host); !ok {
 		writePeerTable(host, new(api.PeerInfo))
@@ -51,6 +201,15 @@ func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.P
 		}
 		peer.RoutingPub = rpk
 	}
+	// checked on every poll, the pin may have been set after the key was cached
+	if expectedPub != "" && peer.RoutingPub.ToB64() != expectedPub {
+		events.Warning(node, "peer "+host+" presented routing key "+peer.RoutingPub.ToB64()+", expected "+expectedPub)
+		peer.RoutingPub = nil // ask again next time, in case the pin is updated
+		return false, api.ErrPeerIdentity
+	}
+	if !peer.VersionChecked {
+		exchangeVersion(transport, node, host, peer, pubsrv, expectedPub != "")
+	}
 
 	// Pickup Local
 	toRemote, err := node.Pickup(peer.RoutingPub, peer.LastPollLocal, transport.ByteLimit())
@@ -64,6 +223,7 @@ func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.P
 	toLocalRaw, err := transport.RPC(host, api.Pickup, pubsrv, peer.LastPollRemote)
 	if err != nil {
 		events.Error(node, "remote pickup error: "+err.Error())
+		peer.VersionChecked = false // the remote node may have restarted, or been upgraded
 		return false, err
 	}
 	var toLocal api.Bundle
@@ -76,11 +236,18 @@ func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.P
 		}
 		events.Debug(node, "pollServer Pickup Remote len: %d ", len(toLocal.Data))
 		peer.TotalBytesRX = peer.TotalBytesRX + int64(len(toLocal.Data))
+		metrics.Default.Add(metrics.PeerRXBytes, float64(len(toLocal.Data)), "node", nodeLabel(node), "peer", host)
+		metrics.Default.Observe(metrics.BundleBytes, float64(len(toLocal.Data)), "direction", "rx")
 	}
 	// Dropoff Remote
 	if len(toRemote.Data) > 0 {
+		if err := stampBundle(transport, node, host, peer, &toRemote); err != nil {
+			events.Error(node, "stamp error: "+err.Error())
+			return false, err
+		}
 		if _, err := transport.RPC(host, api.Dropoff, toRemote); err != nil {
 			events.Error(node, "remote dropoff error: "+err.Error())
+			peer.StampChecked = false // ask again, the difficulty may have changed
 			return false, err
 		}
 		// only start tracking time once we start receiving data
@@ -88,6 +255,8 @@ func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.P
 			peer.LastPollLocal = toRemote.Time
 		}
 		peer.TotalBytesTX = peer.TotalBytesTX + int64(len(toRemote.Data))
+		metrics.Default.Add(metrics.PeerTXBytes, float64(len(toRemote.Data)), "node", nodeLabel(node), "peer", host)
+		metrics.Default.Observe(metrics.BundleBytes, float64(len(toRemote.Data)), "direction", "tx")
 	}
 	// Dropoff Local
 	if toLocalRaw != nil && len(toLocal.Data) > 0 {
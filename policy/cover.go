@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/rand"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// Cover traffic schedules
+const (
+	// CoverOff : no cover traffic
+	CoverOff = ""
+	// CoverConstant : dummy messages at fixed intervals of 1/Rate
+	CoverConstant = "constant"
+	// CoverPoisson : dummy messages at exponentially distributed intervals with mean 1/Rate, like independent real traffic
+	CoverPoisson = "poisson"
+)
+
+// MaxCoverBurst : most dummy messages generated at once, after a long pause the backlog is skipped
+const MaxCoverBurst = 8
+
+// Cover : generates dummy messages between polls, so an observer cannot tell when a node has real traffic.
+// Dummy messages are flagged with ExtCover, peers that support CapCover pick them up like any other
+// message and their routers discard them, older peers never receive them.
+type Cover struct {
+	Mode string  // CoverConstant, CoverPoisson, or CoverOff
+	Rate float64 // average dummy messages per second
+	Size int     // content bytes of each dummy message, before compression and padding
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Due : returns how many dummy messages are due at now, and schedules the next one
+func (c *Cover) Due(now time.Time) int {
+	if c == nil || c.Rate <= 0 || (c.Mode != CoverConstant && c.Mode != CoverPoisson) {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next.IsZero() {
+		c.next = now.Add(c.interval())
+		return 0
+	}
+	n := 0
+	for !c.next.After(now) && n < MaxCoverBurst {
+		n++
+		c.next = c.next.Add(c.interval())
+	}
+	if !c.next.After(now) {
+		c.next = now.Add(c.interval())
+	}
+	return n
+}
+
+func (c *Cover) interval() time.Duration {
+	mean := float64(time.Second) / c.Rate
+	if c.Mode == CoverPoisson {
+		return time.Duration(mrand.ExpFloat64() * mean)
+	}
+	return time.Duration(mean)
+}
+
+// Generate : queues the dummy messages due at now in the node's outbox, a nil Cover generates nothing
+func (c *Cover) Generate(node api.Node, now time.Time) error {
+	for i := c.Due(now); i > 0; i-- {
+		if err := SendCover(node, c.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendCover : queues one dummy message with size bytes of random content,
+// it is encrypted to the node's own content key like a real message, so only its header marks it
+func SendCover(node api.Node, size int) error {
+	cid, err := node.CID()
+	if err != nil {
+		return err
+	}
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		return err
+	}
+	msg := api.Msg{Content: bytes.NewBuffer(content), PubKey: cid}
+	msg.SetExtension(api.ExtCover, nil)
+	return node.SendMsg(msg)
+}
@@ -0,0 +1,27 @@
+// +build !no_json
+
+package policy
+
+import "errors"
+
+// CoverFromMap : reads a Cover from the "Cover" entry of a policy's JSON config, nil if there is none
+func CoverFromMap(t map[string]interface{}) (*Cover, error) {
+	v, ok := t["Cover"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid Cover config")
+	}
+	c := new(Cover)
+	c.Mode, _ = m["Mode"].(string)
+	c.Rate, _ = m["Rate"].(float64)
+	if size, ok := m["Size"].(float64); ok {
+		c.Size = int(size)
+	}
+	if c.Mode != CoverOff && c.Mode != CoverConstant && c.Mode != CoverPoisson {
+		return nil, errors.New("Unknown Cover mode: " + c.Mode)
+	}
+	return c, nil
+}
@@ -0,0 +1,37 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Cover_Due(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	c := &Cover{Mode: CoverConstant, Rate: 2}
+	if c.Due(start) != 0 {
+		t.Fatal("cover due before the first interval")
+	}
+	if n := c.Due(start.Add(time.Second)); n != 2 {
+		t.Fatal("expected 2 dummy messages after one second, got", n)
+	}
+	if n := c.Due(start.Add(time.Hour)); n != MaxCoverBurst {
+		t.Fatal("backlog not capped:", n)
+	}
+	if n := c.Due(start.Add(time.Hour + time.Second)); n != 2 {
+		t.Fatal("schedule not reset after a backlog:", n)
+	}
+
+	p := &Cover{Mode: CoverPoisson, Rate: 10}
+	total := 0
+	for i := 0; i <= 1000; i++ { // 100 seconds
+		total += p.Due(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	if total < 800 || total > 1200 {
+		t.Fatal("poisson cover far from its rate:", total)
+	}
+
+	var off *Cover
+	if off.Due(start) != 0 || (&Cover{Mode: "bogus", Rate: 1}).Due(start.Add(time.Hour)) != 0 {
+		t.Fatal("disabled cover generated messages")
+	}
+}
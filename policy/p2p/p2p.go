@@ -36,8 +36,13 @@ type P2P struct {
 	Transport     api.Transport
 	Node          api.Node
 
+	// Cover : dummy messages generated between polls, nil for none
+	Cover *policy.Cover
+
 	listenSocket *net.UDPConn
 	dialSocket   *net.UDPConn
+
+	health api.HealthTracker
 }
 
 var (
@@ -115,8 +120,14 @@ func (s *P2P) RunPolicy() error {
 
 	s.Transport.Listen(s.ListenURI, s.AdminMode)
 	s.IsListening = true
+	s.health.Listening(multicastAddr.String())
 
-	go s.mdnsListen()
+	go func() {
+		if err := s.mdnsListen(); err != nil && s.IsListening {
+			events.Error(s.Node, "mdnsListen errored: "+err.Error())
+			s.health.ListenFailed(err)
+		}
+	}()
 	go func() {
 		for s.IsListening {
 			if err := s.mdnsAdvertise(); err != nil {
@@ -136,6 +147,12 @@ func (s *P2P) Stop() {
 
 	s.listenSocket.Close()
 	s.dialSocket.Close()
+	s.health.Stopped()
+}
+
+// Health : reports whether mDNS discovery is listening and how the last poll went
+func (s *P2P) Health() api.ComponentHealth {
+	return s.health.Report("p2p")
 }
 
 func (s *P2P) mdnsListen() error {
@@ -205,7 +222,12 @@ func (s *P2P) mdnsListen() error {
 				go func() {
 					for s.IsListening {
 						st := time.Now()
-						if happy, err := policy.PollServer(trans, s.Node, target[len(u.Scheme)+3:], pubsrv); !happy {
+						if err := s.Cover.Generate(s.Node, st); err != nil {
+							events.Warning(s.Node, "p2p cover traffic error: "+err.Error())
+						}
+						happy, err := policy.PollServer(trans, s.Node, target[len(u.Scheme)+3:], pubsrv)
+						s.health.Record(policy.PollError(happy, err))
+						if !happy {
 							if err != nil {
 								events.Warning(s.Node, err.Error())
 							}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/policy"
 )
 
 func init() {
@@ -19,17 +21,27 @@ func NewFromMap(transport api.Transport, node api.Node, p map[string]interface{}
 	adminMode := p["AdminMode"].(bool)
 	listenInterval := p["ListenInterval"].(int)
 	advertiseInterval := p["AdvertiseInterval"].(int)
-	return New(transport, listenURI, node, adminMode, listenInterval, advertiseInterval)
+	s := New(transport, listenURI, node, adminMode, listenInterval, advertiseInterval)
+	cover, err := policy.CoverFromMap(p)
+	if err != nil {
+		events.Error(node, "p2p: "+err.Error())
+	}
+	s.Cover = cover
+	return s
 }
 
 // MarshalJSON : Create a serialied representation of the config of this policy
 func (s *P2P) MarshalJSON() (b []byte, e error) {
-	return json.Marshal(map[string]interface{}{
+	m := map[string]interface{}{
 		"Policy":            "p2p",
 		"ListenURI":         s.ListenURI,
 		"AdminMode":         s.AdminMode,
 		"Transport":         s.Transport,
 		"ListenInterval":    s.ListenInterval,
 		"AdvertiseInterval": s.AdvertiseInterval,
-	})
+	}
+	if s.Cover != nil {
+		m["Cover"] = s.Cover
+	}
+	return json.Marshal(m)
 }
@@ -21,6 +21,8 @@ type Poll struct {
 	// last poll times
 	lastPollLocal, lastPollRemote int64
 
+	health api.HealthTracker
+
 	Transport api.Transport
 	node      api.Node
 
@@ -32,6 +34,9 @@ type Poll struct {
 
 	RetryForever  bool
 	RetryAttempts int
+
+	// Cover : dummy messages generated between polls, nil for none
+	Cover *policy.Cover
 }
 
 // New : Returns a new instance of a Poll Connection Policy
@@ -74,6 +79,8 @@ func (p *Poll) SetJitter(newJitter int) {
 	atomic.StoreInt32(&p.jitter, int32(newJitter))
 }
 
+var errNoPeers = errors.New("All Peers have been disabled or hit retry limits")
+
 // RunPolicy : Poll
 func (p *Poll) RunPolicy() error {
 	if p.isRunning {
@@ -98,7 +105,6 @@ func (p *Poll) RunPolicy() error {
 
 		fails := make(map[string]int)
 		b := make([]byte, 1)
-		counter := 0
 		for {
 			// check if we should still be running
 			if !p.isRunning {
@@ -118,10 +124,15 @@ func (p *Poll) RunPolicy() error {
 				time.Sleep(sleep) // update interval
 			}
 
+			if err := p.Cover.Generate(p.node, time.Now()); err != nil {
+				events.Warning(p.node, "Poll.RunPolicy cover traffic error: ", err)
+			}
+
 			// Get Server List for this Poll's assigned Group
 			peers, err := p.node.GetPeers(p.Groups[p.curGroupIndex])
 			if err != nil {
 				events.Warning(p.node, "Poll.RunPolicy error in loop: ", err)
+				p.health.Record(err)
 				continue
 			}
 			tries := 0
@@ -132,7 +143,8 @@ func (p *Poll) RunPolicy() error {
 				if element.Enabled && fails[element.URI] < p.RetryAttempts {
 					tries++
 
-					_, err := policy.PollServer(p.Transport, p.node, element.URI, pubsrv)
+					err := policy.PollError(policy.PollPeer(p.Transport, p.node, element, pubsrv))
+					p.health.Record(err)
 					if err != nil {
 						events.Warning(p.node, "pollServer error: ", err.Error())
 						fails[element.URI]++
@@ -150,12 +162,9 @@ func (p *Poll) RunPolicy() error {
 					p.curGroupIndex = 0
 				} else {
 					events.Warning(p.node, "pollServer error: All Peers have been disabled or hit retry limits")
+					p.health.Record(errNoPeers)
 				}
 			}
-			if counter%500 == 0 {
-				p.node.FlushOutbox(300) // seconds to cache
-			}
-			counter++
 		}
 	}()
 
@@ -167,6 +176,12 @@ func (p *Poll) Stop() {
 	p.isRunning = false
 	p.wg.Wait()
 	p.Transport.Stop()
+	p.health.Stopped()
+}
+
+// Health : reports how the last poll went
+func (p *Poll) Health() api.ComponentHealth {
+	return p.health.Report("poll")
 }
 
 // GetTransport : Returns the transports associated with this policy
@@ -7,6 +7,8 @@ import (
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/policy"
 )
 
 func init() {
@@ -26,16 +28,26 @@ func NewFromMap(transport api.Transport, node api.Node,
 	}
 
 	// groups :=
-	return New(transport, node, interval, jitter, groups...)
+	p := New(transport, node, interval, jitter, groups...)
+	cover, err := policy.CoverFromMap(t)
+	if err != nil {
+		events.Error(node, "poll: "+err.Error())
+	}
+	p.Cover = cover
+	return p
 }
 
 // MarshalJSON : Create a serialied representation of the config of this policy
 func (p *Poll) MarshalJSON() (b []byte, e error) {
-	return json.Marshal(map[string]interface{}{
+	m := map[string]interface{}{
 		"Policy":    "poll",
 		"Transport": p.Transport,
 		"Interval":  p.GetInterval(),
 		"Jitter":    p.GetJitter(),
 		"Groups":    p.Groups,
-	})
+	}
+	if p.Cover != nil {
+		m["Cover"] = p.Cover
+	}
+	return json.Marshal(m)
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/bencrypt/ecc"
@@ -41,10 +43,13 @@ func p2pServe(transportPublic api.Transport, transportAdmin api.Transport, node
 func main() {
 	var dbFile string
 	var publicPort, adminPort int
+	var adminCA, adminPins string
 
 	flag.StringVar(&dbFile, "dbfile", "ratnet.ql", "QL Database File")
 	flag.IntVar(&publicPort, "p", 20001, "HTTPS Public Port (*)")
 	flag.IntVar(&adminPort, "ap", 20002, "HTTPS Admin Port (localhost)")
+	flag.StringVar(&adminCA, "adminca", "", "PEM file of CAs trusted to sign admin client certificates")
+	flag.StringVar(&adminPins, "adminpins", "", "Comma-separated SHA-256 fingerprints of allowed admin client certificates")
 	flag.Parse()
 
 	publicString := fmt.Sprintf(":%d", publicPort)
@@ -62,5 +67,15 @@ func main() {
 		log.Fatal(err)
 	}
 
-	serve(https.New(cert, key, node, true), https.New(cert, key, node, true), node, publicString, adminString)
+	admin := https.New(cert, key, node, true)
+	if adminCA != "" {
+		if admin.ClientCAs, err = ioutil.ReadFile(adminCA); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if adminPins != "" {
+		admin.ClientPins = strings.Split(adminPins, ",")
+	}
+
+	serve(https.New(cert, key, node, true), admin, node, publicString, adminString)
 }
@@ -2,13 +2,15 @@ package router
 
 import (
 	"bytes"
-	"errors"
 	"hash/crc32"
 	"math"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
 )
 
 const (
@@ -97,6 +99,12 @@ type DefaultRouter struct {
 	ForwardUnknownChannels bool
 	// ForwardUnknownProfile - Should node forward non-consumed messages that matched a profile key
 	ForwardUnknownProfiles bool
+
+	// Mix - Hold forwarded messages in a pool and release them in shuffled batches, nil forwards at once
+	Mix *Mix
+
+	configErr  error // error in the config the router was made from, reported once a node uses it
+	configOnce sync.Once
 }
 
 // NewDefaultRouter - returns a new instance of DefaultRouter
@@ -127,8 +135,21 @@ func (r *DefaultRouter) GetPatches() []api.Patch {
 }
 
 func (r *DefaultRouter) forward(node api.Node, msg api.Msg) error {
+	if !msg.DecrementHopLimit() {
+		events.Dropped(node, msg, api.DropHopLimit)
+		metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionHopLimit)
+		return nil
+	}
 	for _, p := range r.Patches { // todo: this could be constant-time
-		if msg.Name == p.From { // we don't check for IsChan here, we allow forwarding from "" chan to channels
+		if msg.Onion {
+			break // a layer for the next relay, not a message to patch
+		}
+		from := msg.Name
+		if msg.Tagged {
+			from = msg.Channel
+		}
+		if from == p.From { // we don't check for IsChan here, we allow forwarding from "" chan to channels
+			msg.Tagged, msg.Channel = false, ""
 			for i := 0; i < len(p.To); i++ {
 				msg.Name = p.To[i]
 				if msg.Name == "" {
@@ -136,40 +157,109 @@ func (r *DefaultRouter) forward(node api.Node, msg api.Msg) error {
 				} else {
 					msg.IsChan = true
 				}
-				if err := node.Forward(msg); err != nil {
+				if err := r.output(node, msg); err != nil {
 					return err
 				}
 			}
 			return nil
 		}
 	}
-	if err := node.Forward(msg); err != nil {
+	metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionForwarded)
+	return r.output(node, msg)
+}
+
+// output - queues a message in the node's outbox, by way of the mix pool if there is one
+func (r *DefaultRouter) output(node api.Node, msg api.Msg) error {
+	if held, err := r.Mix.Hold(node, msg); held || err != nil {
 		return err
 	}
-	return nil
+	return node.Forward(msg)
+}
+
+// MixLocal : Hands a message this node originated to the mix pool, returns false if it should be queued at once
+func (r *DefaultRouter) MixLocal(node api.Node, msg api.Msg) (bool, error) {
+	if r.Mix == nil || !r.Mix.Local {
+		return false, nil
+	}
+	return r.Mix.Hold(node, msg)
+}
+
+// FlushMix : Forwards the messages the mix pool holds for node at once, called when the node stops
+func (r *DefaultRouter) FlushMix(node api.Node) error {
+	return r.Mix.FlushNode(node)
+}
+
+// channelByTag - returns the name of the channel a ChannelTag belongs to, "" if it is not one of ours
+func channelByTag(node api.Node, tag string) (string, error) {
+	channels, err := node.GetChannels()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	for _, c := range channels {
+		privkey, err := node.GetChannelPrivKey(c.Name)
+		if err != nil {
+			continue
+		}
+		if api.MatchChannelTag(privkey, tag, now) {
+			return c.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// peel - removes an onion layer addressed to this node and forwards what was inside to the next hop,
+// returns false if the layer is for another relay
+func (r *DefaultRouter) peel(node api.Node, msg api.Msg) (bool, error) {
+	tagOK, clear, err := node.PeelOnion(msg)
+	if !tagOK || err != nil {
+		return tagOK, err
+	}
+	nextHop, inner, err := api.OpenOnion(clear)
+	if err != nil {
+		return true, err
+	}
+	hdr, err := api.ParseMsgHeader(inner)
+	if err != nil {
+		return true, err
+	}
+	next := hdr.Msg()
+	next.NextHop = nextHop // the outbox only releases the next layer to its relay
+	next.Content = bytes.NewBuffer(inner[hdr.Size:])
+	return true, r.forward(node, next)
 }
 
 // Route - Router that does default behavior
 func (r *DefaultRouter) Route(node api.Node, message []byte) error {
+	if r.configErr != nil {
+		r.configOnce.Do(func() { events.Warning(node, "router config ignored: "+r.configErr.Error()) })
+	}
 	//  Stuff Everything will need just about every time...
 	//
 	var msg api.Msg
-	flags := message[0]
-	idx := 1
+	hdr, err := api.ParseMsgHeader(message)
+	if err != nil {
+		return err
+	}
+	flags := hdr.Flags
+	idx := hdr.Size
 	msg.IsChan = ((flags & api.ChannelFlag) != 0)
 	msg.Chunked = ((flags & api.ChunkedFlag) != 0)
 	msg.StreamHeader = ((flags & api.StreamHeaderFlag) != 0)
-	var channelLen uint16 // beginning uint16 of message is channel name length
-	if msg.IsChan {
-		channelLen = (uint16(message[1]) << 8) | uint16(message[2])
-		msg.Name = string(message[3 : 3+channelLen]) // flags[0], chan name length[1,2]
-		idx += 2 + int(channelLen)                   // skip over the channel name
+	msg.Onion = ((flags & api.OnionFlag) != 0)
+	msg.Tagged = ((flags & api.TaggedFlag) != 0)
+	msg.Name = hdr.Name
+	msg.Extensions = hdr.Extensions
+	if msg.GetExtension(api.ExtCover) != nil {
+		metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionCover)
+		return nil // cover traffic, only sent one hop
 	}
-	if idx+16 >= len(message) {
-		return errors.New("Malformed message")
+	if idx+nonceSize > len(message) {
+		return &api.WireError{Op: "message nonce", Err: api.ErrInputTooShort}
 	}
 	nonce := message[idx : idx+nonceSize]
 	if r.SeenRecently(nonce) { // LOOP PREVENTION before handling or forwarding
+		metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionDuplicate)
 		return nil
 	}
 	cid, err := node.CID() // we need this for cloning
@@ -177,19 +267,48 @@ func (r *DefaultRouter) Route(node api.Node, message []byte) error {
 		return err
 	}
 	msg.Content = bytes.NewBuffer(message[idx:])
+	// a message with a critical extension this node does not understand is only forwarded
+	canHandle := hdr.Supported()
+	if msg.Onion {
+		peeled, err := r.peel(node, msg)
+		if peeled && err == nil {
+			metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionPeeled)
+		}
+		if err != nil || peeled {
+			return err
+		}
+		// a layer for another relay, outboxes only release layers to their relay, so it is not passed on
+		events.Dropped(node, msg, api.DropOnion)
+		metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionUnpeeled)
+		return nil
+	}
 
 	// Routing Logic
 	if msg.IsChan { // channel message
 		consumed := false
-		if r.CheckChannels {
-			chn, err := node.GetChannel(msg.Name)
+		if r.CheckChannels && canHandle {
+			name := msg.Name
+			if msg.Tagged {
+				if name, err = channelByTag(node, msg.Name); err != nil {
+					return err
+				}
+			}
+			chn, err := node.GetChannel(name)
 			if chn != nil && err == nil { // this is a channel key we know
 				pubkey := cid.Clone()
 				pubkey.FromB64(chn.Pubkey)
-				consumed, err = node.Handle(msg)
+				if msg.Tagged {
+					msg.Channel = name // forwarded with the tag, queued under the name
+				}
+				hmsg := msg // handled with the name
+				hmsg.Name, hmsg.Tagged, hmsg.Channel = name, false, ""
+				consumed, err = node.Handle(hmsg)
 				if err != nil {
 					return err
 				}
+				if consumed {
+					metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionConsumed)
+				}
 			}
 		}
 		if (!consumed && r.ForwardUnknownChannels) || (consumed && r.ForwardConsumedChannels) {
@@ -200,7 +319,7 @@ func (r *DefaultRouter) Route(node api.Node, message []byte) error {
 	} else { // private message (zero length channel)
 		// content key case (to be removed, deprecated)
 		consumedContent := false
-		if r.CheckContent {
+		if r.CheckContent && canHandle {
 			consumedContent, err = node.Handle(msg)
 			if err != nil {
 				return err
@@ -208,7 +327,7 @@ func (r *DefaultRouter) Route(node api.Node, message []byte) error {
 		}
 		// profile keys case
 		consumedProfile := false
-		if r.CheckProfiles {
+		if r.CheckProfiles && canHandle {
 			profiles, err := node.GetProfiles()
 			if err != nil {
 				return err
@@ -227,6 +346,9 @@ func (r *DefaultRouter) Route(node api.Node, message []byte) error {
 				}
 			}
 		}
+		if consumedContent || consumedProfile {
+			metrics.Default.Add(metrics.RouterMessages, 1, "decision", metrics.DecisionConsumed)
+		}
 		fwdUnknowns := r.ForwardUnknownContent || r.ForwardUnknownProfiles // todo: these are redundant fields
 		if (!consumedContent && !consumedProfile && fwdUnknowns) ||
 			(consumedContent && r.ForwardConsumedContent) ||
@@ -4,6 +4,8 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
@@ -15,12 +17,53 @@ func init() {
 
 // NewRouterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
 func NewRouterFromMap(r map[string]interface{}) api.Router {
-	return NewDefaultRouter()
+	router := NewDefaultRouter()
+	mix, err := MixFromMap(r)
+	if err != nil {
+		router.configErr = errors.New("Mix: " + err.Error()) // forward at once instead
+		return router
+	}
+	router.Mix = mix
+	return router
+}
+
+// MixFromMap : reads a Mix from the "Mix" entry of a router's JSON config, nil if there is none
+func MixFromMap(r map[string]interface{}) (*Mix, error) {
+	v, ok := r["Mix"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid Mix config")
+	}
+	mix := new(Mix)
+	if threshold, ok := m["Threshold"].(float64); ok {
+		mix.Threshold = int(threshold)
+	}
+	if delay, ok := m["MaxDelay"].(string); ok && delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			return nil, err
+		}
+		mix.MaxDelay = d
+	}
+	mix.Local, _ = m["Local"].(bool)
+	return mix, nil
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this mix pool
+func (m *Mix) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Threshold": m.Threshold,
+		"MaxDelay":  m.MaxDelay.String(),
+		"Local":     m.Local,
+	})
 }
 
 // MarshalJSON : Create a serialized JSON blob out of the config of this router
 func (r *DefaultRouter) MarshalJSON() (b []byte, e error) {
-	return json.Marshal(map[string]interface{}{
+	m := map[string]interface{}{
 		"Router":                  "default",
 		"CheckContent":            r.CheckContent,
 		"ForwardConsumedContent":  r.ForwardConsumedContent,
@@ -32,5 +75,9 @@ func (r *DefaultRouter) MarshalJSON() (b []byte, e error) {
 		"ForwardConsumedChannels": r.ForwardConsumedChannels,
 		"ForwardUnknownChannels":  r.ForwardUnknownChannels,
 		"Patches":                 r.Patches,
-	})
+	}
+	if r.Mix != nil {
+		m["Mix"] = r.Mix
+	}
+	return json.Marshal(m)
 }
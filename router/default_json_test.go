@@ -0,0 +1,18 @@
+// +build !no_json
+
+package router
+
+import (
+	"testing"
+)
+
+func Test_NewRouterFromMap_BadMix(t *testing.T) {
+	r := NewRouterFromMap(map[string]interface{}{"Router": "default", "Mix": map[string]interface{}{"MaxDelay": "soon"}}).(*DefaultRouter)
+	if r.Mix != nil || r.configErr == nil {
+		t.Fatal("malformed Mix config was not ignored")
+	}
+	r = NewRouterFromMap(map[string]interface{}{"Router": "default", "Mix": map[string]interface{}{"MaxDelay": "1s", "Local": true}}).(*DefaultRouter)
+	if r.Mix == nil || r.Mix.MaxDelay.String() != "1s" || !r.Mix.Local || r.configErr != nil {
+		t.Fatalf("Mix config not read: %+v", r.Mix)
+	}
+}
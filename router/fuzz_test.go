@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package router_test
+
+import (
+	"testing"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+func FuzzRoute(f *testing.F) {
+	node := ram.New(nil, nil)
+	if err := node.Start(); err != nil {
+		f.Fatal(err)
+	}
+	defer node.Stop()
+	chanKey := new(ecc.KeyPair)
+	chanKey.GenerateKey()
+	if err := node.AddChannel("fuzz", chanKey.ToB64()); err != nil {
+		f.Fatal(err)
+	}
+	// seed with well-formed channel and content messages
+	cipher, err := chanKey.EncryptMessage([]byte("hello"), chanKey.GetPubKey())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(append([]byte{api.ChannelFlag, 0, 4, 'f', 'u', 'z', 'z'}, cipher...))
+	cid, _ := node.CID()
+	cipher, err = chanKey.EncryptMessage([]byte("hello"), cid)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(append([]byte{0}, cipher...))
+	f.Add([]byte{api.ChannelFlag, 0, 4, 'f', 'u', 'z', 'z'})
+	f.Add(append([]byte{api.ChunkedFlag}, make([]byte, 160)...))
+	f.Add(append([]byte{api.ChannelFlag | api.StreamHeaderFlag, 0, 4, 'f', 'u', 'z', 'z'}, make([]byte, 160)...))
+
+	r := node.Router()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r.Route(node, data)
+	})
+}
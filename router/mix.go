@@ -0,0 +1,163 @@
+package router
+
+import (
+	"bytes"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
+)
+
+// Mix : a pool that holds the messages a router forwards and releases them in shuffled batches,
+// so an observer cannot match the messages a relay receives with the ones it sends out.
+// A message leaves the pool when its random delay is up, or with the rest of the pool once it holds
+// Threshold messages. With only Threshold set, messages wait until enough others arrive.
+type Mix struct {
+	Threshold int           // release the whole pool once it holds this many messages, 0 disables
+	MaxDelay  time.Duration // hold each message for a random delay of up to MaxDelay, 0 disables
+	Local     bool          // also pool the messages this node sends, instead of queuing them at once
+
+	mu    sync.Mutex
+	pool  []mixEntry
+	stats MixStats
+}
+
+type mixEntry struct {
+	node  api.Node
+	msg   api.Msg
+	due   time.Time
+	timer *time.Timer // releases the message when its delay is up, nil without MaxDelay
+}
+
+// MixStats : pool occupancy counters of a Mix
+type MixStats struct {
+	Pooled   int    // messages in the pool now
+	Peak     int    // most messages the pool has held at once
+	Received uint64 // messages added to the pool
+	Released uint64 // messages forwarded out of the pool
+	Batches  uint64 // number of releases
+}
+
+// Enabled : returns true if the Mix holds messages, a nil Mix does not
+func (m *Mix) Enabled() bool {
+	return m != nil && (m.Threshold > 0 || m.MaxDelay > 0)
+}
+
+// Hold : adds a message to the pool, returns false if the Mix is not enabled and the caller should forward it itself
+func (m *Mix) Hold(node api.Node, msg api.Msg) (bool, error) {
+	if !m.Enabled() {
+		return false, nil
+	}
+	if msg.Content != nil { // the caller's buffer may be reused before release
+		msg.Content = bytes.NewBuffer(append([]byte(nil), msg.Content.Bytes()...))
+	}
+	now := time.Now()
+	e := mixEntry{node: node, msg: msg, due: now}
+	if m.MaxDelay > 0 {
+		d := time.Duration(mrand.Int63n(int64(m.MaxDelay) + 1))
+		e.due = now.Add(d)
+		e.timer = time.AfterFunc(d, func() {
+			if err := m.Release(time.Now()); err != nil {
+				events.Warning(node, "mix release error: "+err.Error())
+			}
+		})
+	}
+
+	m.mu.Lock()
+	m.pool = append(m.pool, e)
+	metrics.Default.Add(metrics.MixPooled, 1)
+	m.stats.Received++
+	if len(m.pool) > m.stats.Peak {
+		m.stats.Peak = len(m.pool)
+	}
+	var batch []mixEntry
+	if m.Threshold > 0 && len(m.pool) >= m.Threshold {
+		batch = m.pool
+		m.pool = nil
+	}
+	m.mu.Unlock()
+	return true, m.release(batch)
+}
+
+// Release : forwards the messages whose delay is up at now, in random order
+func (m *Mix) Release(now time.Time) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	var batch, keep []mixEntry
+	for _, e := range m.pool {
+		if m.MaxDelay > 0 && !e.due.After(now) {
+			batch = append(batch, e)
+		} else {
+			keep = append(keep, e)
+		}
+	}
+	m.pool = keep
+	m.mu.Unlock()
+	return m.release(batch)
+}
+
+// Flush : forwards every message in the pool at once, in random order
+func (m *Mix) Flush() error {
+	return m.FlushNode(nil)
+}
+
+// FlushNode : forwards the messages pooled by one node at once, in random order, a nil node flushes every message
+func (m *Mix) FlushNode(node api.Node) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	var batch, keep []mixEntry
+	for _, e := range m.pool {
+		if node == nil || e.node == node {
+			batch = append(batch, e)
+		} else {
+			keep = append(keep, e)
+		}
+	}
+	m.pool = keep
+	m.mu.Unlock()
+	return m.release(batch)
+}
+
+// Stats : returns the pool occupancy counters
+func (m *Mix) Stats() MixStats {
+	if m == nil {
+		return MixStats{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats
+	s.Pooled = len(m.pool)
+	return s
+}
+
+func (m *Mix) release(batch []mixEntry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	for _, e := range batch {
+		if e.timer != nil {
+			e.timer.Stop() // released early, or the timer is the one releasing it
+		}
+	}
+	mrand.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+	metrics.Default.Add(metrics.MixPooled, -float64(len(batch)))
+	m.mu.Lock()
+	m.stats.Released += uint64(len(batch))
+	m.stats.Batches++
+	m.mu.Unlock()
+
+	var err error
+	for _, e := range batch {
+		if ferr := e.node.Forward(e.msg); ferr != nil && err == nil {
+			err = ferr // keep going, the rest of the batch is already out of the pool
+		}
+	}
+	return err
+}
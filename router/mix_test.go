@@ -0,0 +1,131 @@
+package router
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// forwardNode - records the messages forwarded to it, all other Node methods are unimplemented
+type forwardNode struct {
+	api.Node
+	mu   sync.Mutex
+	sent []string
+}
+
+func (n *forwardNode) Forward(msg api.Msg) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, msg.Content.String())
+	return nil
+}
+
+func (n *forwardNode) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.sent)
+}
+
+func Test_Mix_Threshold(t *testing.T) {
+	node := new(forwardNode)
+	mix := &Mix{Threshold: 4}
+	for i := 0; i < 3; i++ {
+		held, err := mix.Hold(node, api.Msg{Content: bytes.NewBufferString(string(rune('a' + i)))})
+		if !held || err != nil {
+			t.Fatal("Hold did not pool message", i, err)
+		}
+	}
+	if node.count() != 0 {
+		t.Fatal("messages released below threshold")
+	}
+	if s := mix.Stats(); s.Pooled != 3 || s.Received != 3 {
+		t.Fatalf("wrong stats below threshold: %+v", s)
+	}
+	if _, err := mix.Hold(node, api.Msg{Content: bytes.NewBufferString("d")}); err != nil {
+		t.Fatal(err)
+	}
+	if node.count() != 4 {
+		t.Fatal("pool not released at threshold, forwarded:", node.count())
+	}
+	s := mix.Stats()
+	if s.Pooled != 0 || s.Peak != 4 || s.Released != 4 || s.Batches != 1 {
+		t.Fatalf("wrong stats after release: %+v", s)
+	}
+}
+
+func Test_Mix_Delay(t *testing.T) {
+	node := new(forwardNode)
+	mix := &Mix{MaxDelay: 50 * time.Millisecond}
+	for i := 0; i < 10; i++ {
+		if _, err := mix.Hold(node, api.Msg{Content: bytes.NewBufferString("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for node.count() < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if node.count() != 10 {
+		t.Fatal("delayed messages not released, forwarded:", node.count())
+	}
+	if s := mix.Stats(); s.Pooled != 0 || s.Released != 10 {
+		t.Fatalf("wrong stats after delays: %+v", s)
+	}
+}
+
+func Test_Mix_Disabled(t *testing.T) {
+	var mix *Mix
+	if held, err := mix.Hold(new(forwardNode), api.Msg{Content: bytes.NewBufferString("x")}); held || err != nil {
+		t.Fatal("nil Mix held a message")
+	}
+	r := NewDefaultRouter()
+	r.Mix = &Mix{Threshold: 2}
+	if held, _ := r.MixLocal(new(forwardNode), api.Msg{Content: bytes.NewBufferString("x")}); held {
+		t.Fatal("local message pooled without Local")
+	}
+}
+
+func Test_Mix_CopiesContent(t *testing.T) {
+	node := new(forwardNode)
+	mix := &Mix{Threshold: 2}
+	buf := []byte("first")
+	if _, err := mix.Hold(node, api.Msg{Content: bytes.NewBuffer(buf)}); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf, "XXXXX")
+	if _, err := mix.Hold(node, api.Msg{Content: bytes.NewBufferString("second")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range node.sent {
+		if s == "XXXXX" {
+			t.Fatal("pooled message shares the caller's buffer")
+		}
+	}
+}
+
+func Test_Mix_FlushNode(t *testing.T) {
+	a, b := new(forwardNode), new(forwardNode)
+	mix := &Mix{MaxDelay: time.Hour}
+	for _, n := range []*forwardNode{a, a, b} {
+		if _, err := mix.Hold(n, api.Msg{Content: bytes.NewBufferString("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewDefaultRouter()
+	r.Mix = mix
+	if err := r.FlushMix(a); err != nil {
+		t.Fatal(err)
+	}
+	if a.count() != 2 || b.count() != 0 {
+		t.Fatal("FlushMix forwarded", a.count(), "and", b.count(), "messages")
+	}
+	if s := mix.Stats(); s.Pooled != 1 {
+		t.Fatalf("wrong stats after flushing one node: %+v", s)
+	}
+	if err := mix.Flush(); err != nil || b.count() != 1 {
+		t.Fatal("Flush did not forward the rest of the pool", err)
+	}
+}
@@ -0,0 +1,70 @@
+package simulation
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Clock : virtual clock driving a simulation, time only moves when events are run
+type Clock struct {
+	now    time.Duration
+	seq    uint64
+	events eventQueue
+}
+
+type event struct {
+	at  time.Duration
+	seq uint64 // tie-breaker, events scheduled for the same time run in FIFO order
+	fn  func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
+
+// Now : Returns the current virtual time, measured from the start of the simulation
+func (c *Clock) Now() time.Duration {
+	return c.now
+}
+
+// At : Schedules fn to run at the given virtual time (or immediately, if that time has passed)
+func (c *Clock) At(at time.Duration, fn func()) {
+	if at < c.now {
+		at = c.now
+	}
+	c.seq++
+	heap.Push(&c.events, &event{at: at, seq: c.seq, fn: fn})
+}
+
+// After : Schedules fn to run after the given virtual delay
+func (c *Clock) After(d time.Duration, fn func()) {
+	c.At(c.now+d, fn)
+}
+
+// RunUntil : Runs all events scheduled up to and including the given virtual time
+func (c *Clock) RunUntil(until time.Duration) {
+	for len(c.events) > 0 && c.events[0].at <= until {
+		e := heap.Pop(&c.events).(*event)
+		c.now = e.at
+		e.fn()
+	}
+	if c.now < until {
+		c.now = until
+	}
+}
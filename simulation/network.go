@@ -0,0 +1,459 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes/ram"
+	"github.com/awgh/ratnet/policy"
+	"github.com/awgh/ratnet/policy/poll"
+)
+
+// payloadMagic : prefix of every payload generated by the simulator, followed by a big-endian uint64 message ID
+var payloadMagic = []byte("ratsim")
+
+// SimNode : one ram Node in a simulated Network
+type SimNode struct {
+	Name      string
+	Node      *ram.Node
+	Transport *Transport
+	Policy    *poll.Poll
+
+	crashed bool
+}
+
+type sentMsg struct {
+	from      string
+	size      int
+	sentAt    time.Duration
+	expected  map[string]bool
+	delivered map[string]time.Duration
+}
+
+// Network : a graph of ram Nodes connected by in-memory transports and driven by a virtual Clock
+type Network struct {
+	Clock
+
+	id    string
+	rng   *rand.Rand
+	nodes map[string]*SimNode
+	order []string // node names in insertion order, keeps runs deterministic
+
+	cut       map[string]bool  // directed links that are currently down
+	linkBytes map[string]int64 // bytes per directed link
+	channels  map[string][]string
+
+	nextID     uint64
+	sent       map[uint64]*sentMsg
+	duplicates int
+	unknown    int
+	pollErrors int
+}
+
+var networkCount uint64
+
+// New : Returns a new, empty simulated Network, the seed makes poll jitter reproducible
+func New(seed int64) *Network {
+	n := new(Network)
+	n.id = fmt.Sprintf("sim%d", atomic.AddUint64(&networkCount, 1))
+	n.rng = rand.New(rand.NewSource(seed))
+	n.nodes = make(map[string]*SimNode)
+	n.cut = make(map[string]bool)
+	n.linkBytes = make(map[string]int64)
+	n.channels = make(map[string][]string)
+	n.sent = make(map[uint64]*sentMsg)
+	return n
+}
+
+// Node : Returns the named SimNode, or nil
+func (n *Network) Node(name string) *SimNode {
+	return n.nodes[name]
+}
+
+// AddNode : Adds a ram Node with a Poll policy, interval is in milliseconds and jitter a percentage, as in poll.New
+// An interval of zero means this node never polls and only serves
+func (n *Network) AddNode(name string, interval, jitter int) (*SimNode, error) {
+	if _, ok := n.nodes[name]; ok {
+		return nil, errors.New("Simulated node already exists: " + name)
+	}
+	s := &SimNode{Name: name}
+	s.Node = ram.New(nil, nil)
+	s.Transport = &Transport{network: n, name: name, byteLimit: 8000 * 1024}
+	// the policy is set after Start so that RunPolicy never launches its own goroutine,
+	// polling is driven by the virtual clock instead
+	if err := s.Node.Start(); err != nil {
+		return nil, err
+	}
+	s.Policy = poll.New(s.Transport, s.Node, interval, jitter)
+	s.Node.SetPolicy(s.Policy)
+
+	n.nodes[name] = s
+	n.order = append(n.order, name)
+	if interval > 0 {
+		n.schedulePoll(s)
+	}
+	return s, nil
+}
+
+// Connect : Makes the poller node poll the server node
+func (n *Network) Connect(poller, server string) error {
+	p, ok := n.nodes[poller]
+	if !ok {
+		return errors.New("Unknown simulated node: " + poller)
+	}
+	if _, ok := n.nodes[server]; !ok {
+		return errors.New("Unknown simulated node: " + server)
+	}
+	return p.Node.AddPeer(server, true, n.address(poller, server))
+}
+
+// ConnectBoth : Makes each of the two nodes poll the other
+func (n *Network) ConnectBoth(a, b string) error {
+	if err := n.Connect(a, b); err != nil {
+		return err
+	}
+	return n.Connect(b, a)
+}
+
+// AddChannel : Creates a channel key and adds it to each of the member nodes
+func (n *Network) AddChannel(name string, members ...string) error {
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	for _, m := range members {
+		s, ok := n.nodes[m]
+		if !ok {
+			return errors.New("Unknown simulated node: " + m)
+		}
+		if err := s.Node.AddChannel(name, key.ToB64()); err != nil {
+			return err
+		}
+	}
+	n.channels[name] = append(n.channels[name], members...)
+	return nil
+}
+
+// Send : Sends a direct message of the given size from one node to another at the current virtual time
+func (n *Network) Send(from, to string, size int) (uint64, error) {
+	src, ok := n.nodes[from]
+	if !ok {
+		return 0, errors.New("Unknown simulated node: " + from)
+	}
+	dst, ok := n.nodes[to]
+	if !ok {
+		return 0, errors.New("Unknown simulated node: " + to)
+	}
+	cid, err := dst.Node.CID()
+	if err != nil {
+		return 0, err
+	}
+	id, payload := n.newPayload(from, size, []string{to})
+	if err := src.Node.Send(to, payload, cid); err != nil {
+		delete(n.sent, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// SendRouted : Sends a direct message like Send, wrapped in onion layers for the given relay nodes, first hop first
+func (n *Network) SendRouted(from, to string, size int, relays ...string) (uint64, error) {
+	src, ok := n.nodes[from]
+	if !ok {
+		return 0, errors.New("Unknown simulated node: " + from)
+	}
+	dst, ok := n.nodes[to]
+	if !ok {
+		return 0, errors.New("Unknown simulated node: " + to)
+	}
+	cid, err := dst.Node.CID()
+	if err != nil {
+		return 0, err
+	}
+	var route []bc.PubKey
+	for _, r := range relays {
+		relay, ok := n.nodes[r]
+		if !ok {
+			return 0, errors.New("Unknown simulated node: " + r)
+		}
+		rpub, err := relay.Node.ID()
+		if err != nil {
+			return 0, err
+		}
+		route = append(route, rpub)
+	}
+	id, payload := n.newPayload(from, size, []string{to})
+	if err := src.Node.SendMsg(api.Msg{Content: bytes.NewBuffer(payload), PubKey: cid, Route: route}); err != nil {
+		delete(n.sent, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// SendChannel : Sends a channel message of the given size, every other member of the channel is expected to receive it
+func (n *Network) SendChannel(from, channel string, size int) (uint64, error) {
+	src, ok := n.nodes[from]
+	if !ok {
+		return 0, errors.New("Unknown simulated node: " + from)
+	}
+	members, ok := n.channels[channel]
+	if !ok {
+		return 0, errors.New("Unknown simulated channel: " + channel)
+	}
+	var expected []string
+	for _, m := range members {
+		if m != from {
+			expected = append(expected, m)
+		}
+	}
+	id, payload := n.newPayload(from, size, expected)
+	if err := src.Node.SendChannel(channel, payload); err != nil {
+		delete(n.sent, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (n *Network) newPayload(from string, size int, expected []string) (uint64, []byte) {
+	n.nextID++
+	id := n.nextID
+	if min := len(payloadMagic) + 8; size < min {
+		size = min
+	}
+	payload := make([]byte, size)
+	copy(payload, payloadMagic)
+	binary.BigEndian.PutUint64(payload[len(payloadMagic):], id)
+
+	m := &sentMsg{from: from, size: size, sentAt: n.Now(), expected: make(map[string]bool), delivered: make(map[string]time.Duration)}
+	for _, e := range expected {
+		m.expected[e] = true
+	}
+	n.sent[id] = m
+	return id, payload
+}
+
+// Cut : Takes down the link between two nodes, in both directions
+func (n *Network) Cut(a, b string) {
+	n.cut[a+"->"+b] = true
+	n.cut[b+"->"+a] = true
+}
+
+// Restore : Brings back up the link between two nodes
+func (n *Network) Restore(a, b string) {
+	delete(n.cut, a+"->"+b)
+	delete(n.cut, b+"->"+a)
+}
+
+// Partition : Cuts every link between the two groups of nodes
+func (n *Network) Partition(groupA, groupB []string) {
+	for _, a := range groupA {
+		for _, b := range groupB {
+			n.Cut(a, b)
+		}
+	}
+}
+
+// Heal : Restores every link in the network
+func (n *Network) Heal() {
+	n.cut = make(map[string]bool)
+}
+
+// Crash : Stops a node from polling and from answering polls, its state is kept for Recover
+func (n *Network) Crash(name string) {
+	if s, ok := n.nodes[name]; ok {
+		s.crashed = true
+	}
+}
+
+// Recover : Brings a crashed node back
+func (n *Network) Recover(name string) {
+	if s, ok := n.nodes[name]; ok {
+		s.crashed = false
+	}
+}
+
+// Run : Runs the simulation until the given virtual time
+func (n *Network) Run(until time.Duration) {
+	n.RunUntil(until)
+	n.collect()
+}
+
+// Stop : Stops all the nodes in the network
+func (n *Network) Stop() {
+	for _, name := range n.order {
+		n.nodes[name].Node.Stop()
+	}
+}
+
+func (n *Network) reachable(from, to string) bool {
+	if n.nodes[from].crashed || n.nodes[to].crashed {
+		return false
+	}
+	return !n.cut[from+"->"+to]
+}
+
+func (n *Network) countBytes(from, to string, count int) {
+	n.linkBytes[from+"->"+to] += int64(count)
+}
+
+// schedulePoll - schedules the next poll of this node, using the same jitter discount as poll.Poll
+func (n *Network) schedulePoll(s *SimNode) {
+	delay := time.Duration(s.Policy.GetInterval()) * time.Millisecond
+	if jit := s.Policy.GetJitter(); jit > 0 {
+		delay = time.Duration((float64(100-(n.rng.Intn(256)%jit)) / 100) * float64(delay))
+	}
+	n.After(delay, func() {
+		n.poll(s)
+		n.schedulePoll(s)
+	})
+}
+
+func (n *Network) poll(s *SimNode) {
+	if s.crashed {
+		return
+	}
+	pubsrv, err := s.Node.ID()
+	if err != nil {
+		events.Error(s.Node, err.Error())
+		return
+	}
+	peers, err := s.Node.GetPeers()
+	if err != nil {
+		events.Error(s.Node, err.Error())
+		return
+	}
+	if err := s.Policy.Cover.Generate(s.Node, time.Unix(0, int64(n.Now()))); err != nil {
+		events.Error(s.Node, err.Error())
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	for _, peer := range peers {
+		if !peer.Enabled {
+			continue
+		}
+		if _, err := policy.PollPeer(s.Transport, s.Node, peer, pubsrv); err != nil {
+			n.pollErrors++
+		}
+		n.collect()
+	}
+}
+
+// collect - drains the Out channel of every node and records deliveries
+func (n *Network) collect() {
+	for _, name := range n.order {
+		s := n.nodes[name]
+		for {
+			select {
+			case msg := <-s.Node.Out():
+				n.record(name, msg)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+func (n *Network) record(name string, msg api.Msg) {
+	if msg.Content == nil {
+		n.unknown++
+		return
+	}
+	b := msg.Content.Bytes()
+	if len(b) < len(payloadMagic)+8 || !bytes.Equal(b[:len(payloadMagic)], payloadMagic) {
+		n.unknown++
+		return
+	}
+	id := binary.BigEndian.Uint64(b[len(payloadMagic):])
+	m, ok := n.sent[id]
+	if !ok || !m.expected[name] || len(b) != m.size {
+		n.unknown++
+		return
+	}
+	if _, ok := m.delivered[name]; ok {
+		n.duplicates++
+		return
+	}
+	m.delivered[name] = n.Now() - m.sentAt
+}
+
+// Report : Summarizes the traffic of a simulation run
+type Report struct {
+	Sent          int
+	Expected      int // number of (message, recipient) pairs
+	Delivered     int
+	DeliveryRatio float64
+	Duplicates    int
+	Unexpected    int // messages received that were not addressed to the receiving node, or arrived with the wrong size
+	PollErrors    int
+
+	MinLatency  time.Duration
+	MeanLatency time.Duration
+	MaxLatency  time.Duration
+
+	LinkBytes map[string]int64 // keyed by "from->to"
+}
+
+// Report : Returns the statistics gathered so far
+func (n *Network) Report() Report {
+	r := Report{
+		Sent:       len(n.sent),
+		Duplicates: n.duplicates,
+		Unexpected: n.unknown,
+		PollErrors: n.pollErrors,
+		LinkBytes:  make(map[string]int64),
+	}
+	var total time.Duration
+	for _, m := range n.sent {
+		r.Expected += len(m.expected)
+		for _, lat := range m.delivered {
+			if r.Delivered == 0 || lat < r.MinLatency {
+				r.MinLatency = lat
+			}
+			if lat > r.MaxLatency {
+				r.MaxLatency = lat
+			}
+			total += lat
+			r.Delivered++
+		}
+	}
+	if r.Expected > 0 {
+		r.DeliveryRatio = float64(r.Delivered) / float64(r.Expected)
+	}
+	if r.Delivered > 0 {
+		r.MeanLatency = total / time.Duration(r.Delivered)
+	}
+	for k, v := range n.linkBytes {
+		r.LinkBytes[k] = v
+	}
+	return r
+}
+
+// Delivered : Returns true if the given message reached every expected recipient
+func (n *Network) Delivered(id uint64) bool {
+	m, ok := n.sent[id]
+	return ok && len(m.delivered) == len(m.expected)
+}
+
+func (r Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "sent %d, delivered %d/%d (%.1f%%), duplicates %d, unexpected %d, poll errors %d\n",
+		r.Sent, r.Delivered, r.Expected, r.DeliveryRatio*100, r.Duplicates, r.Unexpected, r.PollErrors)
+	fmt.Fprintf(&buf, "latency min %v mean %v max %v\n", r.MinLatency, r.MeanLatency, r.MaxLatency)
+	links := make([]string, 0, len(r.LinkBytes))
+	for k := range r.LinkBytes {
+		links = append(links, k)
+	}
+	sort.Strings(links)
+	for _, k := range links {
+		fmt.Fprintf(&buf, "%s: %d bytes\n", k, r.LinkBytes[k])
+	}
+	return buf.String()
+}
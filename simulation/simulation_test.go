@@ -0,0 +1,649 @@
+package simulation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/metrics"
+	"github.com/awgh/ratnet/api/stamp"
+	"github.com/awgh/ratnet/policy"
+	"github.com/awgh/ratnet/router"
+)
+
+// line : a-b-c-d, each node polls its neighbours
+func line(t *testing.T, seed int64) *Network {
+	n := New(seed)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if _, err := n.AddNode(name, 500, 20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pair := range [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}} {
+		if err := n.ConnectBoth(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+func Test_clock_order(t *testing.T) {
+	var c Clock
+	var got []int
+	c.At(2*time.Second, func() { got = append(got, 3) })
+	c.At(time.Second, func() { got = append(got, 1) })
+	c.At(time.Second, func() {
+		got = append(got, 2)
+		c.After(0, func() { got = append(got, 4) }) // same time, runs after everything already scheduled for it
+	})
+	c.RunUntil(3 * time.Second)
+	if len(got) != 4 || got[0] != 1 || got[1] != 2 || got[2] != 4 || got[3] != 3 {
+		t.Fatal("events ran out of order:", got)
+	}
+	if c.Now() != 3*time.Second {
+		t.Fatal("clock did not advance to the end of the run:", c.Now())
+	}
+}
+
+func Test_simulation_flood_line(t *testing.T) {
+	n := line(t, 1)
+	defer n.Stop()
+
+	id, err := n.Send("a", "d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+
+	r := n.Report()
+	t.Log(r)
+	if !n.Delivered(id) || r.DeliveryRatio != 1 {
+		t.Fatal("message was not delivered across the line")
+	}
+	if r.Duplicates != 0 {
+		t.Fatal("router delivered duplicates:", r.Duplicates)
+	}
+	if r.LinkBytes["a->b"] == 0 || r.LinkBytes["c->d"] == 0 {
+		t.Fatal("link bytes were not counted")
+	}
+	if r.MaxLatency > 10*time.Second || r.MinLatency <= 0 {
+		t.Fatal("latency out of range:", r.MinLatency, r.MaxLatency)
+	}
+}
+
+func Test_simulation_channel(t *testing.T) {
+	n := line(t, 2)
+	defer n.Stop()
+
+	if err := n.AddChannel("sim", "a", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.SendChannel("a", "sim", 64); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+
+	r := n.Report()
+	if r.Expected != 2 || r.Delivered != 2 {
+		t.Fatal("channel message not delivered to all members:", r.Delivered, "of", r.Expected)
+	}
+}
+
+func Test_simulation_partition_heal(t *testing.T) {
+	n := line(t, 3)
+	defer n.Stop()
+
+	n.Partition([]string{"a", "b"}, []string{"c", "d"})
+	id, err := n.Send("a", "d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if n.Delivered(id) {
+		t.Fatal("message crossed a partition")
+	}
+	if n.Report().PollErrors == 0 {
+		t.Fatal("expected poll errors across the partition")
+	}
+
+	n.Heal()
+	n.Run(20 * time.Second)
+	if !n.Delivered(id) {
+		t.Fatal("message was not delivered after healing")
+	}
+	if lat := n.Report().MinLatency; lat < 10*time.Second {
+		t.Fatal("latency should include the partition:", lat)
+	}
+}
+
+func Test_simulation_crash_recover(t *testing.T) {
+	n := line(t, 4)
+	defer n.Stop()
+
+	n.Crash("c")
+	id, err := n.Send("a", "d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(5 * time.Second)
+	if n.Delivered(id) {
+		t.Fatal("message passed through a crashed node")
+	}
+	n.Recover("c")
+	n.Run(15 * time.Second)
+	if !n.Delivered(id) {
+		t.Fatal("message was not delivered after recovery")
+	}
+}
+
+func Test_simulation_peer_identity(t *testing.T) {
+	n := New(5)
+	defer n.Stop()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := n.AddNode(name, 500, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Connect("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	// a expects b to present c's routing key
+	wrong, _ := n.Node("c").Node.ID()
+	if err := n.Node("a").Node.SetPeerIdentity("b", wrong.ToB64(), ""); err != nil {
+		t.Fatal(err)
+	}
+	id, err := n.Send("a", "b", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(5 * time.Second)
+	if n.Delivered(id) || n.Report().PollErrors == 0 {
+		t.Fatal("peer with the wrong routing key was polled")
+	}
+
+	right, _ := n.Node("b").Node.ID()
+	if err := n.Node("a").Node.SetPeerIdentity("b", right.ToB64(), ""); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if !n.Delivered(id) {
+		t.Fatal("message not delivered once the right routing key was pinned")
+	}
+}
+
+func Test_simulation_versions(t *testing.T) {
+	n := New(6)
+	defer n.Stop()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := n.AddNode(name, 500, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Connect("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(2 * time.Second)
+	local := api.PeerVersion{Version: api.ProtocolVersion, Caps: api.LocalCapabilities}
+	a, b := n.Node("a").Node, n.Node("b").Node
+	apub, _ := a.ID()
+	bpub, _ := b.ID()
+	if a.PeerVersions().Get(bpub.ToB64()) != local || b.PeerVersions().Get(apub.ToB64()) != local {
+		t.Fatal("versions not recorded once the challenges were answered")
+	}
+
+	// a caller claiming a's routing key cannot change the version b keeps for a
+	fake := api.VersionToArgs(api.PeerVersion{})
+	call := api.RemoteCall{Action: api.Version, Args: append(fake, apub)}
+	if _, err := b.PublicRPC(n.Node("c").Transport, call); err != nil {
+		t.Fatal(err)
+	}
+	call.Args = append(fake, apub, []byte{}, make([]byte, 32))
+	if _, err := b.PublicRPC(n.Node("c").Transport, call); err != nil {
+		t.Fatal(err)
+	}
+	if b.PeerVersions().Get(apub.ToB64()) != local || n.Node("c").Node.PeerVersions().Get(apub.ToB64()) != (api.PeerVersion{}) {
+		t.Fatal("unanswered Version call changed a recorded version")
+	}
+}
+
+func Test_simulation_stamps(t *testing.T) {
+	n := line(t, 6)
+	defer n.Stop()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := n.Node(name).Node.SetConfig(api.ConfigStampDifficulty, "8"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := n.Node("b")
+	unstamped := api.RemoteCall{Action: api.Dropoff, Args: []interface{}{api.Bundle{Data: make([]byte, 100)}}}
+	if _, err := b.Node.PublicRPC(b.Transport, unstamped); err != stamp.ErrMissing {
+		t.Fatal("expected an unstamped Dropoff to be refused, got", err)
+	}
+	// a stamp is only accepted once
+	bpub, _ := b.Node.ID()
+	bundle := api.Bundle{Data: make([]byte, 100)}
+	var err error
+	if bundle.Stamp, err = stamp.Mint(bpub.ToB64(), bundle.Data, 8, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stamped := api.RemoteCall{Action: api.Dropoff, Args: []interface{}{bundle}}
+	if _, err := b.Node.PublicRPC(b.Transport, stamped); err == stamp.ErrReplayed {
+		t.Fatal("fresh stamp refused as a replay")
+	}
+	if _, err := b.Node.PublicRPC(b.Transport, stamped); err != stamp.ErrReplayed {
+		t.Fatal("expected a replayed stamp to be refused, got", err)
+	}
+
+	id, err := n.Send("a", "d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if !n.Delivered(id) || n.Report().PollErrors != 0 {
+		t.Fatal("stamped message was not delivered")
+	}
+}
+
+func Test_simulation_stamps_limit(t *testing.T) {
+	n := New(7)
+	defer n.Stop()
+	for _, name := range []string{"a", "b"} {
+		if _, err := n.AddNode(name, 500, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Connect("a", "b"); err != nil { // only a polls, so a has to stamp its Dropoff
+		t.Fatal(err)
+	}
+	if err := n.Node("b").Node.SetConfig(api.ConfigStampDifficulty, "12"); err != nil {
+		t.Fatal(err)
+	}
+	// a will not mint for more than 8 bits
+	if err := n.Node("a").Node.SetConfig(api.ConfigMaxStampDifficulty, "8"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := n.Send("a", "b", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(5 * time.Second)
+	if n.Delivered(id) || n.Report().PollErrors == 0 {
+		t.Fatal("a minted a stamp above its limit")
+	}
+	if err := n.Node("a").Node.SetConfig(api.ConfigMaxStampDifficulty, "12"); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if !n.Delivered(id) {
+		t.Fatal("message not delivered once the limit was raised")
+	}
+}
+
+func Test_simulation_hop_limit(t *testing.T) {
+	for _, c := range []struct {
+		hops      string
+		delivered bool
+	}{{"1", false}, {"2", true}} {
+		n := line(t, 7)
+		if err := n.Node("a").Node.SetConfig(api.ConfigHopLimit, c.hops); err != nil {
+			t.Fatal(err)
+		}
+		id, err := n.Send("a", "d", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run(10 * time.Second)
+		n.Stop()
+		if n.Delivered(id) != c.delivered {
+			t.Fatal("hop limit", c.hops, "delivered:", n.Delivered(id))
+		}
+	}
+}
+
+func Test_simulation_compression(t *testing.T) {
+	var linkBytes []int64
+	for _, alg := range []string{"", "deflate", "gzip"} {
+		n := line(t, 8)
+		if err := n.Node("a").Node.SetConfig(api.ConfigCompression, alg); err != nil {
+			t.Fatal(err)
+		}
+		id, err := n.Send("a", "d", 4000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run(10 * time.Second)
+		n.Stop()
+		if !n.Delivered(id) {
+			t.Fatal("message not delivered with compression", alg)
+		}
+		linkBytes = append(linkBytes, n.Report().LinkBytes["a->b"])
+	}
+	if linkBytes[1] >= linkBytes[0] || linkBytes[2] >= linkBytes[0] {
+		t.Fatal("compression did not reduce link bytes:", linkBytes)
+	}
+}
+
+func Test_simulation_padding(t *testing.T) {
+	for _, mode := range []string{"bucket", "fixed"} {
+		var linkBytes []int64
+		for _, size := range []int{100, 200} {
+			n := line(t, 9)
+			for _, name := range []string{"a", "b", "c", "d"} {
+				s := n.Node(name)
+				s.Transport.SetByteLimit(64 * 1024)
+				if err := s.Node.SetConfig(api.ConfigPadding, mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			id, err := n.Send("a", "d", size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Run(10 * time.Second)
+			n.Stop()
+			if !n.Delivered(id) || n.Report().Unexpected != 0 {
+				t.Fatal("padded message not delivered intact with", mode, "padding")
+			}
+			linkBytes = append(linkBytes, n.Report().LinkBytes["a->b"])
+		}
+		if linkBytes[0] != linkBytes[1] {
+			t.Fatal(mode, "padding leaked the message size:", linkBytes)
+		}
+	}
+}
+
+func Test_simulation_padding_limit(t *testing.T) {
+	for _, mode := range []string{"bucket", "fixed"} {
+		n := line(t, 14)
+		for _, name := range []string{"a", "b", "c", "d"} {
+			s := n.Node(name)
+			s.Transport.SetByteLimit(1024)
+			if err := s.Node.SetConfig(api.ConfigPadding, mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := n.AddChannel("padded-channel", "a", "d"); err != nil {
+			t.Fatal(err)
+		}
+		// padded messages, header and all, still fit the transport byte limit
+		var ids []uint64
+		for _, size := range []int{100, 850} {
+			id, err := n.Send("a", "d", size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			if id, err = n.SendChannel("a", "padded-channel", size); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		// and so do their onion layers, two of them leave 512 bytes of the 1024
+		id, err := n.SendRouted("a", "d", 500, "b", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		n.Run(10 * time.Second)
+		n.Stop()
+		for _, id := range ids {
+			if !n.Delivered(id) || n.Report().PollErrors != 0 {
+				t.Fatal("padded message did not fit the byte limit with", mode, "padding:", n.Report())
+			}
+		}
+	}
+}
+
+func Test_simulation_cover(t *testing.T) {
+	var linkBytes []int64
+	for _, rate := range []float64{0, 4} {
+		n := line(t, 10)
+		for _, name := range []string{"a", "b", "c", "d"} {
+			n.Node(name).Policy.Cover = &policy.Cover{Mode: policy.CoverConstant, Rate: rate, Size: 100}
+		}
+		id, err := n.Send("a", "d", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run(10 * time.Second)
+		n.Stop()
+		r := n.Report()
+		if !n.Delivered(id) || r.Unexpected != 0 || r.PollErrors != 0 {
+			t.Fatal("cover traffic at rate", rate, "disturbed delivery:", r)
+		}
+		linkBytes = append(linkBytes, r.LinkBytes["c->d"])
+	}
+	if linkBytes[1] <= linkBytes[0] {
+		t.Fatal("no cover traffic was sent:", linkBytes)
+	}
+}
+
+func Test_simulation_onion(t *testing.T) {
+	n := line(t, 11)
+	defer n.Stop()
+	id, err := n.SendRouted("a", "d", 100, "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a relay that is not connected to anyone can never peel its layer
+	if _, err := n.AddNode("x", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := n.SendRouted("a", "d", 100, "b", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// layers are only handed to their relay, b does not pass on a layer for c
+	skipped, err := n.SendRouted("a", "d", 100, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Run(10 * time.Second)
+	if !n.Delivered(id) {
+		t.Fatal("onion routed message was not delivered")
+	}
+	if n.Delivered(lost) {
+		t.Fatal("message delivered without being peeled by its last relay")
+	}
+	if n.Delivered(skipped) {
+		t.Fatal("onion layer flooded past a relay it was not for")
+	}
+}
+
+func Test_simulation_channel_tags(t *testing.T) {
+	n := line(t, 13)
+	defer n.Stop()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := n.Node(name).Node.SetConfig(api.ConfigChannelTags, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.AddChannel("sim", "a", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.SendChannel("a", "sim", 64); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if r := n.Report(); r.Expected != 2 || r.Delivered != 2 {
+		t.Fatal("tagged channel message not delivered to all members:", r.Delivered, "of", r.Expected)
+	}
+
+	// b is not a member and forwards the message as it arrived
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	bundle, err := n.Node("b").Node.Pickup(key.GetPubKey(), 0, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clear, err := api.DecryptMessage(key, bundle.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := api.BytesBytesFromBytes(&clear)
+	if err != nil || len(*msgs) == 0 {
+		t.Fatal("relay forwarded nothing", err)
+	}
+	for _, m := range *msgs {
+		h, err := api.ParseMsgHeader(m)
+		if err != nil || h.Name == "sim" || h.Flags&api.TaggedFlag == 0 {
+			t.Fatalf("relay saw the channel name: %+v %v", h, err)
+		}
+	}
+}
+
+func Test_simulation_mix(t *testing.T) {
+	for _, c := range []struct {
+		node string
+		mix  *router.Mix
+	}{{"b", &router.Mix{Threshold: 3}}, {"a", &router.Mix{Threshold: 3, Local: true}}} {
+		n := line(t, 14)
+		n.Node(c.node).Node.Router().(*router.DefaultRouter).Mix = c.mix
+		var ids []uint64
+		for i := 0; i < 2; i++ {
+			id, err := n.Send("a", "d", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		n.Run(10 * time.Second)
+		if n.Delivered(ids[0]) || n.Delivered(ids[1]) {
+			t.Fatal("message left the mix pool of", c.node, "below its threshold")
+		}
+		if s := c.mix.Stats(); s.Pooled != 2 {
+			t.Fatalf("wrong pool occupancy at %s: %+v", c.node, s)
+		}
+		id, err := n.Send("a", "d", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		n.Run(20 * time.Second)
+		n.Stop()
+		for _, id := range ids {
+			if !n.Delivered(id) {
+				t.Fatal("message not delivered after the mix pool of", c.node, "filled")
+			}
+		}
+	}
+}
+
+// drain - returns the events buffered in a subscription so far
+func drain(sub *api.Subscription) []api.Event {
+	var evs []api.Event
+	for {
+		select {
+		case e := <-sub.Events():
+			evs = append(evs, e)
+		default:
+			return evs
+		}
+	}
+}
+
+func Test_simulation_events(t *testing.T) {
+	n := line(t, 15)
+	if err := n.Node("a").Node.SetConfig(api.ConfigHopLimit, "1"); err != nil {
+		t.Fatal(err)
+	}
+	typed := api.EventFilter{Types: []api.EventType{api.MessageDelivered, api.MessageDropped, api.PeerPollSucceeded}}
+	subB := n.Node("b").Node.EventBus().Subscribe(1024, typed)
+	subC := n.Node("c").Node.EventBus().Subscribe(1024, typed)
+	if _, err := n.Send("a", "b", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Send("a", "d", 100); err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	n.Stop()
+
+	delivered, polled := false, false
+	for _, e := range drain(subB) {
+		switch e.Type {
+		case api.MessageDelivered:
+			if p, ok := e.Payload.(api.MessageEvent); !ok || p.Channel != "" || p.Size != 100 {
+				t.Fatalf("wrong MessageDelivered payload: %+v", e.Payload)
+			}
+			delivered = true
+		case api.PeerPollSucceeded:
+			polled = true
+		}
+	}
+	if !delivered || !polled {
+		t.Fatal("missing events at b, delivered:", delivered, "polled:", polled)
+	}
+	dropped := false
+	for _, e := range drain(subC) {
+		if p, ok := e.Payload.(api.DropEvent); ok && e.Type == api.MessageDropped && p.Reason == api.DropHopLimit {
+			dropped = true
+		}
+	}
+	if !dropped {
+		t.Fatal("no MessageDropped event where the hop limit ran out")
+	}
+}
+
+func Test_simulation_metrics(t *testing.T) {
+	consumed := metrics.Default.Value(metrics.RouterMessages, "decision", metrics.DecisionConsumed)
+	forwarded := metrics.Default.Value(metrics.RouterMessages, "decision", metrics.DecisionForwarded)
+	polls := metrics.Default.Value(metrics.Polls, "result", "ok")
+
+	n := line(t, 12)
+	id, err := n.Send("a", "d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run(10 * time.Second)
+	if !n.Delivered(id) {
+		n.Stop()
+		t.Fatal("message was not delivered")
+	}
+	key, err := n.Node("a").Node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nodeSeries(metrics.OutboxMessages, key.ToB64()); !ok {
+		t.Fatal("no outbox depth series for a running node")
+	}
+	if _, ok := nodeSeries(metrics.StreamsPending, key.ToB64()); !ok {
+		t.Fatal("no pending streams series for a running node")
+	}
+	if metrics.Default.Value(metrics.PeerTXBytes, "node", key.ToB64(), "peer", n.address("a", "b")) <= 0 {
+		t.Fatal("bytes sent to a peer were not counted for the node")
+	}
+	n.Stop()
+
+	if metrics.Default.Value(metrics.RouterMessages, "decision", metrics.DecisionConsumed) <= consumed {
+		t.Fatal("consumed messages were not counted")
+	}
+	if metrics.Default.Value(metrics.RouterMessages, "decision", metrics.DecisionForwarded) <= forwarded {
+		t.Fatal("forwarded messages were not counted")
+	}
+	if metrics.Default.Value(metrics.Polls, "result", "ok") <= polls {
+		t.Fatal("polls were not counted")
+	}
+	if _, ok := nodeSeries(metrics.OutboxMessages, key.ToB64()); ok {
+		t.Fatal("outbox depth series left behind by a stopped node")
+	}
+	if _, ok := nodeSeries(metrics.StreamsPending, key.ToB64()); ok {
+		t.Fatal("pending streams series left behind by a stopped node")
+	}
+}
+
+// nodeSeries - returns the line for one node's gauge in the default registry's text output
+func nodeSeries(name, node string) (string, bool) {
+	var buf bytes.Buffer
+	metrics.Default.WriteText(&buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, name+`{node="`+node+`"}`) {
+			return line, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,98 @@
+package simulation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// Transport : in-memory Transport that delivers RPCs between the nodes of a simulated Network
+type Transport struct {
+	network   *Network
+	name      string // name of the simulated node that owns this transport
+	byteLimit int64
+}
+
+// Name : Returns this module's common name, which should be unique
+func (*Transport) Name() string {
+	return "sim"
+}
+
+// ByteLimit - get limit on bytes per bundle for this transport
+func (t *Transport) ByteLimit() int64 { return t.byteLimit }
+
+// SetByteLimit - set limit on bytes per bundle for this transport
+func (t *Transport) SetByteLimit(limit int64) { t.byteLimit = limit }
+
+// Listen : no-op, simulated nodes are always reachable through the Network unless crashed or partitioned
+func (t *Transport) Listen(listen string, adminMode bool) {}
+
+// Stop : no-op
+func (t *Transport) Stop() {}
+
+// RPC : client interface, the call and response are serialized exactly as a real transport would
+func (t *Transport) RPC(host string, method api.Action, args ...interface{}) (interface{}, error) {
+	from, to, err := t.network.parseAddress(host)
+	if err != nil {
+		return nil, err
+	}
+	if from != t.name {
+		return nil, errors.New("Simulated address does not belong to this transport")
+	}
+	if !t.network.reachable(from, to) {
+		return nil, errors.New("Simulated host unreachable: " + to)
+	}
+	target := t.network.nodes[to]
+
+	var a api.RemoteCall
+	a.Action = method
+	a.Args = args
+	rbytes := api.RemoteCallToBytes(&a)
+	t.network.countBytes(from, to, len(*rbytes))
+
+	call, err := api.RemoteCallFromBytes(rbytes)
+	if err != nil {
+		return nil, err
+	}
+	result, err := target.Node.PublicRPC(target.Transport, *call)
+
+	rr := api.RemoteResponse{}
+	if err != nil {
+		rr.Error = err.Error()
+	}
+	if result != nil {
+		rr.Value = result
+	}
+	respBytes := api.RemoteResponseToBytes(&rr)
+	t.network.countBytes(to, from, len(*respBytes))
+
+	resp, err := api.RemoteResponseFromBytes(respBytes)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsErr() {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.IsNil() {
+		return nil, nil
+	}
+	return resp.Value, nil
+}
+
+// address - builds the host string used by "from" to reach "to"
+// PollServer keeps its peer state by host, so each directed link gets its own address
+func (n *Network) address(from, to string) string {
+	return n.id + "/" + from + "/" + to
+}
+
+func (n *Network) parseAddress(host string) (string, string, error) {
+	parts := strings.Split(host, "/")
+	if len(parts) != 3 || parts[0] != n.id {
+		return "", "", errors.New("Invalid simulated address: " + host)
+	}
+	if _, ok := n.nodes[parts[2]]; !ok {
+		return "", "", errors.New("Unknown simulated node: " + parts[2])
+	}
+	return parts[1], parts[2], nil
+}
@@ -0,0 +1,13 @@
+// +build !no_json
+
+package simulation
+
+import "encoding/json"
+
+// MarshalJSON : Create a serialied representation of the config of this module
+func (t *Transport) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Transport": "sim",
+		"Node":      t.name,
+	})
+}
@@ -0,0 +1,169 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// Fingerprint : Returns the hex-encoded SHA-256 hash of a DER-encoded certificate
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// FingerprintPEM : Returns the fingerprint of the first certificate in a PEM block
+func FingerprintPEM(certPem []byte) (string, error) {
+	for {
+		var block *pem.Block
+		block, certPem = pem.Decode(certPem)
+		if block == nil {
+			return "", errors.New("No certificate found in PEM")
+		}
+		if block.Type == "CERTIFICATE" {
+			return Fingerprint(block.Bytes), nil
+		}
+	}
+}
+
+// NormalizeFingerprint : lowercases a fingerprint and strips the colons and spaces some tools print
+func NormalizeFingerprint(fp string) string {
+	fp = strings.ToLower(fp)
+	fp = strings.Replace(fp, ":", "", -1)
+	return strings.Replace(fp, " ", "", -1)
+}
+
+// ClientVerifier : Checks client certificates against a CA pool and a list of pinned fingerprints
+//
+// a certificate is accepted if it chains to one of the CAs or if its fingerprint is pinned
+type ClientVerifier struct {
+	roots *x509.CertPool
+	pins  map[string]bool
+
+	// OnReject : called with the reason whenever a client certificate is rejected
+	OnReject func(err error)
+}
+
+// NewClientVerifier : Makes a ClientVerifier from PEM-encoded CA certificates and hex SHA-256 fingerprints
+//
+// returns nil if neither CAs nor pins are configured, meaning client certificates are not required
+func NewClientVerifier(caPem []byte, pins []string) (*ClientVerifier, error) {
+	if len(caPem) == 0 && len(pins) == 0 {
+		return nil, nil
+	}
+	v := new(ClientVerifier)
+	if len(caPem) > 0 {
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(caPem) {
+			return nil, errors.New("No client CA certificates found in PEM")
+		}
+	}
+	v.pins = make(map[string]bool)
+	for _, pin := range pins {
+		v.pins[NormalizeFingerprint(pin)] = true
+	}
+	return v, nil
+}
+
+// Configure : makes a server tls.Config request client certificates and check them with this verifier
+func (v *ClientVerifier) Configure(conf *tls.Config) {
+	// RequestClientCert rather than RequireAnyClientCert, so that connections without
+	// a certificate still reach VerifyPeerCertificate and get reported
+	conf.ClientAuth = tls.RequestClientCert
+	conf.VerifyPeerCertificate = v.VerifyPeerCertificate
+}
+
+// VerifyPeerCertificate : tls.Config callback
+func (v *ClientVerifier) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	err := v.verify(rawCerts)
+	if err != nil && v.OnReject != nil {
+		v.OnReject(err)
+	}
+	return err
+}
+
+func (v *ClientVerifier) verify(rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("client did not provide a certificate")
+	}
+	fp := Fingerprint(rawCerts[0])
+	if v.pins[fp] {
+		return nil
+	}
+	if v.roots != nil {
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, raw := range rawCerts[1:] {
+			if c, err := x509.ParseCertificate(raw); err == nil {
+				intermediates.AddCert(c)
+			}
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         v.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err == nil {
+			return nil
+		}
+		return errors.New("client certificate " + fp + " not trusted: " + err.Error())
+	}
+	return errors.New("client certificate " + fp + " is not pinned")
+}
+
+// PeerFingerprint : Returns the fingerprint of the leaf certificate of a TLS connection, or "" if there is none
+func PeerFingerprint(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return Fingerprint(state.PeerCertificates[0].Raw)
+}
+
+// Pins : expected server certificate fingerprints by host, safe for concurrent use
+type Pins struct {
+	mu   sync.RWMutex
+	pins map[string]string
+}
+
+// Set : pins a fingerprint for host, "" removes the pin, returns true if the pin changed
+func (p *Pins) Set(host, fingerprint string) bool {
+	fingerprint = NormalizeFingerprint(fingerprint)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pins == nil {
+		p.pins = make(map[string]string)
+	}
+	if p.pins[host] == fingerprint {
+		return false
+	}
+	if fingerprint == "" {
+		delete(p.pins, host)
+	} else {
+		p.pins[host] = fingerprint
+	}
+	return true
+}
+
+// Check : returns api.ErrPeerIdentity if host is pinned and the connection presented a different certificate
+func (p *Pins) Check(host string, state *tls.ConnectionState) error {
+	p.mu.RLock()
+	expected, ok := p.pins[host]
+	p.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if PeerFingerprint(state) != expected {
+		return api.ErrPeerIdentity
+	}
+	return nil
+}
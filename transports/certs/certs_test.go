@@ -0,0 +1,164 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+)
+
+func makeCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake : runs a TLS handshake over a pipe, returns the server side error
+func handshake(t *testing.T, v *ClientVerifier, client *tls.Certificate) error {
+	certPem, keyPem, err := bc.GenerateSSLCertBytes(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConf := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	v.Configure(serverConf)
+	clientConf := &tls.Config{InsecureSkipVerify: true}
+	if client != nil {
+		clientConf.Certificates = []tls.Certificate{*client}
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		tls.Client(c, clientConf).Handshake()
+		c.Close()
+	}()
+	return tls.Server(s, serverConf).Handshake()
+}
+
+func Test_NewClientVerifier_disabled(t *testing.T) {
+	v, err := NewClientVerifier(nil, nil)
+	if err != nil || v != nil {
+		t.Fatal("verifier should be nil when nothing is configured")
+	}
+	if _, err := NewClientVerifier([]byte("not a pem"), nil); err == nil {
+		t.Fatal("expected an error for a bad CA PEM")
+	}
+}
+
+func Test_ClientVerifier_pins(t *testing.T) {
+	pinned, _, pinnedCert := makeCert(t, "pinned", false, nil, nil)
+	_, _, otherCert := makeCert(t, "other", false, nil, nil)
+
+	// pins are accepted in the colon-separated uppercase form too
+	fp := Fingerprint(pinned.Raw)
+	var colons string
+	for i := 0; i < len(fp); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += fp[i : i+2]
+	}
+	v, err := NewClientVerifier(nil, []string{colons})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejects := 0
+	v.OnReject = func(error) { rejects++ }
+
+	if err := handshake(t, v, &pinnedCert); err != nil {
+		t.Fatal("pinned client rejected:", err)
+	}
+	if err := handshake(t, v, &otherCert); err == nil {
+		t.Fatal("unpinned client accepted")
+	}
+	if err := handshake(t, v, nil); err == nil {
+		t.Fatal("client without certificate accepted")
+	}
+	if rejects != 2 {
+		t.Fatal("expected 2 rejections, got", rejects)
+	}
+}
+
+func Test_ClientVerifier_ca(t *testing.T) {
+	ca, caKey, _ := makeCert(t, "ca", true, nil, nil)
+	_, _, leafCert := makeCert(t, "leaf", false, ca, caKey)
+	_, _, selfSigned := makeCert(t, "self", false, nil, nil)
+
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	v, err := NewClientVerifier(caPem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, v, &leafCert); err != nil {
+		t.Fatal("client signed by CA rejected:", err)
+	}
+	if err := handshake(t, v, &selfSigned); err == nil {
+		t.Fatal("self-signed client accepted")
+	}
+
+	fp, err := FingerprintPEM(caPem)
+	if err != nil || fp != Fingerprint(ca.Raw) {
+		t.Fatal("FingerprintPEM mismatch:", fp, err)
+	}
+}
+
+func Test_Pins(t *testing.T) {
+	cert, _, _ := makeCert(t, "server", false, nil, nil)
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	var pins Pins
+	if err := pins.Check("host:443", state); err != nil {
+		t.Fatal("unpinned host should pass:", err)
+	}
+	if !pins.Set("host:443", "00") {
+		t.Fatal("Set should report a change")
+	}
+	if err := pins.Check("host:443", state); err == nil {
+		t.Fatal("wrong certificate accepted")
+	}
+	pins.Set("host:443", strings.ToUpper(Fingerprint(cert.Raw)))
+	if err := pins.Check("host:443", state); err != nil {
+		t.Fatal("pinned certificate rejected:", err)
+	}
+	if !pins.Set("host:443", "") || pins.Set("host:443", "") {
+		t.Fatal("removing a pin should only report a change once")
+	}
+}
@@ -0,0 +1,32 @@
+// +build !no_json
+
+package certs
+
+import (
+	"errors"
+)
+
+// ClientAuthFromMap : Reads "ClientCAs" (PEM) and "ClientPins" (hex SHA-256 fingerprints) from a transport's JSON config
+func ClientAuthFromMap(t map[string]interface{}) (cas []byte, pins []string, err error) {
+	if v, ok := t["ClientCAs"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, nil, errors.New("ClientCAs must be a PEM string")
+		}
+		cas = []byte(s)
+	}
+	if v, ok := t["ClientPins"]; ok && v != nil {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, nil, errors.New("ClientPins must be a list of fingerprints")
+		}
+		for _, p := range list {
+			pin, ok := p.(string)
+			if !ok {
+				return nil, nil, errors.New("ClientPins must be a list of fingerprints")
+			}
+			pins = append(pins, pin)
+		}
+	}
+	return cas, pins, nil
+}
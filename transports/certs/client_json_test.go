@@ -0,0 +1,23 @@
+// +build !no_json
+
+package certs
+
+import (
+	"testing"
+)
+
+func Test_ClientAuthFromMap(t *testing.T) {
+	cas, pins, err := ClientAuthFromMap(map[string]interface{}{"ClientCAs": "pem", "ClientPins": []interface{}{"ab", "cd"}})
+	if err != nil || string(cas) != "pem" || len(pins) != 2 || pins[1] != "cd" {
+		t.Fatal("ClientAuthFromMap returned", string(cas), pins, err)
+	}
+	for _, bad := range []map[string]interface{}{
+		{"ClientCAs": 1.0},
+		{"ClientPins": "ab"},
+		{"ClientPins": []interface{}{"ab", true}},
+	} {
+		if _, _, err := ClientAuthFromMap(bad); err == nil {
+			t.Fatalf("ClientAuthFromMap accepted %v", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+)
+
+// Provider : source of the certificate a listener presents, which may change while the listener is running
+type Provider interface {
+	// Certificate : returns the current certificate
+	Certificate() (*tls.Certificate, error)
+	// Start : begins watching for changes, onReload is called after every reload attempt with its result
+	Start(onReload func(error))
+	// Stop : stops watching for changes
+	Stop()
+}
+
+// GetCertificate : adapts a Provider to tls.Config.GetCertificate
+func GetCertificate(p Provider) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return p.Certificate()
+	}
+}
+
+// DefaultFileInterval : how often a FileProvider checks its files when no interval is configured
+const DefaultFileInterval = time.Minute
+
+// DefaultRotateInterval : how often a SelfSignedProvider re-issues its certificate when no interval is configured
+const DefaultRotateInterval = 24 * time.Hour
+
+// ticker : the reload loop shared by the providers
+type ticker struct {
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	interval time.Duration
+}
+
+// Certificate : returns the current certificate
+func (t *ticker) Certificate() (*tls.Certificate, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cert == nil {
+		return nil, errors.New("No certificate loaded")
+	}
+	return t.cert, nil
+}
+
+func (t *ticker) set(cert *tls.Certificate) {
+	t.mu.Lock()
+	t.cert = cert
+	t.mu.Unlock()
+}
+
+func (t *ticker) start(reload func() (bool, error), onReload func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop != nil {
+		return // already running
+	}
+	t.stop = make(chan struct{})
+	stop := t.stop
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		tick := time.NewTicker(t.interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				changed, err := reload()
+				if (changed || err != nil) && onReload != nil {
+					onReload(err)
+				}
+			}
+		}
+	}()
+}
+
+// Interval : returns how often the certificate is checked or re-issued
+func (t *ticker) Interval() time.Duration {
+	return t.interval
+}
+
+// Stop : stops watching for changes
+func (t *ticker) Stop() {
+	t.mu.Lock()
+	stop := t.stop
+	t.stop = nil
+	t.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		t.wg.Wait()
+	}
+}
+
+// FileProvider : reloads a PEM certificate and key from disk whenever either file is modified
+type FileProvider struct {
+	ticker
+	CertFile, KeyFile string
+
+	certMod, keyMod time.Time
+}
+
+// NewFileProvider : loads the certificate and key files, which will be checked for changes every interval once started
+func NewFileProvider(certFile, keyFile string, interval time.Duration) (*FileProvider, error) {
+	p := &FileProvider{CertFile: certFile, KeyFile: keyFile}
+	p.interval = interval
+	if p.interval <= 0 {
+		p.interval = DefaultFileInterval
+	}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Start : begins watching the files for changes
+func (p *FileProvider) Start(onReload func(error)) {
+	p.start(p.reload, onReload)
+}
+
+func (p *FileProvider) reload() (bool, error) {
+	ci, err := os.Stat(p.CertFile)
+	if err != nil {
+		return false, err
+	}
+	ki, err := os.Stat(p.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	if ci.ModTime().Equal(p.certMod) && ki.ModTime().Equal(p.keyMod) {
+		return false, nil
+	}
+	certPem, err := ioutil.ReadFile(p.CertFile)
+	if err != nil {
+		return false, err
+	}
+	keyPem, err := ioutil.ReadFile(p.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		// the files may be mid-update, keep serving the old certificate and retry next time
+		return false, err
+	}
+	p.certMod, p.keyMod = ci.ModTime(), ki.ModTime()
+	p.set(&cert)
+	return true, nil
+}
+
+// SelfSignedProvider : re-issues a self-signed certificate on a schedule
+type SelfSignedProvider struct {
+	ticker
+	EccMode bool
+}
+
+// NewSelfSignedProvider : issues a self-signed certificate, which will be replaced every interval once started
+func NewSelfSignedProvider(eccMode bool, interval time.Duration) (*SelfSignedProvider, error) {
+	p := &SelfSignedProvider{EccMode: eccMode}
+	p.interval = interval
+	if p.interval <= 0 {
+		p.interval = DefaultRotateInterval
+	}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Start : begins re-issuing the certificate
+func (p *SelfSignedProvider) Start(onReload func(error)) {
+	p.start(p.reload, onReload)
+}
+
+func (p *SelfSignedProvider) reload() (bool, error) {
+	certPem, keyPem, err := bc.GenerateSSLCertBytes(p.EccMode)
+	if err != nil {
+		return false, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return false, err
+	}
+	p.set(&cert)
+	return true, nil
+}
@@ -0,0 +1,39 @@
+// +build !no_json
+
+package certs
+
+import (
+	"time"
+)
+
+// ProviderFromMap : Makes a Provider from a transport's JSON config, or returns nil if none is configured
+// "CertFile" and "KeyFile" select a FileProvider, "RotateSelfSigned" a SelfSignedProvider,
+// and "CertIntervalSeconds" sets how often either one reloads.
+func ProviderFromMap(t map[string]interface{}, eccMode bool) (Provider, error) {
+	var interval time.Duration
+	if v, ok := t["CertIntervalSeconds"].(float64); ok {
+		interval = time.Duration(v) * time.Second
+	}
+	certFile, _ := t["CertFile"].(string)
+	keyFile, _ := t["KeyFile"].(string)
+	if certFile != "" || keyFile != "" {
+		return NewFileProvider(certFile, keyFile, interval)
+	}
+	if rotate, _ := t["RotateSelfSigned"].(bool); rotate {
+		return NewSelfSignedProvider(eccMode, interval)
+	}
+	return nil, nil
+}
+
+// ProviderToMap : Adds the config of a Provider to a transport's JSON config
+func ProviderToMap(p Provider, t map[string]interface{}) {
+	switch v := p.(type) {
+	case *FileProvider:
+		t["CertFile"] = v.CertFile
+		t["KeyFile"] = v.KeyFile
+		t["CertIntervalSeconds"] = int64(v.Interval() / time.Second)
+	case *SelfSignedProvider:
+		t["RotateSelfSigned"] = true
+		t["CertIntervalSeconds"] = int64(v.Interval() / time.Second)
+	}
+}
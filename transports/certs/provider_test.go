@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+)
+
+func writePair(t *testing.T, certFile, keyFile string, mod time.Time) string {
+	certPem, keyPem, err := bc.GenerateSSLCertBytes(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// set the mtimes explicitly, filesystem timestamps may be too coarse to see the rewrite
+	os.Chtimes(certFile, mod, mod)
+	os.Chtimes(keyFile, mod, mod)
+	fp, err := FingerprintPEM(certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func currentFingerprint(t *testing.T, p Provider) string {
+	cert, err := p.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Fingerprint(cert.Certificate[0])
+}
+
+func Test_FileProvider(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	fp1 := writePair(t, certFile, keyFile, time.Now().Add(-time.Hour))
+
+	p, err := NewFileProvider(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentFingerprint(t, p) != fp1 {
+		t.Fatal("initial certificate not loaded")
+	}
+	reloads := make(chan error, 10)
+	p.Start(func(err error) { reloads <- err })
+	defer p.Stop()
+
+	fp2 := writePair(t, certFile, keyFile, time.Now())
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificate was not reloaded")
+	}
+	if currentFingerprint(t, p) != fp2 {
+		t.Fatal("reloaded certificate not served")
+	}
+
+	// a broken key keeps the old certificate and reports the failure
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	select {
+	case err := <-reloads:
+		if err == nil {
+			t.Fatal("expected a reload error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload failure was not reported")
+	}
+	if currentFingerprint(t, p) != fp2 {
+		t.Fatal("old certificate should still be served")
+	}
+}
+
+func Test_SelfSignedProvider(t *testing.T) {
+	p, err := NewSelfSignedProvider(true, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp1 := currentFingerprint(t, p)
+	reloads := make(chan error, 10)
+	p.Start(func(err error) { reloads <- err })
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificate was not re-issued")
+	}
+	p.Stop()
+	if currentFingerprint(t, p) == fp1 {
+		t.Fatal("certificate did not change")
+	}
+}
@@ -3,15 +3,21 @@ package https
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
+	"github.com/awgh/ratnet/transports/certs"
+	"github.com/awgh/ratnet/transports/limiter"
 )
 
 // New : Makes a new instance of this transport module
@@ -23,8 +29,23 @@ func New(certPem []byte, keyPem []byte, node api.Node, eccMode bool) *Module {
 	web.node = node
 	web.EccMode = eccMode
 
+	clientConf := &tls.Config{InsecureSkipVerify: true, GetClientCertificate: web.clientCertificate}
 	web.transport = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: clientConf,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := &tls.Dialer{Config: clientConf}
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			state := conn.(*tls.Conn).ConnectionState()
+			if err := web.pins.Check(addr, &state); err != nil {
+				events.Warning(web.node, "https server "+addr+" presented certificate "+certs.PeerFingerprint(&state)+", which is not the pinned one")
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
 	}
 	web.client = &http.Client{
 		Timeout:   time.Second * 10,
@@ -47,7 +68,27 @@ type Module struct {
 	Cert, Key []byte
 	EccMode   bool
 
+	// Certs : when set, used instead of Cert and Key, and reloaded while the listener runs
+	Certs certs.Provider
+
+	// ClientCAs and ClientPins enable client certificate authentication in admin mode,
+	// clients must present a certificate signed by one of the PEM-encoded CAs or with a pinned SHA-256 fingerprint
+	ClientCAs  []byte
+	ClientPins []string
+
+	// pins : expected server certificate fingerprints, set by PollPeer from the Peer records
+	pins certs.Pins
+
+	// Auth : restricts admin callers, identified by bearer token or client certificate fingerprint, to the Actions of their role
+	Auth *api.Authorizer
+	// Token : bearer token sent with every RPC made by this module
+	Token string
+
+	// Limiter : rate limits public calls per source address and routing pubkey, nil for no limits
+	Limiter *limiter.Limiter
+
 	byteLimit int64
+	health    api.HealthTracker
 }
 
 // Name : Returns this module's common name, which should be unique
@@ -70,10 +111,24 @@ func (h *Module) Listen(listen string, adminMode bool) {
 	}
 
 	// init ssl components
-	cert, err := tls.X509KeyPair(h.Cert, h.Key)
-	if err != nil {
-		events.Error(h.node, err.Error())
-		return
+	conf := new(tls.Config)
+	if h.Certs != nil {
+		h.Certs.Start(func(err error) {
+			if err != nil {
+				events.Error(h.node, "https certificate reload failed: "+err.Error())
+			} else {
+				events.Info(h.node, "https certificate reloaded")
+			}
+		})
+		conf.GetCertificate = certs.GetCertificate(h.Certs)
+	} else {
+		cert, err := tls.X509KeyPair(h.Cert, h.Key)
+		if err != nil {
+			events.Error(h.node, err.Error())
+			h.health.ListenFailed(err)
+			return
+		}
+		conf.Certificates = []tls.Certificate{cert}
 	}
 
 	// build http handler
@@ -82,21 +137,46 @@ func (h *Module) Listen(listen string, adminMode bool) {
 		h.handleResponse(w, r, h.node, adminMode)
 	})
 
+	if adminMode {
+		verifier, err := certs.NewClientVerifier(h.ClientCAs, h.ClientPins)
+		if err != nil {
+			events.Error(h.node, err.Error())
+			h.health.ListenFailed(err)
+			return
+		}
+		if verifier != nil {
+			verifier.OnReject = func(err error) {
+				events.Warning(h.node, "https admin client rejected: "+err.Error())
+			}
+			verifier.Configure(conf)
+		}
+	}
+
 	h.server = &http.Server{
 		Addr:      listen,
-		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		TLSConfig: conf,
 		Handler:   serveMux,
 	}
 
 	// start
+	h.health.Listening(listen)
 	go func() {
 		if err := h.server.ListenAndServeTLS("", ""); err != nil {
 			events.Error(h.node, err.Error())
+			if err != http.ErrServerClosed {
+				h.health.ListenFailed(err)
+			}
 		}
 	}()
 	h.setIsRunning(true)
 }
 
+// Health : reports whether the listener is up and how the last RPC call went
+func (h *Module) Health() api.ComponentHealth { return h.health.Report(h.Name()) }
+
+// Authorizer : returns the Authorizer for admin calls, or nil
+func (h *Module) Authorizer() *api.Authorizer { return h.Auth }
+
 func (h *Module) handleResponse(w http.ResponseWriter, r *http.Request, node api.Node, adminMode bool) {
 	buf, err := api.ReadBuffer(r.Body)
 	if err != nil {
@@ -108,10 +188,19 @@ func (h *Module) handleResponse(w http.ResponseWriter, r *http.Request, node api
 		events.Warning(h.node, "https listen remote deserialize failed: "+err.Error())
 		return
 	}
+	// a bearer token takes precedence over the client certificate as the credential for admin calls
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		a.Credential = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		a.Credential = certs.PeerFingerprint(r.TLS)
+	}
 
 	var result interface{}
 	if adminMode {
 		result, err = node.AdminRPC(h, *a)
+	} else if err = h.Limiter.Allow(r.RemoteAddr, a, len(*buf)); err != nil {
+		events.Warning(h.node, fmt.Sprintf("https listen rejected call: %s (%d rejected)", err.Error(), h.Limiter.Rejected()))
+		w.WriteHeader(http.StatusTooManyRequests)
 	} else {
 		result, err = node.PublicRPC(h, *a)
 	}
@@ -134,6 +223,13 @@ func (h *Module) handleResponse(w http.ResponseWriter, r *http.Request, node api
 
 // RPC : client interface
 func (h *Module) RPC(host string, method api.Action, args ...interface{}) (interface{}, error) {
+	result, err := h.rpc(host, method, args...)
+	h.health.Record(err)
+	metrics.Default.Add(metrics.TransportCalls, 1, "transport", h.Name(), "action", method.String(), "result", metrics.Result(err))
+	return result, err
+}
+
+func (h *Module) rpc(host string, method api.Action, args ...interface{}) (interface{}, error) {
 	events.Info(h.node, fmt.Sprintf("\n***\n***RPC %d on %s called with: %+v\n***\n", method, host, args))
 
 	var a api.RemoteCall
@@ -151,6 +247,9 @@ func (h *Module) RPC(host string, method api.Action, args ...interface{}) (inter
 	writer.Flush()
 
 	req, _ := http.NewRequest("POST", "https://"+host, &bbuf)
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
 	resp, err := h.client.Do(req)
 	if err != nil {
 		events.Warning(h.node, "https RPC remote write failed: "+err.Error())
@@ -178,10 +277,36 @@ func (h *Module) RPC(host string, method api.Action, args ...interface{}) (inter
 	return rr.Value, nil
 }
 
+// PinCertificate : require host to present a certificate with this fingerprint, "" removes the pin
+func (h *Module) PinCertificate(host string, fingerprint string) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443") // connections are dialed, and checked, with the default port
+	}
+	if h.pins.Set(host, fingerprint) {
+		h.transport.CloseIdleConnections() // kept-alive connections were checked against the old pin
+	}
+}
+
+// clientCertificate : the certificate presented when a server asks for one
+func (h *Module) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if h.Certs != nil {
+		return h.Certs.Certificate()
+	}
+	cert, err := tls.X509KeyPair(h.Cert, h.Key)
+	if err != nil {
+		return new(tls.Certificate), nil // no certificate to send
+	}
+	return &cert, nil
+}
+
 // Stop : stops the HTTPS transport from running
 func (h *Module) Stop() {
+	if h.Certs != nil {
+		h.Certs.Stop()
+	}
 	h.server.Close()
 	h.setIsRunning(false)
+	h.health.Stopped()
 }
 
 // IsRunning - returns true if this node is running
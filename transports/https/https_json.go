@@ -7,6 +7,9 @@ import (
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/transports/certs"
+	"github.com/awgh/ratnet/transports/limiter"
 )
 
 func init() {
@@ -27,15 +30,49 @@ func NewFromMap(node api.Node, t map[string]interface{}) api.Transport {
 	if _, ok := t["EccMode"]; ok {
 		eccMode = t["EccMode"].(bool)
 	}
-	return New([]byte(certPem), []byte(keyPem), node, eccMode)
+	module := New([]byte(certPem), []byte(keyPem), node, eccMode)
+	provider, err := certs.ProviderFromMap(t, eccMode)
+	if err != nil {
+		events.Error(node, "https certificate config: "+err.Error())
+	} else if provider != nil {
+		module.Certs = provider
+	}
+	if module.ClientCAs, module.ClientPins, err = certs.ClientAuthFromMap(t); err != nil {
+		events.Error(node, "https client certificate config: "+err.Error())
+		module.Auth = api.NewAuthorizer() // fail closed, deny all admin calls
+	}
+	if auth, ok := t["Authorizer"].(map[string]interface{}); ok && module.Auth == nil {
+		a, err := api.AuthorizerFromMap(auth)
+		if err != nil {
+			events.Error(node, "https Authorizer config: "+err.Error())
+			a = api.NewAuthorizer() // fail closed, deny all admin calls
+		}
+		module.Auth = a
+	}
+	if _, ok := t["Token"]; ok {
+		module.Token = t["Token"].(string)
+	}
+	module.Limiter = limiter.FromMap(t)
+	return module
 }
 
 // MarshalJSON : Create a serialied representation of the config of this module
 func (h *Module) MarshalJSON() (b []byte, e error) {
-	return json.Marshal(map[string]interface{}{
-		"Transport": "https",
-		"Cert":      string(h.Cert),
-		"Key":       string(h.Key),
-		"EccMode":   h.EccMode,
-	})
+	m := map[string]interface{}{
+		"Transport":  "https",
+		"Cert":       string(h.Cert),
+		"Key":        string(h.Key),
+		"EccMode":    h.EccMode,
+		"ClientCAs":  string(h.ClientCAs),
+		"ClientPins": h.ClientPins,
+		"Token":      h.Token,
+	}
+	if h.Auth != nil {
+		m["Authorizer"] = h.Auth
+	}
+	if h.Certs != nil {
+		certs.ProviderToMap(h.Certs, m)
+	}
+	limiter.ToMap(h.Limiter, m)
+	return json.Marshal(m)
 }
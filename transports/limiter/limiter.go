@@ -0,0 +1,158 @@
+package limiter
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet/api"
+)
+
+// MaxKeys : buckets kept before idle ones are evicted, bounds memory under floods from many addresses
+const MaxKeys = 10000
+
+// Config : token-bucket rates for one key, a zero rate means that dimension is unlimited
+type Config struct {
+	CallsPerSecond float64
+	CallBurst      float64
+	BytesPerSecond float64
+	ByteBurst      float64
+}
+
+func (c Config) enabled() bool {
+	return c.CallsPerSecond > 0 || c.BytesPerSecond > 0
+}
+
+// Error : returned for a call rejected by the Limiter
+type Error struct {
+	Key   string // source address or routing pubkey that hit the limit
+	Limit string // "calls" or "bytes"
+}
+
+func (e *Error) Error() string {
+	return "rate limit exceeded: " + e.Limit + " for " + e.Key
+}
+
+type bucket struct {
+	calls, bytes float64
+	last         time.Time
+}
+
+// Limiter : token-bucket limits on PublicRPC calls, per source address and per routing pubkey
+type Limiter struct {
+	Source Config // applied per source address, any call
+	Pubkey Config // applied per routing pubkey, Pickup calls
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	rejected uint64
+
+	now func() time.Time
+}
+
+// New : Makes a new Limiter with the given per-source and per-pubkey rates
+func New(source, pubkey Config) *Limiter {
+	l := new(Limiter)
+	l.Source = source
+	l.Pubkey = pubkey
+	l.buckets = make(map[string]*bucket)
+	l.now = time.Now
+	return l
+}
+
+// Rejected : returns the number of calls rejected so far
+func (l *Limiter) Rejected() uint64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&l.rejected)
+}
+
+// Allow : charges a call of size bytes from remoteAddr, returns an *Error if it is over a limit
+// A nil Limiter allows everything.
+func (l *Limiter) Allow(remoteAddr string, call *api.RemoteCall, size int) error {
+	if l == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if err := l.take("addr:"+host, l.Source, size); err != nil {
+		return err
+	}
+	if call.Action == api.Pickup && len(call.Args) > 0 {
+		if rpk, ok := call.Args[0].(bc.PubKey); ok {
+			return l.take("pub:"+rpk.ToB64(), l.Pubkey, size)
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) take(key string, c Config, size int) error {
+	if !c.enabled() {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxKeys {
+			l.evict(now)
+		}
+		b = &bucket{calls: burst(c.CallBurst, c.CallsPerSecond), bytes: burst(c.ByteBurst, c.BytesPerSecond), last: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.calls = refill(b.calls, elapsed, c.CallsPerSecond, c.CallBurst)
+	b.bytes = refill(b.bytes, elapsed, c.BytesPerSecond, c.ByteBurst)
+
+	if c.CallsPerSecond > 0 && b.calls < 1 {
+		atomic.AddUint64(&l.rejected, 1)
+		return &Error{Key: key, Limit: "calls"}
+	}
+	if c.BytesPerSecond > 0 && b.bytes < float64(size) {
+		atomic.AddUint64(&l.rejected, 1)
+		return &Error{Key: key, Limit: "bytes"}
+	}
+	if c.CallsPerSecond > 0 {
+		b.calls--
+	}
+	if c.BytesPerSecond > 0 {
+		b.bytes -= float64(size)
+	}
+	return nil
+}
+
+// evict : drops buckets that have been idle long enough to be full again, or everything if that is not enough
+func (l *Limiter) evict(now time.Time) {
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, k)
+		}
+	}
+	if len(l.buckets) >= MaxKeys {
+		l.buckets = make(map[string]*bucket)
+	}
+}
+
+// burst : bucket capacity, defaults to one second worth of tokens
+func burst(b, rate float64) float64 {
+	if b > 0 {
+		return b
+	}
+	return rate
+}
+
+func refill(tokens, elapsed, rate, b float64) float64 {
+	tokens += elapsed * rate
+	if max := burst(b, rate); tokens > max {
+		tokens = max
+	}
+	return tokens
+}
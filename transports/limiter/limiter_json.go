@@ -0,0 +1,37 @@
+// +build !no_json
+
+package limiter
+
+// FromMap : Makes a Limiter from a transport's JSON config, or returns nil if none is configured
+// "RateLimit" holds "Source" and "Pubkey" objects with the fields of Config.
+func FromMap(t map[string]interface{}) *Limiter {
+	rl, ok := t["RateLimit"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return New(configFromMap(rl["Source"]), configFromMap(rl["Pubkey"]))
+}
+
+// ToMap : Adds the config of a Limiter to a transport's JSON config
+func ToMap(l *Limiter, t map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	t["RateLimit"] = map[string]interface{}{
+		"Source": l.Source,
+		"Pubkey": l.Pubkey,
+	}
+}
+
+func configFromMap(v interface{}) Config {
+	var c Config
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return c
+	}
+	c.CallsPerSecond, _ = m["CallsPerSecond"].(float64)
+	c.CallBurst, _ = m["CallBurst"].(float64)
+	c.BytesPerSecond, _ = m["BytesPerSecond"].(float64)
+	c.ByteBurst, _ = m["ByteBurst"].(float64)
+	return c
+}
@@ -0,0 +1,67 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+)
+
+func clock(l *Limiter) *time.Time {
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	return &now
+}
+
+func Test_Limiter_calls(t *testing.T) {
+	l := New(Config{CallsPerSecond: 2, CallBurst: 3}, Config{})
+	now := clock(l)
+	call := &api.RemoteCall{Action: api.ID}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("10.0.0.1:1234", call, 10); err != nil {
+			t.Fatal("call within burst rejected:", err)
+		}
+	}
+	err := l.Allow("10.0.0.1:5678", call, 10) // same host, other port
+	if e, ok := err.(*Error); !ok || e.Limit != "calls" {
+		t.Fatal("expected a calls limit error, got", err)
+	}
+	if err := l.Allow("10.0.0.2:1234", call, 10); err != nil {
+		t.Fatal("other source rejected:", err)
+	}
+	*now = now.Add(500 * time.Millisecond)
+	if err := l.Allow("10.0.0.1:1234", call, 10); err != nil {
+		t.Fatal("call after refill rejected:", err)
+	}
+	if l.Rejected() != 1 {
+		t.Fatal("expected 1 rejection, got", l.Rejected())
+	}
+}
+
+func Test_Limiter_bytes_pubkey(t *testing.T) {
+	l := New(Config{}, Config{BytesPerSecond: 100})
+	clock(l)
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	pickup := &api.RemoteCall{Action: api.Pickup, Args: []interface{}{key.GetPubKey(), int64(0)}}
+
+	if err := l.Allow("10.0.0.1:1", pickup, 80); err != nil {
+		t.Fatal(err)
+	}
+	// a different address does not escape the pubkey bucket
+	err := l.Allow("10.0.0.2:1", pickup, 80)
+	if e, ok := err.(*Error); !ok || e.Limit != "bytes" {
+		t.Fatal("expected a bytes limit error, got", err)
+	}
+	// calls that are not keyed by pubkey are unlimited here
+	if err := l.Allow("10.0.0.2:1", &api.RemoteCall{Action: api.Dropoff}, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilLimiter *Limiter
+	if nilLimiter.Allow("10.0.0.1:1", pickup, 1<<20) != nil {
+		t.Fatal("nil Limiter should allow everything")
+	}
+}
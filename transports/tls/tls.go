@@ -11,14 +11,43 @@ import (
 
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
+	"github.com/awgh/ratnet/transports/certs"
+	"github.com/awgh/ratnet/transports/limiter"
 )
 
-var cachedSessions map[string]*tls.Conn
+var (
+	cachedSessions map[string]*tls.Conn
+	sessionsMux    sync.Mutex
+)
 
 func init() {
 	cachedSessions = make(map[string]*tls.Conn)
 }
 
+func cachedSession(host string) (*tls.Conn, bool) {
+	sessionsMux.Lock()
+	defer sessionsMux.Unlock()
+	conn, ok := cachedSessions[host]
+	return conn, ok
+}
+
+func cacheSession(host string, conn *tls.Conn) {
+	sessionsMux.Lock()
+	cachedSessions[host] = conn
+	sessionsMux.Unlock()
+}
+
+// dropSession - removes conn from the cache, if it is still the session for host, and closes it
+func dropSession(host string, conn *tls.Conn) {
+	sessionsMux.Lock()
+	if cachedSessions[host] == conn {
+		delete(cachedSessions, host)
+	}
+	sessionsMux.Unlock()
+	_ = conn.Close()
+}
+
 // New : Makes a new instance of this transport module
 func New(certPem, keyPem []byte, node api.Node, eccMode bool) *Module {
 	tls := new(Module)
@@ -43,7 +72,25 @@ type Module struct {
 	Cert, Key []byte
 	EccMode   bool
 
+	// Certs : when set, used instead of Cert and Key, and reloaded while the listener runs
+	Certs certs.Provider
+
+	// ClientCAs and ClientPins enable client certificate authentication in admin mode,
+	// clients must present a certificate signed by one of the PEM-encoded CAs or with a pinned SHA-256 fingerprint
+	ClientCAs  []byte
+	ClientPins []string
+
+	// pins : expected server certificate fingerprints, set by PollPeer from the Peer records
+	pins certs.Pins
+
+	// Auth : restricts admin callers, identified by client certificate fingerprint, to the Actions of their role
+	Auth *api.Authorizer
+
+	// Limiter : rate limits public calls per source address and routing pubkey, nil for no limits
+	Limiter *limiter.Limiter
+
 	byteLimit int64
+	health    api.HealthTracker
 }
 
 // Name : Returns this module's common name, which should be unique
@@ -68,28 +115,57 @@ func (h *Module) Listen(listen string, adminMode bool) {
 	}
 
 	// init ssl components
-	cert, err := tls.X509KeyPair(h.Cert, h.Key)
-	if err != nil {
-		events.Error(h.node, err.Error())
-		return
+	conf := new(tls.Config)
+	if h.Certs != nil {
+		h.Certs.Start(func(err error) {
+			if err != nil {
+				events.Error(h.node, "tls certificate reload failed: "+err.Error())
+			} else {
+				events.Info(h.node, "tls certificate reloaded")
+			}
+		})
+		conf.GetCertificate = certs.GetCertificate(h.Certs)
+	} else {
+		cert, err := tls.X509KeyPair(h.Cert, h.Key)
+		if err != nil {
+			events.Error(h.node, err.Error())
+			h.health.ListenFailed(err)
+			return
+		}
+		conf.Certificates = []tls.Certificate{cert}
 	}
 
 	// setup Listener
 	listener, err := net.Listen("tcp", listen)
 	if err != nil {
 		events.Error(h.node, err.Error())
+		h.health.ListenFailed(err)
 		return
 	}
 
+	if adminMode {
+		verifier, err := certs.NewClientVerifier(h.ClientCAs, h.ClientPins)
+		if err != nil {
+			events.Error(h.node, err.Error())
+			h.health.ListenFailed(err)
+			listener.Close()
+			return
+		}
+		if verifier != nil {
+			verifier.OnReject = func(err error) {
+				events.Warning(h.node, "tls admin client rejected: "+err.Error())
+			}
+			verifier.Configure(conf)
+		}
+	}
+
 	// transform Listener into TLS Listener
-	tlsListener := tls.NewListener(
-		listener,
-		&tls.Config{Certificates: []tls.Certificate{cert}},
-	)
+	tlsListener := tls.NewListener(listener, conf)
 
 	// add Listener to the Listener pool
 	h.listeners = append(h.listeners, listener)
 	h.setIsRunning(true)
+	h.health.Listening(listener.Addr().String())
 
 	h.wg.Add(1)
 	go func() {
@@ -106,9 +182,26 @@ func (h *Module) Listen(listen string, adminMode bool) {
 	}()
 }
 
+// Health : reports whether the listener is up and how the last RPC call went
+func (h *Module) Health() api.ComponentHealth { return h.health.Report(h.Name()) }
+
+// Authorizer : returns the Authorizer for admin calls, or nil
+func (h *Module) Authorizer() *api.Authorizer { return h.Auth }
+
 func (h *Module) handleConnection(conn net.Conn, node api.Node, adminMode bool) {
 	defer conn.Close()
 
+	// the client certificate fingerprint is the credential for admin calls
+	var credential string
+	if tlsConn, ok := conn.(*tls.Conn); ok && adminMode {
+		if err := tlsConn.Handshake(); err != nil {
+			events.Warning(h.node, "tls handshake failed: "+err.Error())
+			return
+		}
+		state := tlsConn.ConnectionState()
+		credential = certs.PeerFingerprint(&state)
+	}
+
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
@@ -124,9 +217,13 @@ func (h *Module) handleConnection(conn net.Conn, node api.Node, adminMode bool)
 			break
 		}
 
+		a.Credential = credential
+
 		var result interface{}
 		if adminMode {
 			result, err = node.AdminRPC(h, *a)
+		} else if err = h.Limiter.Allow(conn.RemoteAddr().String(), a, len(*buf)); err != nil {
+			events.Warning(h.node, fmt.Sprintf("tls listen rejected call: %s (%d rejected)", err.Error(), h.Limiter.Rejected()))
 		} else {
 			result, err = node.PublicRPC(h, *a)
 		}
@@ -151,18 +248,31 @@ func (h *Module) handleConnection(conn net.Conn, node api.Node, adminMode bool)
 
 // RPC : client interface
 func (h *Module) RPC(host string, method api.Action, args ...interface{}) (interface{}, error) {
+	result, err := h.rpc(host, method, args...)
+	h.health.Record(err)
+	metrics.Default.Add(metrics.TransportCalls, 1, "transport", h.Name(), "action", method.String(), "result", metrics.Result(err))
+	return result, err
+}
+
+func (h *Module) rpc(host string, method api.Action, args ...interface{}) (interface{}, error) {
 	events.Info(h.node, fmt.Sprintf("\n***\n***RPC %d on %s called with: %+v\n***\n", method, host, args))
 
-	conn, ok := cachedSessions[host]
+	conn, ok := cachedSession(host)
 	if !ok {
 		var err error
-		conf := &tls.Config{InsecureSkipVerify: true}
+		conf := &tls.Config{InsecureSkipVerify: true, GetClientCertificate: h.clientCertificate}
 		conn, err = tls.Dial("tcp", host, conf)
 		if err != nil {
 			events.Error(h.node, err.Error())
 			return nil, err
 		}
-		cachedSessions[host] = conn
+		state := conn.ConnectionState()
+		if err := h.pins.Check(host, &state); err != nil {
+			events.Warning(h.node, "tls server "+host+" presented certificate "+certs.PeerFingerprint(&state)+", which is not the pinned one")
+			_ = conn.Close()
+			return nil, err
+		}
+		cacheSession(host, conn)
 	}
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
@@ -175,8 +285,7 @@ func (h *Module) RPC(host string, method api.Action, args ...interface{}) (inter
 	err := api.WriteBuffer(writer, rbytes)
 	if err != nil {
 		events.Warning(h.node, "tls RPC remote write failed: "+err.Error())
-		delete(cachedSessions, host) // something's wrong, make a new session next attempt
-		_ = conn.Close()
+		dropSession(host, conn) // something's wrong, make a new session next attempt
 		return nil, err
 	}
 	writer.Flush()
@@ -184,14 +293,12 @@ func (h *Module) RPC(host string, method api.Action, args ...interface{}) (inter
 	buf, err := api.ReadBuffer(reader)
 	if err != nil {
 		events.Warning(h.node, "tls RPC remote read failed: "+err.Error())
-		delete(cachedSessions, host) // something's wrong, make a new session next attempt
-		_ = conn.Close()
+		dropSession(host, conn) // something's wrong, make a new session next attempt
 		return nil, err
 	}
 	rr, err := api.RemoteResponseFromBytes(buf)
 	if err != nil {
-		delete(cachedSessions, host) // something's wrong, make a new session next attempt
-		_ = conn.Close()
+		dropSession(host, conn) // something's wrong, make a new session next attempt
 		events.Warning(h.node, "tls RPC decode failed: "+err.Error())
 		return nil, err
 	}
@@ -205,17 +312,44 @@ func (h *Module) RPC(host string, method api.Action, args ...interface{}) (inter
 	return rr.Value, nil
 }
 
+// PinCertificate : require host to present a certificate with this fingerprint, "" removes the pin
+func (h *Module) PinCertificate(host string, fingerprint string) {
+	if h.pins.Set(host, fingerprint) {
+		if conn, ok := cachedSession(host); ok { // the cached session was checked against the old pin
+			dropSession(host, conn)
+		}
+	}
+}
+
+// clientCertificate : the certificate presented when a server asks for one
+func (h *Module) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if h.Certs != nil {
+		return h.Certs.Certificate()
+	}
+	cert, err := tls.X509KeyPair(h.Cert, h.Key)
+	if err != nil {
+		return new(tls.Certificate), nil // no certificate to send
+	}
+	return &cert, nil
+}
+
 // Stop : stops the TLS transport from running
 func (h *Module) Stop() {
 	h.setIsRunning(false)
+	if h.Certs != nil {
+		h.Certs.Stop()
+	}
+	sessionsMux.Lock()
 	for k, v := range cachedSessions {
 		delete(cachedSessions, k)
 		_ = v.Close()
 	}
+	sessionsMux.Unlock()
 	for _, listener := range h.listeners {
 		listener.Close()
 	}
 	h.wg.Wait()
+	h.health.Stopped()
 }
 
 // IsRunning - returns true if this node is running
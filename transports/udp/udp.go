@@ -14,6 +14,8 @@ import (
 
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/metrics"
+	"github.com/awgh/ratnet/transports/limiter"
 )
 
 var cachedSessions map[string]*kcp.UDPSession
@@ -38,6 +40,10 @@ type Module struct {
 	isRunning uint32
 	wg        sync.WaitGroup
 	byteLimit int64
+	health    api.HealthTracker
+
+	// Limiter : rate limits public calls per source address and routing pubkey, nil for no limits
+	Limiter *limiter.Limiter
 }
 
 // Name : Returns name of module
@@ -60,9 +66,11 @@ func (m *Module) Listen(listen string, adminMode bool) {
 	lis, err := kcp.ListenWithOptions(listen, nil, 10, 0) // disabled FEC
 	if err != nil {
 		events.Error(m.node, err.Error())
+		m.health.ListenFailed(err)
 		return
 	}
 	m.setIsRunning(true)
+	m.health.Listening(lis.Addr().String())
 	m.wg.Add(1)
 
 	// read loop
@@ -105,6 +113,8 @@ func (m *Module) Listen(listen string, adminMode bool) {
 					var result interface{}
 					if adminMode {
 						result, err = m.node.AdminRPC(m, *a)
+					} else if err = m.Limiter.Allow(conn.RemoteAddr().String(), a, len(*buf)); err != nil {
+						events.Warning(m.node, fmt.Sprintf("udp listen rejected call: %s (%d rejected)", err.Error(), m.Limiter.Rejected()))
 					} else {
 						result, err = m.node.PublicRPC(m, *a)
 					}
@@ -130,8 +140,18 @@ func (m *Module) Listen(listen string, adminMode bool) {
 	}()
 }
 
+// Health : reports whether the listener is up and how the last RPC call went
+func (m *Module) Health() api.ComponentHealth { return m.health.Report(m.Name()) }
+
 // RPC : transmit data via UDP
 func (m *Module) RPC(host string, method api.Action, args ...interface{}) (interface{}, error) {
+	result, err := m.rpc(host, method, args...)
+	m.health.Record(err)
+	metrics.Default.Add(metrics.TransportCalls, 1, "transport", m.Name(), "action", method.String(), "result", metrics.Result(err))
+	return result, err
+}
+
+func (m *Module) rpc(host string, method api.Action, args ...interface{}) (interface{}, error) {
 	events.Info(m.node, fmt.Sprintf("\n***\n***RPC %d on %s called with: %+v\n***\n", method, host, args))
 
 	conn, ok := cachedSessions[host]
@@ -206,6 +226,7 @@ func (m *Module) Stop() {
 		_ = v.Close()
 	}
 	m.wg.Wait()
+	m.health.Stopped()
 }
 
 // IsRunning - returns true if this node is running
@@ -7,6 +7,7 @@ import (
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/transports/limiter"
 )
 
 func init() {
@@ -15,12 +16,16 @@ func init() {
 
 // NewFromMap : Makes a new instance of this transport module from a map of arguments (for deserialization support)
 func NewFromMap(node api.Node, t map[string]interface{}) api.Transport {
-	return New(node)
+	module := New(node)
+	module.Limiter = limiter.FromMap(t)
+	return module
 }
 
 // MarshalJSON : Create a serialied representation of the config of this module
 func (m *Module) MarshalJSON() (b []byte, e error) {
-	return json.Marshal(map[string]interface{}{
+	t := map[string]interface{}{
 		"Transport": "udp",
-	})
+	}
+	limiter.ToMap(m.Limiter, t)
+	return json.Marshal(t)
 }